@@ -0,0 +1,142 @@
+package panda
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Annotations are stored next to the archive file they describe, one line
+// per flagged record: the offset of the record in the file followed by its
+// flags (eg: 4096 duplicate,checksum).
+const AnnotationExt = ".ann"
+
+type Flag uint8
+
+const (
+	FlagDuplicate Flag = 1 << iota
+	FlagChecksum
+	FlagTime
+	FlagDecode
+)
+
+const FlagAll = FlagDuplicate | FlagChecksum | FlagTime | FlagDecode
+
+var flagNames = []struct {
+	Flag Flag
+	Name string
+}{
+	{FlagDuplicate, "duplicate"},
+	{FlagChecksum, "checksum"},
+	{FlagTime, "time"},
+	{FlagDecode, "decode"},
+}
+
+func ParseFlag(s string) (Flag, error) {
+	var f Flag
+	for _, n := range strings.Split(s, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if n == "all" {
+			f |= FlagAll
+			continue
+		}
+		var ok bool
+		for _, v := range flagNames {
+			if v.Name == n {
+				f, ok = f|v.Flag, true
+				break
+			}
+		}
+		if !ok {
+			return 0, fmt.Errorf("unknown flag %s", n)
+		}
+	}
+	return f, nil
+}
+
+func (f Flag) String() string {
+	var vs []string
+	for _, v := range flagNames {
+		if f&v.Flag == v.Flag {
+			vs = append(vs, v.Name)
+		}
+	}
+	return strings.Join(vs, ",")
+}
+
+func (f *Flag) Set(s string) error {
+	v, err := ParseFlag(s)
+	if err == nil {
+		*f = v
+	}
+	return err
+}
+
+func (f Flag) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *Flag) UnmarshalText(bs []byte) error {
+	return f.Set(string(bs))
+}
+
+type Annotations map[int64]Flag
+
+func LoadAnnotations(file string) (Annotations, error) {
+	f, err := os.Open(file + AnnotationExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	as := make(Annotations)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		vs := strings.Fields(s.Text())
+		if len(vs) != 2 {
+			continue
+		}
+		o, err := strconv.ParseInt(vs[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		g, err := ParseFlag(vs[1])
+		if err != nil {
+			return nil, err
+		}
+		as[o] |= g
+	}
+	return as, s.Err()
+}
+
+func (a Annotations) Save(file string) error {
+	file += AnnotationExt
+	if len(a) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	vs := make([]int64, 0, len(a))
+	for o := range a {
+		vs = append(vs, o)
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+
+	var buf bytes.Buffer
+	for _, o := range vs {
+		fmt.Fprintf(&buf, "%d %s\n", o, a[o])
+	}
+	return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
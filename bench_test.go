@@ -0,0 +1,137 @@
+package panda
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// benchPackets is the number of packets of the archive files and streams read
+// by the benchmarks.
+const benchPackets = 4096
+
+// writeArchive writes an archive file of n TM records and returns its name
+// and its size.
+func writeArchive(b *testing.B, n int) (string, int64) {
+	b.Helper()
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		bs, _ := testTelemetry(i, false).Bytes()
+		binary.Write(&buf, binary.LittleEndian, uint32(len(bs)+RecordHeaderTM))
+		buf.Write([]byte{TagTM, 0, 0, 0, 0, 0})
+		buf.Write(bs)
+	}
+	file := filepath.Join(b.TempDir(), "rt_00_04.dat")
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		b.Fatal(err)
+	}
+	return file, int64(buf.Len())
+}
+
+func drain(b *testing.B, rs *Reader, n int) {
+	b.Helper()
+	var count int
+	for {
+		_, err := rs.ReadPacket()
+		if err == ErrDone {
+			break
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		count++
+	}
+	if count != n {
+		b.Fatalf("want %d packets, got %d", n, count)
+	}
+}
+
+// BenchmarkReadAll measures the framing and decoding of the packets of an
+// archive file (walk) and of a stream of HRD frames (stream).
+func BenchmarkReadAll(b *testing.B) {
+	b.Run("walk", func(b *testing.B) {
+		file, size := writeArchive(b, benchPackets)
+		b.SetBytes(size)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			r, err := Walk("tm", file)
+			if err != nil {
+				b.Fatal(err)
+			}
+			drain(b, NewReader(r, DecodeTM()), benchPackets)
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		var buf bytes.Buffer
+		for i := 0; i < benchPackets; i++ {
+			bs, err := Frame(testTelemetry(i, false))
+			if err != nil {
+				b.Fatal(err)
+			}
+			buf.Write(bs)
+		}
+		b.SetBytes(int64(buf.Len()))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			r, err := Stream("tm", bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				b.Fatal(err)
+			}
+			drain(b, NewReader(r, DecodeTM()), benchPackets)
+		}
+	})
+}
+
+func benchmarkDecode(b *testing.B, d Decoder, p Packet) {
+	bs, err := p.Bytes()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(bs)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := d.Decode(bs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeTM(b *testing.B) {
+	b.Run("esa", func(b *testing.B) {
+		benchmarkDecode(b, DecodeTM(), testTelemetry(1, false))
+	})
+	b.Run("esa/crc", func(b *testing.B) {
+		benchmarkDecode(b, DecodeTM(WithChecksum(ChecksumFlag)), testTelemetry(1, false))
+	})
+	b.Run("pus", func(b *testing.B) {
+		benchmarkDecode(b, DecodeTMPUS(), testTelemetry(1, true))
+	})
+}
+
+func BenchmarkDecodePP(b *testing.B) {
+	benchmarkDecode(b, DecodePP(), testParameter(1))
+}
+
+func BenchmarkDecodeHR(b *testing.B) {
+	for _, v := range []int{VMUProtocol1, VMUProtocol2} {
+		d, err := DecodeHR(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+		name := "v1"
+		if v == VMUProtocol2 {
+			name = "v2"
+		}
+		b.Run(name+"/image", func(b *testing.B) {
+			benchmarkDecode(b, d, testHR(1, v, true))
+		})
+		b.Run(name+"/table", func(b *testing.B) {
+			benchmarkDecode(b, d, testHR(1, v, false))
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/busoc/panda"
+)
+
+type Query struct {
+	Apid     int
+	Codes    []uint64
+	Start    time.Time
+	End      time.Time
+	Filename string
+}
+
+func (q Query) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Apid  int       `json:"apid,omitempty"`
+		Codes []string  `json:"codes,omitempty"`
+		Start time.Time `json:"dtstart"`
+		End   time.Time `json:"dtend"`
+		Name  string    `json:"filename,omitempty"`
+	}{
+		Apid:  q.Apid,
+		Start: q.Start.UTC(),
+		End:   q.End.UTC(),
+		Name:  q.Filename,
+	}
+	for _, c := range q.Codes {
+		v.Codes = append(v.Codes, fmt.Sprintf("0x%012x", c))
+	}
+	return json.Marshal(v)
+}
+
+type Manifest struct {
+	Packets    int
+	Duplicates int
+	Files      int
+	Gaps       int
+}
+
+func readManifest(h http.Header) Manifest {
+	get := func(k string) int {
+		n, _ := strconv.Atoi(h.Get(k))
+		return n
+	}
+	return Manifest{
+		Packets:    get("X-Panda-Packets"),
+		Duplicates: get("X-Panda-Duplicates"),
+		Files:      get("X-Panda-Files"),
+		Gaps:       get("X-Panda-Gaps"),
+	}
+}
+
+type Client struct {
+	Addr string
+	Kind string
+
+	client *http.Client
+}
+
+func New(k, a string) (*Client, error) {
+	switch k {
+	default:
+		return nil, fmt.Errorf("unsupported: %s", k)
+	case "tm", "pp":
+	}
+	return &Client{Addr: a, Kind: k, client: http.DefaultClient}, nil
+}
+
+type Extraction struct {
+	io.ReadCloser
+	Manifest
+}
+
+func (c *Client) BlockingExtract(q Query) (*Extraction, error) {
+	return c.extract(context.Background(), q)
+}
+
+func (c *Client) Extract(q Query, w io.Writer) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	go func() {
+		defer close(j.done)
+		x, err := c.extract(ctx, q)
+		if err != nil {
+			j.err = err
+			return
+		}
+		defer x.Close()
+		j.manifest = x.Manifest
+		_, j.err = io.Copy(w, x)
+	}()
+	return j
+}
+
+func (c *Client) extract(ctx context.Context, q Query) (*Extraction, error) {
+	bs, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.Addr, bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+
+	rs, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	switch rs.StatusCode {
+	case http.StatusOK:
+		return &Extraction{ReadCloser: rs.Body, Manifest: readManifest(rs.Header)}, nil
+	case http.StatusNoContent:
+		rs.Body.Close()
+		return &Extraction{ReadCloser: ioutil.NopCloser(new(bytes.Buffer)), Manifest: readManifest(rs.Header)}, nil
+	default:
+		defer rs.Body.Close()
+		v := struct {
+			Err string `json:"error"`
+		}{}
+		if err := json.NewDecoder(rs.Body).Decode(&v); err != nil || len(v.Err) == 0 {
+			return nil, fmt.Errorf("extraction failed: %s", rs.Status)
+		}
+		return nil, fmt.Errorf("extraction failed: %s", v.Err)
+	}
+}
+
+type Job struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	manifest Manifest
+	err      error
+}
+
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+func (j *Job) Wait() (Manifest, error) {
+	<-j.done
+	return j.manifest, j.err
+}
+
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+func Reader(k string, r io.Reader) (io.Reader, error) {
+	switch k {
+	default:
+		return nil, fmt.Errorf("unsupported: %s", k)
+	case "tm", "pp":
+	}
+	return &reader{reader: bufio.NewReader(r), kind: k}, nil
+}
+
+type reader struct {
+	reader *bufio.Reader
+	kind   string
+}
+
+func (r *reader) Read(bs []byte) (int, error) {
+	var size int
+	switch r.kind {
+	case "tm":
+		h, err := r.reader.Peek(panda.CCSDSLength)
+		if err != nil {
+			return 0, err
+		}
+		size = panda.CCSDSLength + int(binary.BigEndian.Uint16(h[4:])) + 1
+	case "pp":
+		h, err := r.reader.Peek(panda.UMILength)
+		if err != nil {
+			return 0, err
+		}
+		size = panda.UMILength + int(binary.BigEndian.Uint16(h[panda.UMILength-2:]))
+	}
+	if len(bs) < size {
+		return 0, panda.ErrTooShort
+	}
+	return io.ReadFull(r.reader, bs[:size])
+}
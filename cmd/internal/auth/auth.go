@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/busoc/panda/cmd/internal/httpx"
+)
+
+var (
+	ErrRate  = errors.New("too many requests")
+	ErrQuota = errors.New("quota exceeded")
+)
+
+// Account is a client of the servers, identified by a static token (sent as
+// "Authorization: Bearer <token>" or, for websocket clients, as the token
+// parameter of the query) or by a user and password (basic auth).
+//
+// Rate limits the number of requests per minute and Quota the number of bytes
+// replayed per day (UTC). Zero means no limit. Admin accounts can also make
+// the requests reserved to the administration of the servers (see Admin).
+type Account struct {
+	Name     string `toml:"name" json:"name"`
+	Token    string `toml:"token" json:"token"`
+	User     string `toml:"user" json:"user"`
+	Password string `toml:"password" json:"password"`
+	Rate     int    `toml:"rate" json:"rate"`
+	Quota    int64  `toml:"quota" json:"quota"`
+	Admin    bool   `toml:"admin" json:"admin"`
+}
+
+type usage struct {
+	Account
+
+	mu       sync.Mutex
+	minute   time.Time
+	requests int
+	day      time.Time
+	bytes    int64
+}
+
+func (u *usage) admit(n time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if m := n.Truncate(time.Minute); !m.Equal(u.minute) {
+		u.minute, u.requests = m, 0
+	}
+	if u.Rate > 0 && u.requests >= u.Rate {
+		return ErrRate
+	}
+	u.requests++
+	u.reset(n)
+	if u.Quota > 0 && u.bytes >= u.Quota {
+		return ErrQuota
+	}
+	return nil
+}
+
+func (u *usage) use(n time.Time, c int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.reset(n)
+	if u.Quota > 0 && u.bytes+int64(c) > u.Quota {
+		return ErrQuota
+	}
+	u.bytes += int64(c)
+	return nil
+}
+
+func (u *usage) reset(n time.Time) {
+	if d := n.UTC().Truncate(time.Hour * 24); !d.Equal(u.day) {
+		u.day, u.bytes = d, 0
+	}
+}
+
+// Guard authenticates the requests made to the handlers it protects. A Guard
+// without accounts lets every request through.
+type Guard struct {
+	mu       sync.RWMutex
+	accounts map[string]*usage
+}
+
+func New(as []Account) (*Guard, error) {
+	var g Guard
+	return &g, g.Load(as)
+}
+
+// Load replaces the accounts of g. The usage of the accounts whose name is
+// unchanged is kept.
+func (g *Guard) Load(as []Account) error {
+	us := make(map[string]*usage)
+	for _, a := range as {
+		if a.Name == "" {
+			return fmt.Errorf("account without name")
+		}
+		if a.Token == "" && (a.User == "" || a.Password == "") {
+			return fmt.Errorf("%s: token or user and password required", a.Name)
+		}
+		if _, ok := us[a.Name]; ok {
+			return fmt.Errorf("%s: account defined more than once", a.Name)
+		}
+		us[a.Name] = &usage{Account: a}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for n, u := range us {
+		if p, ok := g.accounts[n]; ok {
+			p.mu.Lock()
+			u.minute, u.requests, u.day, u.bytes = p.minute, p.requests, p.day, p.bytes
+			p.mu.Unlock()
+		}
+	}
+	g.accounts = us
+	return nil
+}
+
+// Protect gives a handler only accepting the requests of the accounts named
+// in names, or of any account when names is empty.
+func (g *Guard) Protect(h http.Handler, names []string) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		g.mu.RLock()
+		empty := len(g.accounts) == 0
+		g.mu.RUnlock()
+		if empty {
+			h.ServeHTTP(w, r)
+			return
+		}
+		u := g.authenticate(r)
+		if u == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="panda"`)
+			httpx.Error(w, fmt.Errorf("authentication required"), http.StatusUnauthorized)
+			return
+		}
+		if !allowed(names, u.Name) {
+			httpx.Error(w, fmt.Errorf("%s not allowed", u.Name), http.StatusForbidden)
+			return
+		}
+		if err := u.admit(time.Now()); err != nil {
+			httpx.Error(w, err, http.StatusTooManyRequests)
+			return
+		}
+		httpx.SetAccount(r, u.Name)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accountKey, u)))
+	}
+	return http.HandlerFunc(f)
+}
+
+// Admin gives a handler only accepting the requests of the admin accounts.
+// Without accounts, only the requests coming from the loopback interface are
+// accepted.
+func (g *Guard) Admin(h http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		g.mu.RLock()
+		empty := len(g.accounts) == 0
+		g.mu.RUnlock()
+		if empty {
+			httpx.Loopback(h).ServeHTTP(w, r)
+			return
+		}
+		u := g.authenticate(r)
+		if u == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="panda"`)
+			httpx.Error(w, fmt.Errorf("authentication required"), http.StatusUnauthorized)
+			return
+		}
+		if !u.Admin {
+			httpx.Error(w, fmt.Errorf("%s not allowed", u.Name), http.StatusForbidden)
+			return
+		}
+		httpx.SetAccount(r, u.Name)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accountKey, u)))
+	}
+	return http.HandlerFunc(f)
+}
+
+func (g *Guard) authenticate(r *http.Request) *usage {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	user, passwd, basic := r.BasicAuth()
+	token := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	for _, u := range g.accounts {
+		switch {
+		case basic && u.User != "":
+			if equal(u.User, user) && equal(u.Password, passwd) {
+				return u
+			}
+		case token != "" && u.Token != "":
+			if equal(u.Token, token) {
+				return u
+			}
+		}
+	}
+	return nil
+}
+
+type key int
+
+const accountKey key = 0
+
+// Name gives the name of the account that made r, if any.
+func Name(r *http.Request) string {
+	if u, ok := r.Context().Value(accountKey).(*usage); ok {
+		return u.Name
+	}
+	return ""
+}
+
+// Use accounts n bytes sent to the client of r. It returns ErrQuota, without
+// accounting them, when they exceed the daily quota of its account.
+func Use(r *http.Request, n int) error {
+	if u, ok := r.Context().Value(accountKey).(*usage); ok {
+		return u.use(time.Now(), n)
+	}
+	return nil
+}
+
+func allowed(names []string, n string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, v := range names {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
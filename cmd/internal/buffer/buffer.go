@@ -3,59 +3,107 @@ package buffer
 import (
 	"bytes"
 	"compress/gzip"
-	"encoding/binary"
 	"fmt"
-	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/busoc/panda"
+	"github.com/busoc/panda/hrdp"
 )
 
+const Partial = ".part"
+
 type Buffer interface {
 	Write(panda.Packet) (int, int, error)
 	Flush(time.Time) error
 }
 
+type Option func(*flat)
+
+func WithTemplate(t *template.Template) Option {
+	return func(f *flat) {
+		f.naming = t
+	}
+}
+
+func WithRaw(raw bool) Option {
+	return func(f *flat) {
+		f.raw = raw
+	}
+}
+
+// WithFraming makes the buffer write packets preceded by the header of the HRD
+// frames (see panda.Frame) so that files can be replayed on multicast groups.
+// It has no effect in raw mode.
+func WithFraming(framed bool) Option {
+	return func(f *flat) {
+		f.framed = framed
+	}
+}
+
+func WithUTC(utc bool) Option {
+	return func(f *flat) {
+		f.utc = utc
+	}
+}
+
 type flat struct {
 	datadir string
 	prefix  string
 
 	compat   bool
 	compress bool
+	utc      bool
+	raw      bool
+	framed   bool
+	naming   *template.Template
 
 	count    uint64
 	sequence uint64
 	buf      *bytes.Buffer
 }
 
-func New(i, d string, c bool) Buffer {
-	return &flat{
+func New(i, d string, c bool, opts ...Option) Buffer {
+	return newFlat(i, d, c, opts...)
+}
+
+func newFlat(i, d string, c bool, opts ...Option) *flat {
+	f := &flat{
 		datadir: d,
 		compat:  c,
 		prefix:  i,
 		buf:     new(bytes.Buffer),
 	}
+	for _, o := range opts {
+		o(f)
+	}
+	return f
 }
 
 func (f *flat) Write(p panda.Packet) (int, int, error) {
-	bs, err := p.Bytes()
+	var (
+		bs  []byte
+		err error
+	)
+	switch {
+	case f.raw:
+		bs, err = panda.RawBytes(p)
+	case f.framed:
+		bs, err = panda.Frame(p)
+	default:
+		bs, err = p.Bytes()
+	}
 	if err != nil {
 		return int(atomic.LoadUint64(&f.count)), f.buf.Len(), err
 	}
-	if f.compat {
-		switch p.(type) {
-		case panda.Parameter:
-			binary.Write(f.buf, binary.LittleEndian, uint32(len(bs)))
-		case panda.Telemetry:
-			t := time.Now()
-			binary.Write(f.buf, binary.LittleEndian, uint32(len(bs)+6))
-			binary.Write(f.buf, binary.BigEndian, uint8(0x09))
-			binary.Write(f.buf, binary.BigEndian, uint32(t.Unix()))
-			binary.Write(f.buf, binary.BigEndian, uint8(0x09))
-		}
+	if f.compat && !f.raw && !f.framed {
+		bs, err = hrdp.Record(p, time.Now())
 	}
 	_, err = f.buf.Write(bs)
 	if err != nil {
@@ -77,25 +125,233 @@ func (f *flat) Flush(t time.Time) error {
 	if t.IsZero() {
 		t = time.Now()
 	}
-	n := fmt.Sprintf("%s_%06d_%06d_%s.dat", f.prefix, s, c, t.Add(panda.GPS.Sub(panda.UNIX)).Format("20060102_150405"))
-
-	w, err := os.Create(filepath.Join(f.datadir, n))
+	if !f.utc {
+		t = t.Add(panda.GPS.Sub(panda.UNIX))
+	}
+	n, err := f.filename(s, c, t)
 	if err != nil {
 		return err
 	}
-	defer w.Close()
-
-	var z io.Writer
+	n = filepath.Join(f.datadir, n)
+	if err := os.MkdirAll(filepath.Dir(n), 0755); err != nil {
+		return err
+	}
+	bs := f.buf.Bytes()
 	if f.compress {
-		g := gzip.NewWriter(w)
-		defer g.Close()
+		var buf bytes.Buffer
+		g := gzip.NewWriter(&buf)
+		if _, err := g.Write(bs); err != nil {
+			return err
+		}
+		if err := g.Close(); err != nil {
+			return err
+		}
+		bs = buf.Bytes()
+	}
+	return writeFile(n, bs)
+}
 
-		z = g
-	} else {
-		z = w
+// writeFile writes bs to a temporary file named after the final file and
+// the expected size and renames it once the content is on disk, so that
+// pollers never see a partial file and Recover can tell complete leftovers
+// from truncated ones.
+func writeFile(n string, bs []byte) error {
+	t := fmt.Sprintf("%s.%d%s", n, len(bs), Partial)
+	w, err := os.Create(t)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(bs); err == nil {
+		err = w.Sync()
 	}
-	if _, err := io.Copy(z, f.buf); err != nil {
+	if e := w.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(t)
 		return err
 	}
-	return nil
+	return os.Rename(t, n)
+}
+
+// Recover finalizes the temporary files left in d by an interrupted Flush
+// when they are complete and removes them otherwise. It returns the number
+// of files finalized and discarded.
+func Recover(d string) (int, int, error) {
+	var done, discard int
+	err := filepath.Walk(d, func(p string, i os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if i.IsDir() || !strings.HasSuffix(p, Partial) {
+			return nil
+		}
+		n := strings.TrimSuffix(p, Partial)
+		x := strings.LastIndex(n, ".")
+		if x < 0 {
+			return nil
+		}
+		s, err := strconv.ParseInt(n[x+1:], 10, 64)
+		if err != nil {
+			return nil
+		}
+		if s == i.Size() {
+			err = os.Rename(p, n[:x])
+			done++
+		} else {
+			err = os.Remove(p)
+			discard++
+		}
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	})
+	return done, discard, err
+}
+
+func (f *flat) filename(s, c uint64, t time.Time) (string, error) {
+	if f.naming == nil {
+		return fmt.Sprintf("%s_%06d_%06d_%s.dat", f.prefix, s, c, t.Format("20060102_150405")), nil
+	}
+	v := struct {
+		Prefix   string
+		Sequence uint64
+		Count    uint64
+		Time     time.Time
+	}{
+		Prefix:   f.prefix,
+		Sequence: s,
+		Count:    c,
+		Time:     t,
+	}
+	var buf bytes.Buffer
+	if err := f.naming.Execute(&buf, v); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type split struct {
+	datadir string
+	prefix  string
+	compat  bool
+	options []Option
+
+	key     func(panda.Packet) string
+	buffers map[string]*flat
+
+	count int
+	size  int
+}
+
+func Split(i, d string, c bool, k func(panda.Packet) string, opts ...Option) Buffer {
+	return &split{
+		datadir: d,
+		prefix:  i,
+		compat:  c,
+		options: opts,
+		key:     k,
+		buffers: make(map[string]*flat),
+	}
+}
+
+func (s *split) Write(p panda.Packet) (int, int, error) {
+	k := s.key(p)
+	b, ok := s.buffers[k]
+	if !ok {
+		b = newFlat(s.prefix+"_"+k, s.datadir, s.compat, s.options...)
+		s.buffers[k] = b
+	}
+	n := b.buf.Len()
+	_, c, err := b.Write(p)
+	if err != nil {
+		return s.count, s.size, err
+	}
+	s.count, s.size = s.count+1, s.size+c-n
+	return s.count, s.size, nil
+}
+
+func (s *split) Flush(t time.Time) error {
+	var err error
+	for _, b := range s.buffers {
+		if e := b.Flush(t); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.count, s.size = 0, 0
+	return err
+}
+
+type archive struct {
+	*hrdp.Archive
+
+	count int
+	size  int
+}
+
+// Archive gives a buffer appending the packets to the files of the HRDP
+// archive d, one file for every 5 minutes of reception (see hrdp.Archive), as
+// the records of compat mode. The reception time is the one of the envelope of
+// the packets read with it, the current time otherwise. Flush writes the
+// pending records and closes the file of the current slot.
+func Archive(d string) (Buffer, error) {
+	a, err := hrdp.Create(d)
+	if err != nil {
+		return nil, err
+	}
+	return &archive{Archive: a}, nil
+}
+
+func (a *archive) Write(p panda.Packet) (int, int, error) {
+	t, ok := panda.RecordTime(p)
+	if !ok {
+		t = time.Now()
+	}
+	bs, err := p.Bytes()
+	if err == nil {
+		err = a.AppendAt(p, t)
+	}
+	if err != nil {
+		return a.count, a.size, err
+	}
+	a.count, a.size = a.count+1, a.size+len(bs)
+	return a.count, a.size, nil
+}
+
+func (a *archive) Flush(_ time.Time) error {
+	a.count, a.size = 0, 0
+	return a.Close()
+}
+
+type Forwarder struct {
+	Buffer
+
+	conn   net.Conn
+	writer *panda.Writer
+}
+
+func Forward(b Buffer, k, a string) (*Forwarder, error) {
+	c, err := net.Dial("udp", a)
+	if err != nil {
+		return nil, err
+	}
+	w, err := panda.NewWriter(k, c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &Forwarder{Buffer: b, conn: c, writer: w}, nil
+}
+
+func (f *Forwarder) Write(p panda.Packet) (int, int, error) {
+	f.writer.Write(p)
+	return f.Buffer.Write(p)
+}
+
+func (f *Forwarder) Close() error {
+	return f.conn.Close()
 }
@@ -0,0 +1,211 @@
+package buffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/busoc/panda"
+)
+
+func testTM(i int) panda.Telemetry {
+	data := []byte{byte(i), 1, 2, 3, 4, 5, 6, 7}
+	return panda.Telemetry{
+		CCSDSHeader: panda.CCSDSHeader{
+			Pid:     1<<12 | 1<<11 | 0x0386,
+			Segment: 0xC000 | uint16(i),
+			Length:  uint16(panda.ESALength + len(data) - 1),
+		},
+		ESAHeader: panda.ESAHeader{Coarse: uint32(1200000000 + i), Fine: 128, Control: 0x01, Sid: 0x1234},
+		Data:      data,
+	}
+}
+
+func testPP(i int) panda.Parameter {
+	data := []byte{0, 0, 0, byte(i)}
+	return panda.Parameter{
+		UMIHeader: panda.UMIHeader{
+			State:  1,
+			Code:   [6]byte{0, 0, 0, 0, 0, byte(i)},
+			Type:   panda.Int32,
+			Unit:   1,
+			Coarse: uint32(1200000000 + i),
+			Length: uint16(len(data)),
+		},
+		Data: data,
+	}
+}
+
+func readAll(t *testing.T, kind, d string, dec panda.Decoder) [][]byte {
+	t.Helper()
+	r, err := panda.Walk(kind, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := panda.NewReader(r, dec)
+	var vs [][]byte
+	for {
+		p, err := rs.ReadPacket()
+		if err == panda.ErrDone {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, _ := p.Bytes()
+		vs = append(vs, bs)
+	}
+	return vs
+}
+
+func TestCompatTM(t *testing.T) {
+	d := t.TempDir()
+	b := New("tm", d, true)
+
+	before := time.Now().Unix()
+	var want [][]byte
+	for i := 0; i < 16; i++ {
+		p := testTM(i)
+		if _, _, err := b.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		bs, _ := p.Bytes()
+		want = append(want, bs)
+	}
+	after := time.Now().Unix()
+	if err := b.Flush(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, "tm", d, panda.DecodeTM())
+	if len(got) != len(want) {
+		t.Fatalf("packets: want %d, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !bytes.Equal(want[i], got[i]) {
+			t.Errorf("packet %d: want %x, got %x", i, want[i], got[i])
+		}
+	}
+
+	fs, _ := filepath.Glob(filepath.Join(d, "*.dat"))
+	if len(fs) != 1 {
+		t.Fatalf("files: want 1, got %d", len(fs))
+	}
+	bs, err := ioutil.ReadFile(fs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; len(bs) > 0; i++ {
+		n := int(binary.LittleEndian.Uint32(bs))
+		if z := len(want[i]) + panda.RecordHeaderTM; n != z {
+			t.Fatalf("record %d: want length %d, got %d", i, z, n)
+		}
+		h := bs[panda.RecordLengthSize:]
+		if h[0] != panda.TagTM {
+			t.Errorf("record %d: want tag %02x, got %02x", i, panda.TagTM, h[0])
+		}
+		if c := int64(binary.BigEndian.Uint32(h[1:])); c < before || c > after {
+			t.Errorf("record %d: coarse time %d not in [%d, %d]", i, c, before, after)
+		}
+		bs = bs[panda.RecordLengthSize+n:]
+	}
+}
+
+func TestCompatPP(t *testing.T) {
+	d := t.TempDir()
+	b := New("pp", d, true)
+
+	var want [][]byte
+	for i := 0; i < 16; i++ {
+		p := testPP(i)
+		if _, _, err := b.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		bs, _ := p.Bytes()
+		want = append(want, bs)
+	}
+	if err := b.Flush(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, "pp", d, panda.DecodePP())
+	if len(got) != len(want) {
+		t.Fatalf("packets: want %d, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !bytes.Equal(want[i], got[i]) {
+			t.Errorf("packet %d: want %x, got %x", i, want[i], got[i])
+		}
+	}
+}
+
+func BenchmarkFlush(b *testing.B) {
+	for _, c := range []struct {
+		Name     string
+		Compat   bool
+		Compress bool
+	}{
+		{"plain", false, false},
+		{"compat", true, false},
+		{"gzip", false, true},
+	} {
+		b.Run(c.Name, func(b *testing.B) {
+			f := newFlat("tm", b.TempDir(), c.Compat)
+			f.compress = c.Compress
+
+			var size int
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < 1024; j++ {
+					if _, size, _ = f.Write(testTM(j)); size == 0 {
+						b.Fatal("packet not buffered")
+					}
+				}
+				if err := f.Flush(time.Time{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(size))
+		})
+	}
+}
+
+func TestArchive(t *testing.T) {
+	d := t.TempDir()
+	b, err := Archive(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want [][]byte
+	for i := 0; i < 16; i++ {
+		p := testTM(i)
+		c, _, err := b.Write(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != i+1 {
+			t.Errorf("packet %d: want count %d, got %d", i, i+1, c)
+		}
+		bs, _ := p.Bytes()
+		want = append(want, bs)
+	}
+	if err := b.Flush(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if fs, _ := filepath.Glob(filepath.Join(d, "*", "*", "*", "rt_*.dat")); len(fs) == 0 {
+		t.Fatalf("no file written in the archive layout")
+	}
+	got := readAll(t, "tm", d, panda.DecodeTM())
+	if len(got) != len(want) {
+		t.Fatalf("packets: want %d, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !bytes.Equal(want[i], got[i]) {
+			t.Errorf("packet %d: want %x, got %x", i, want[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package dump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/busoc/panda"
+)
+
+// inline is the largest number of bytes printed on the same line as the
+// label of their field.
+const inline = 8
+
+type field struct {
+	Label string
+	Start int
+	End   int
+	Value string
+}
+
+func Telemetry(w io.Writer, p panda.Telemetry) error {
+	bs, err := p.Bytes()
+	if err != nil {
+		return err
+	}
+	c, e := p.CCSDSHeader, p.ESAHeader
+
+	n := len(bs)
+	fs := []field{
+		{"ccsds.pid", 0, 2, fmt.Sprintf("apid %d", c.Apid())},
+		{"ccsds.segment", 2, 4, fmt.Sprintf("%s, sequence %d", c.SegmentationFlag(), c.Sequence())},
+		{"ccsds.length", 4, 6, fmt.Sprintf("%d bytes", c.Len())},
+	}
+	if h := p.PUS; h != nil {
+		fs = append(fs, []field{
+			{"pus.version", 6, 7, fmt.Sprint(h.Version)},
+			{"pus.service", 7, 8, fmt.Sprint(h.Service)},
+			{"pus.subtype", 8, 9, fmt.Sprint(h.Subtype)},
+			{"pus.dest", 9, 10, fmt.Sprint(h.Destination)},
+			{"pus.coarse", 10, 14, fmt.Sprint(h.Coarse)},
+			{"pus.fine", 14, 16, fmt.Sprint(h.Fine)},
+		}...)
+	} else {
+		fs = append(fs, []field{
+			{"esa.coarse", 6, 10, fmt.Sprint(e.Coarse)},
+			{"esa.fine", 10, 11, fmt.Sprint(e.Fine)},
+			{"esa.control", 11, 12, fmt.Sprintf("type %s, time %s, checksum %t, zoe %t", e.PacketType(), e.PacketTime(), e.Sum(), e.ZOE())},
+			{"esa.sid", 12, 16, fmt.Sprint(e.Sid)},
+		}...)
+	}
+	if e.Sum() && n-2 >= panda.CCSDSLength+panda.ESALength {
+		v := "valid"
+		if !p.Verify() {
+			v = "invalid"
+		}
+		fs = append(fs, field{"data", 16, n - 2, ""}, field{"checksum", n - 2, n, v})
+	} else {
+		fs = append(fs, field{"data", 16, n, ""})
+	}
+	return write(w, bs, fs)
+}
+
+func Parameter(w io.Writer, p panda.Parameter) error {
+	bs, err := p.Bytes()
+	if err != nil {
+		return err
+	}
+	u := p.UMIHeader
+	fs := []field{
+		{"umi.state", 0, 1, u.State.String()},
+		{"umi.orbit", 1, 5, fmt.Sprint(binary.BigEndian.Uint32(u.Orbit[:]))},
+		{"umi.code", 5, 11, fmt.Sprintf("%x", u.Code)},
+		{"umi.type", 11, 12, u.Type.String()},
+		{"umi.unit", 12, 14, fmt.Sprint(u.Unit)},
+		{"umi.coarse", 14, 18, fmt.Sprint(u.Coarse)},
+		{"umi.fine", 18, 19, fmt.Sprint(u.Fine)},
+		{"umi.length", 19, 21, fmt.Sprintf("%d bytes", u.Length)},
+		{"data", 21, len(bs), fmt.Sprint(p.Value())},
+	}
+	return write(w, bs, fs)
+}
+
+func write(w io.Writer, bs []byte, fs []field) error {
+	for _, f := range fs {
+		if f.End > len(bs) {
+			f.End = len(bs)
+		}
+		if f.Start >= f.End {
+			continue
+		}
+		vs := bs[f.Start:f.End]
+		x := fmt.Sprintf("% x", vs)
+		if len(vs) > inline {
+			x = fmt.Sprintf("%d bytes", len(vs))
+		}
+		line := fmt.Sprintf("%04x-%04x | %-13s | %-23s | %s", f.Start, f.End-1, f.Label, x, f.Value)
+		if _, err := fmt.Fprintln(w, strings.TrimSuffix(line, " | ")); err != nil {
+			return err
+		}
+		if len(vs) <= inline {
+			continue
+		}
+		for i := 0; i < len(vs); i += 16 {
+			j := i + 16
+			if j > len(vs) {
+				j = len(vs)
+			}
+			if _, err := fmt.Fprintf(w, "          | %04x          | % x\n", f.Start+i, vs[i:j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
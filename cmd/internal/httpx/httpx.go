@@ -0,0 +1,377 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const RequestHeader = "X-Request-Id"
+
+type key int
+
+const (
+	requestKey key = iota
+	clientKey
+	logKey
+)
+
+type Middleware func(http.Handler) http.Handler
+
+func Chain(h http.Handler, ms ...Middleware) http.Handler {
+	for i := len(ms) - 1; i >= 0; i-- {
+		h = ms[i](h)
+	}
+	return h
+}
+
+// Wrap gives h with the request ids, the access log, the recovery of panics
+// and the CORS policy of origins. forwarded, the middleware given by Forwarded,
+// can be nil when no proxy is trusted.
+func Wrap(h http.Handler, origins []string, forwarded Middleware) http.Handler {
+	ms := []Middleware{RequestID}
+	if forwarded != nil {
+		ms = append(ms, forwarded)
+	}
+	return Chain(h, append(ms, Log, Recover, CORS(origins))...)
+}
+
+func Error(w http.ResponseWriter, err error, code int) {
+	v := struct {
+		Err     string `json:"error"`
+		Code    int    `json:"code"`
+		Request string `json:"request,omitempty"`
+	}{
+		Err:     err.Error(),
+		Code:    code,
+		Request: w.Header().Get(RequestHeader),
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func Audit(file string) (*log.Logger, error) {
+	if len(file) == 0 {
+		return nil, nil
+	}
+	w, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return log.New(w, "", log.LstdFlags|log.LUTC), nil
+}
+
+func ID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestKey).(string); ok {
+		return id
+	}
+	return r.Header.Get(RequestHeader)
+}
+
+// Client gives the address of the client that made r: the one given by the
+// trusted proxies (see Forwarded) or the remote address of r.
+func Client(r *http.Request) string {
+	if addr, ok := r.Context().Value(clientKey).(string); ok {
+		return addr
+	}
+	return remoteHost(r)
+}
+
+func remoteHost(r *http.Request) string {
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return h
+	}
+	return r.RemoteAddr
+}
+
+// Forwarded gives a middleware making Client take the address of the clients
+// from the X-Forwarded-For header of the requests coming from proxies, given
+// as IP addresses or CIDR blocks. The header is ignored for the other
+// requests.
+func Forwarded(proxies []string) (Middleware, error) {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %s", p)
+			}
+			if ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	trusted := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		for _, n := range nets {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	m := func(h http.Handler) http.Handler {
+		f := func(w http.ResponseWriter, r *http.Request) {
+			addr := remoteHost(r)
+			if !trusted(addr) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			// the proxies append the address of their peer: the client is the
+			// last address not given by a trusted proxy.
+			fs := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(fs) - 1; i >= 0 && trusted(addr); i-- {
+				if a := strings.TrimSpace(fs[i]); len(a) > 0 {
+					addr = a
+				}
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey, addr)))
+		}
+		return http.HandlerFunc(f)
+	}
+	return m, nil
+}
+
+// SetAccount gives the name of the account that made r to the access log
+// written by Log.
+func SetAccount(r *http.Request, name string) {
+	if e, ok := r.Context().Value(logKey).(*entry); ok {
+		e.account = name
+	}
+}
+
+type entry struct {
+	account string
+}
+
+func RequestID(h http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestHeader)
+		if len(id) == 0 || len(id) > 64 {
+			id = newID()
+		}
+		w.Header().Set(RequestHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestKey, id)))
+	}
+	return http.HandlerFunc(f)
+}
+
+func newID() string {
+	bs := make([]byte, 8)
+	if _, err := rand.Read(bs); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%x", bs)
+}
+
+// CORS allows the requests coming from origins, any origin when empty or
+// holding "*". Credentials are only allowed for the origins listed explicitly:
+// their requests get their own origin back instead of "*".
+func CORS(origins []string) Middleware {
+	return func(h http.Handler) http.Handler {
+		f := func(w http.ResponseWriter, r *http.Request) {
+			if o := allowOrigin(origins, r.Header.Get("Origin")); len(o) > 0 {
+				w.Header().Set("Access-Control-Allow-Origin", o)
+				if o != "*" {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+					w.Header().Add("Vary", "Origin")
+				}
+				if h := r.Header.Get("Access-Control-Request-Headers"); len(h) > 0 {
+					w.Header().Set("Access-Control-Allow-Headers", h)
+				}
+			}
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(f)
+	}
+}
+
+// allowOrigin gives o when it is listed in origins, "*" when any origin is
+// allowed and an empty string otherwise.
+func allowOrigin(origins []string, o string) string {
+	wild := len(origins) == 0
+	for _, v := range origins {
+		if len(o) > 0 && v == o {
+			return o
+		}
+		wild = wild || v == "*"
+	}
+	if wild {
+		return "*"
+	}
+	return ""
+}
+
+func Recover(h http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("id=%s method=%s path=%q: panic: %v", ID(r), r.Method, r.URL.Path, err)
+			Error(w, fmt.Errorf("internal server error"), http.StatusInternalServerError)
+		}()
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(f)
+}
+
+func Log(h http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		n := time.Now()
+		x := &writer{ResponseWriter: w}
+		e := new(entry)
+		defer func() {
+			log.Printf("id=%s addr=%s account=%s method=%s path=%q status=%d size=%d duration=%s", ID(r), Client(r), e.account, r.Method, requestURI(r), x.Code(), x.size, time.Since(n))
+		}()
+		h.ServeHTTP(x, r.WithContext(context.WithValue(r.Context(), logKey, e)))
+	}
+	return http.HandlerFunc(f)
+}
+
+// requestURI gives the URI of r without the token that the websocket clients
+// give in its query.
+func requestURI(r *http.Request) string {
+	u := *r.URL
+	if q := u.Query(); len(q["token"]) > 0 {
+		q.Del("token")
+		u.RawQuery = q.Encode()
+	}
+	return u.RequestURI()
+}
+
+type writer struct {
+	http.ResponseWriter
+	code int
+	size int
+}
+
+func (w *writer) Code() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func (w *writer) WriteHeader(c int) {
+	if w.code == 0 {
+		w.code = c
+	}
+	w.ResponseWriter.WriteHeader(c)
+}
+
+func (w *writer) Write(bs []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(bs)
+	w.size += n
+	return n, err
+}
+
+func (w *writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking not supported")
+	}
+	if w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+const ProvenanceHeader = "X-Panda-Provenance"
+
+// Origin tells where the packets of the slice of an archive starting at Start
+// come from: "local" or the address of the peer that sent them.
+type Origin struct {
+	Start  time.Time
+	Source string
+}
+
+type Provenance []Origin
+
+func (p Provenance) String() string {
+	vs := make([]string, len(p))
+	for i, o := range p {
+		vs[i] = o.Start.UTC().Format(time.RFC3339) + "=" + o.Source
+	}
+	return strings.Join(vs, ", ")
+}
+
+// Fetch posts v encoded as JSON to addr with c, the default client when nil,
+// and gives the body of the response. A nil body is returned when addr has no
+// content to send, whether it answers 204 or 200 with an empty body.
+func Fetch(ctx context.Context, c *http.Client, addr string, v interface{}) (io.ReadCloser, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, addr, bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+
+	if c == nil {
+		c = http.DefaultClient
+	}
+	rs, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	switch rs.StatusCode {
+	case http.StatusOK:
+		r := bufio.NewReader(rs.Body)
+		if _, err := r.Peek(1); err != nil {
+			rs.Body.Close()
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%s: %s", req.URL.Redacted(), err)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{r, rs.Body}, nil
+	case http.StatusNoContent:
+		rs.Body.Close()
+		return nil, nil
+	default:
+		rs.Body.Close()
+		return nil, fmt.Errorf("%s: %s", req.URL.Redacted(), rs.Status)
+	}
+}
@@ -0,0 +1,149 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	const origin = "https://panda.example.org"
+
+	data := []struct {
+		Name        string
+		Origins     []string
+		Origin      string
+		Allow       string
+		Credentials bool
+	}{
+		{"default", nil, origin, "*", false},
+		{"wildcard", []string{"*"}, origin, "*", false},
+		{"listed", []string{"https://other.example.org", origin}, origin, origin, true},
+		{"listed with wildcard", []string{"*", origin}, origin, origin, true},
+		{"not listed", []string{"https://other.example.org"}, origin, "", false},
+		{"no origin", []string{origin}, "", "", false},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, d := range data {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if d.Origin != "" {
+			r.Header.Set("Origin", d.Origin)
+		}
+		w := httptest.NewRecorder()
+		CORS(d.Origins)(ok).ServeHTTP(w, r)
+
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != d.Allow {
+			t.Errorf("%s: want allowed origin %q, got %q", d.Name, d.Allow, got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials") == "true"; got != d.Credentials {
+			t.Errorf("%s: want credentials %t, got %t", d.Name, d.Credentials, got)
+		}
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	fwd, err := Forwarded([]string{"10.0.0.1", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		Name   string
+		Remote string
+		Header []string
+		Want   string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrusted proxy", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed header", "10.0.0.1:4000", []string{"127.0.0.1, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.0.0.1:4000", []string{"198.51.100.1, 192.168.1.1", "192.168.2.1"}, "198.51.100.1"},
+		{"trusted proxy without header", "10.0.0.1:4000", nil, "10.0.0.1"},
+	}
+	for _, d := range data {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = d.Remote
+		for _, h := range d.Header {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		var got string
+		fwd(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = Client(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		if got != d.Want {
+			t.Errorf("%s: want client %s, got %s", d.Name, d.Want, got)
+		}
+	}
+	if _, err := Forwarded([]string{"proxy.example.org"}); err == nil {
+		t.Errorf("invalid proxy address accepted")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	data := []struct {
+		Name string
+		Code int
+		Body string
+		Err  bool
+	}{
+		{"content", http.StatusOK, "packets", false},
+		{"empty", http.StatusOK, "", false},
+		{"no content", http.StatusNoContent, "", false},
+		{"failure", http.StatusInternalServerError, "", true},
+	}
+	for _, d := range data {
+		d := d
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(d.Code)
+			w.Write([]byte(d.Body))
+		}))
+		rc, err := Fetch(context.Background(), s.Client(), s.URL, struct{}{})
+		s.Close()
+		if d.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", d.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", d.Name, err)
+			continue
+		}
+		if d.Body == "" {
+			if rc != nil {
+				t.Errorf("%s: want no body", d.Name)
+			}
+			continue
+		}
+		bs, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(bs) != d.Body {
+			t.Errorf("%s: want body %q, got %q (%v)", d.Name, d.Body, bs, err)
+		}
+	}
+}
+
+func TestLogToken(t *testing.T) {
+	const token = "s3cr3t-t0k3n"
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest(http.MethodGet, "/realtime/tm?apid=902&token="+token, nil)
+	Log(ok).ServeHTTP(httptest.NewRecorder(), r)
+
+	line := buf.String()
+	if strings.Contains(line, token) {
+		t.Errorf("token written to the access log: %s", line)
+	}
+	if !strings.Contains(line, "/realtime/tm?apid=902") {
+		t.Errorf("request not written to the access log: %s", line)
+	}
+}
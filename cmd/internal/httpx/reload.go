@@ -0,0 +1,104 @@
+package httpx
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Reloader serves the handler built by its load function, building it again
+// each time the process receives SIGHUP or a POST request is made to its path.
+// Requests in progress, websocket sessions included, keep being served by the
+// handler they started with. The current handler is kept when loading fails.
+//
+// The requests made to its path go through the guard given to Reload, only
+// the ones coming from the loopback interface being accepted without guard.
+type Reloader struct {
+	path  string
+	load  func() (http.Handler, error)
+	admin http.Handler
+
+	reload  sync.Mutex
+	mu      sync.RWMutex
+	handler http.Handler
+}
+
+func Reload(path string, load func() (http.Handler, error), guard Middleware) (*Reloader, error) {
+	if guard == nil {
+		guard = Loopback
+	}
+	r := &Reloader{path: path, load: load}
+	r.admin = guard(http.HandlerFunc(r.serveReload))
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			if err := r.Reload(); err != nil {
+				log.Printf("reload: %s", err)
+			} else {
+				log.Printf("reload: configuration reloaded")
+			}
+		}
+	}()
+	return r, nil
+}
+
+func (r *Reloader) Reload() error {
+	r.reload.Lock()
+	defer r.reload.Unlock()
+	h, err := r.load()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.handler = h
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == r.path && req.Method == http.MethodPost {
+		r.admin.ServeHTTP(w, req)
+		return
+	}
+	r.mu.RLock()
+	h := r.handler
+	r.mu.RUnlock()
+	h.ServeHTTP(w, req)
+}
+
+// serveReload reloads the handler. The reason of a failure is only logged, the
+// configuration files being none of the client's business.
+func (r *Reloader) serveReload(w http.ResponseWriter, req *http.Request) {
+	if err := r.Reload(); err != nil {
+		log.Printf("id=%s: reload: %s", ID(req), err)
+		Error(w, fmt.Errorf("configuration not reloaded"), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("id=%s: reload: configuration reloaded", ID(req))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Loopback only lets through the requests coming from the loopback interface.
+func Loopback(h http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		addr, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			addr = r.RemoteAddr
+		}
+		if ip := net.ParseIP(addr); ip == nil || !ip.IsLoopback() {
+			Error(w, fmt.Errorf("forbidden"), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(f)
+}
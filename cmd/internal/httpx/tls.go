@@ -0,0 +1,101 @@
+package httpx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// TLS gives the certificate and key a server uses to serve HTTPS and, when
+// clients have to authenticate with a certificate, the CA their certificates
+// are signed by. A server without certificate serves plain HTTP.
+type TLS struct {
+	Cert string `toml:"cert" json:"cert"`
+	Key  string `toml:"key" json:"key"`
+	CA   string `toml:"ca" json:"ca"`
+}
+
+func (t TLS) Config() (*tls.Config, error) {
+	if t.Cert == "" && t.Key == "" {
+		if t.CA != "" {
+			return nil, fmt.Errorf("tls: ca given without certificate")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %s", err)
+	}
+	c := &tls.Config{
+		Certificates:     []tls.Certificate{cert},
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
+	}
+	if t.CA != "" {
+		bs, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("tls: no certificate found in %s", t.CA)
+		}
+		c.ClientCAs, c.ClientAuth = pool, tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// Server gives a server for h listening on addr, over TLS when t has a
+// certificate.
+func Server(addr string, h http.Handler, t TLS) (*http.Server, error) {
+	c, err := t.Config()
+	if err != nil {
+		return nil, err
+	}
+	s := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		TLSConfig:         c,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// Client gives the client used by a server configured with t to query its
+// peers: it presents the certificate of t and trusts the peers whose
+// certificate is signed by the CA of t, the one it requires from its own
+// clients. The default client is given when t has no certificate.
+func (t TLS) Client() (*http.Client, error) {
+	c, err := t.Config()
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return http.DefaultClient, nil
+	}
+	c.RootCAs, c.ClientCAs, c.ClientAuth = c.ClientCAs, nil, tls.NoClientCert
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = c
+	return &http.Client{Transport: tr}, nil
+}
+
+// ListenAndServe serves HTTPS when s has a TLS configuration and HTTP
+// otherwise.
+func ListenAndServe(s *http.Server) error {
+	if s.TLSConfig != nil {
+		return s.ListenAndServeTLS("", "")
+	}
+	return s.ListenAndServe()
+}
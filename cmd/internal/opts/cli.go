@@ -8,6 +8,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/busoc/panda/cmd/internal/pp"
 )
 
 type Gap struct {
@@ -97,7 +99,7 @@ func (i *SIDSet) String() string {
 	return fmt.Sprint(*i)
 }
 
-type UMISet []uint64
+type UMISet []pp.Code
 
 func (i *UMISet) Set(vs string) error {
 	if f, err := os.Open(vs); err == nil {
@@ -127,10 +129,10 @@ func (i *UMISet) String() string {
 }
 
 func (i *UMISet) parse(v string) error {
-	n, err := strconv.ParseUint(v, 0, 64)
+	c, err := pp.ParseCode(v)
 	if err != nil {
 		return err
 	}
-	*i = append(*i, uint64(n))
+	*i = append(*i, c)
 	return nil
 }
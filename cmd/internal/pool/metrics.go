@@ -0,0 +1,43 @@
+package pool
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+func Metrics(p *Pool, prefix string) http.Handler {
+	metrics := []struct {
+		Name  string
+		Type  string
+		Value func(State) interface{}
+	}{
+		{"packets", "gauge", func(s State) interface{} { return s.Count }},
+		{"bytes", "gauge", func(s State) interface{} { return s.Size }},
+		{"errors_total", "counter", func(s State) interface{} { return s.Errors }},
+		{"skipped_total", "counter", func(s State) interface{} { return s.Skipped }},
+		{"failures_total", "counter", func(s State) interface{} { return s.Failures }},
+		{"socket_drops_total", "counter", func(s State) interface{} { return s.Dropped }},
+		{"socket_short_total", "counter", func(s State) interface{} { return s.Short }},
+		{"running", "gauge", func(s State) interface{} {
+			if s.Running {
+				return 1
+			}
+			return 0
+		}},
+	}
+	f := func(w http.ResponseWriter, r *http.Request) {
+		vs := p.Status()
+		sort.Slice(vs, func(i, j int) bool { return vs[i].Id < vs[j].Id })
+
+		w.Header().Set("content-type", "text/plain; version=0.0.4")
+		for _, m := range metrics {
+			n := prefix + "_" + m.Name
+			fmt.Fprintf(w, "# TYPE %s %s\n", n, m.Type)
+			for _, s := range vs {
+				fmt.Fprintf(w, "%s{worker=%q} %v\n", n, s.Id, m.Value(s))
+			}
+		}
+	}
+	return http.HandlerFunc(f)
+}
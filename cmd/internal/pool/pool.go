@@ -8,7 +8,11 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/buffer"
 )
 
 var (
@@ -24,11 +28,72 @@ type Worker interface {
 }
 
 type State struct {
-	Id      string    `json:"worker"`
-	Count   int       `json:"count"`
-	Size    int       `json:"size"`
-	Running bool      `json:"running"`
-	Last    time.Time `json:"last"`
+	Id       string    `json:"worker"`
+	Count    int       `json:"count"`
+	Size     int       `json:"size"`
+	Running  bool      `json:"running"`
+	Last     time.Time `json:"last"`
+	Errors   int       `json:"errors"`
+	Skipped  int       `json:"skipped"`
+	Dropped  int       `json:"dropped"`
+	Failures int       `json:"failures"`
+	Short    int       `json:"short"`
+}
+
+type Counter struct {
+	errors   uint64
+	skipped  uint64
+	failures uint64
+}
+
+func (c *Counter) Decoder(d panda.Decoder) panda.Decoder {
+	f := func(bs []byte) (int, panda.Packet, error) {
+		n, p, err := d.Decode(bs)
+		switch err {
+		case nil:
+		case panda.ErrSkip:
+			atomic.AddUint64(&c.skipped, 1)
+		default:
+			atomic.AddUint64(&c.errors, 1)
+			err = panda.ErrSkip
+		}
+		return n, p, err
+	}
+	return panda.DecoderFunc(f)
+}
+
+func (c *Counter) Skip() {
+	atomic.AddUint64(&c.skipped, 1)
+}
+
+func (c *Counter) Fail() {
+	atomic.AddUint64(&c.failures, 1)
+}
+
+func (c *Counter) Update(s State, r io.Reader) State {
+	s.Errors = int(atomic.LoadUint64(&c.errors))
+	s.Skipped = int(atomic.LoadUint64(&c.skipped))
+	s.Failures = int(atomic.LoadUint64(&c.failures))
+	if d, ok := r.(interface{ Drops() (uint64, error) }); ok {
+		if n, err := d.Drops(); err == nil {
+			s.Dropped = int(n)
+		}
+	}
+	if d, ok := r.(interface{ Short() uint64 }); ok {
+		s.Short = int(d.Short())
+	}
+	return s
+}
+
+func Recover(d string) error {
+	c, r, err := buffer.Recover(d)
+	if err != nil {
+		return err
+	}
+	if c > 0 || r > 0 {
+		log.Printf("%s: %d leftover file(s) finalized, %d discarded", d, c, r)
+	}
+	return nil
 }
 
 type worker struct {
@@ -112,6 +177,9 @@ func (p *Pool) check() error {
 }
 
 func (p *Pool) Run(a bool) error {
+	if err := Recover(p.Datadir); err != nil {
+		return err
+	}
 	run := func(w Worker, wg *sync.WaitGroup) {
 		if err := w.Run(p.Addr, p.Datadir, p.Compat); err != nil {
 			log.Printf("%s: %s", w.String(), err)
@@ -1,91 +1,299 @@
 package pp
 
 import (
-	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/busoc/panda"
+	"github.com/busoc/panda/hrdp"
 )
 
-func Open(a string) (io.Reader, error) {
+func Open(a string, opts ...panda.WalkOption) (io.Reader, error) {
+	return OpenContext(context.Background(), a, opts...)
+}
+
+func OpenContext(ctx context.Context, a string, opts ...panda.WalkOption) (io.Reader, error) {
+	if a == "-" {
+		return panda.Stream("pp", os.Stdin)
+	}
 	if _, _, err := net.SplitHostPort(a); err == nil {
-		return panda.Listen("pp", a)
+		return panda.ListenContext(ctx, "pp", a)
+	}
+	return panda.WalkContext(ctx, "pp", a, opts...)
+}
+
+// OpenBetween is like Open but only reads the files of the archive a covering
+// [start, end) when a is a directory. A zero end stands for now; a zero start
+// reads the whole archive.
+func OpenBetween(a string, start, end time.Time, opts ...panda.WalkOption) (io.Reader, error) {
+	if i, err := os.Stat(a); start.IsZero() || err != nil || !i.IsDir() {
+		return Open(a, opts...)
+	}
+	return hrdp.NewReader("pp", a, start, end, opts...)
+}
+
+func OpenRaw(a string, opts ...panda.WalkOption) (io.Reader, int, error) {
+	var (
+		r   io.Reader
+		n   = panda.FramePP
+		err error
+	)
+	if a == "-" {
+		r, err = panda.StreamRaw("pp", os.Stdin)
+	} else if _, _, e := net.SplitHostPort(a); e == nil {
+		r, err = panda.ListenRaw("pp", a)
+	} else {
+		r, err = panda.WalkRaw("pp", a, opts...)
+		n = panda.RecordLengthSize
 	}
-	return panda.Walk("pp", a)
+	return r, n, err
+}
+
+func Watch(a string, opts ...panda.WalkOption) (io.Reader, error) {
+	return panda.Watch("pp", a, opts...)
+}
+
+func WatchRaw(a string, opts ...panda.WalkOption) (io.Reader, int, error) {
+	r, err := panda.WatchRaw("pp", a, opts...)
+	return r, panda.RecordLengthSize, err
 }
 
 func Filter(r io.Reader, d panda.Decoder) <-chan panda.Parameter {
+	return FilterContext(context.Background(), r, d)
+}
+
+func FilterContext(ctx context.Context, r io.Reader, d panda.Decoder, opts ...panda.ReaderOption) <-chan panda.Parameter {
+	return FilterReport(ctx, r, d, nil, opts...)
+}
+
+// FilterReport is like FilterContext but gives the packets that could not be
+// decoded to report before skipping them.
+func FilterReport(ctx context.Context, r io.Reader, d panda.Decoder, report func(error), opts ...panda.ReaderOption) <-chan panda.Parameter {
 	q := make(chan panda.Parameter)
 	go func() {
-		source := panda.NewReader(r, d)
+		source := panda.NewReaderContext(ctx, r, d, opts...)
 		defer func() {
 			close(q)
 			source.Close()
 		}()
 		for {
-			p, err := source.Read()
+			p, err := source.ReadPacket()
 			switch err {
 			case nil:
 				if p, ok := p.(panda.Parameter); ok {
-					q <- p
+					select {
+					case q <- p:
+					case <-ctx.Done():
+						return
+					}
 				}
 			case panda.ErrDone:
 				return
+			default:
+				if report != nil {
+					report(err)
+				}
 			}
 		}
 	}()
 	return q
 }
 
-func Packets(addr string, codes []uint64) (<-chan panda.Parameter, error) {
-	r, err := Open(addr)
+func Packets(addr string, codes []Code, opts ...panda.DecodeOption) (<-chan panda.Parameter, error) {
+	return PacketsContext(context.Background(), addr, codes, opts...)
+}
+
+func PacketsContext(ctx context.Context, addr string, codes []Code, opts ...panda.DecodeOption) (<-chan panda.Parameter, error) {
+	r, err := OpenContext(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
-	return Filter(r, NewDecoder(codes)), nil
+	return FilterContext(ctx, r, panda.DecodePP(opts...), panda.WithPredicate(NewPredicate(codes))), nil
+}
+
+type Code struct {
+	Value [6]byte
+	Mask  [6]byte
+}
+
+func Exact(v uint64) Code {
+	var c Code
+	for i := len(c.Value) - 1; i >= 0; i-- {
+		c.Value[i], c.Mask[i], v = byte(v), 0xFF, v>>8
+	}
+	return c
+}
+
+func Codes(vs []uint64) []Code {
+	cs := make([]Code, len(vs))
+	for i, v := range vs {
+		cs[i] = Exact(v)
+	}
+	return cs
+}
+
+func ParseCode(s string) (Code, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	prefix := strings.HasSuffix(s, "*")
+	if !prefix && !strings.Contains(s, "?") {
+		v, err := strconv.ParseUint(s, 0, 48)
+		if err != nil {
+			return Code{}, err
+		}
+		return Exact(v), nil
+	}
+	s = strings.TrimPrefix(strings.TrimSuffix(s, "*"), "0x")
+	if len(s) > 12 {
+		return Code{}, fmt.Errorf("invalid umi code %q: too long", s)
+	}
+	if prefix {
+		s += strings.Repeat("?", 12-len(s))
+	} else {
+		s = strings.Repeat("0", 12-len(s)) + s
+	}
+	var c Code
+	for i, r := range s {
+		var v, m byte
+		switch {
+		case r == '?':
+		case r >= '0' && r <= '9':
+			v, m = byte(r-'0'), 0x0F
+		case r >= 'a' && r <= 'f':
+			v, m = byte(r-'a'+10), 0x0F
+		default:
+			return Code{}, fmt.Errorf("invalid umi code %q", s)
+		}
+		if i%2 == 0 {
+			v, m = v<<4, m<<4
+		}
+		c.Value[i/2] |= v
+		c.Mask[i/2] |= m
+	}
+	return c, nil
+}
+
+func (c Code) Match(bs [6]byte) bool {
+	for i := range bs {
+		if bs[i]&c.Mask[i] != c.Value[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Code) String() string {
+	const digits = "0123456789abcdef"
+
+	bs := make([]byte, 0, 12)
+	for i := 0; i < 12; i++ {
+		v, m := c.Value[i/2], c.Mask[i/2]
+		if i%2 == 0 {
+			v, m = v>>4, m>>4
+		}
+		if m&0x0F == 0 {
+			bs = append(bs, '?')
+		} else {
+			bs = append(bs, digits[v&0x0F])
+		}
+	}
+	s := string(bs)
+	if t := strings.TrimRight(s, "?"); len(t) < len(s) {
+		s = t + "*"
+	}
+	return "0x" + s
+}
+
+func (c Code) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Code) UnmarshalText(bs []byte) error {
+	v, err := ParseCode(string(bs))
+	if err == nil {
+		*c = v
+	}
+	return err
+}
+
+// umiCode is the offset of the code in the UMI header, after the state and the
+// orbit.
+const umiCode = 5
+
+type codeSet struct {
+	mask  [6]byte
+	codes map[[6]byte]struct{}
 }
 
 type Decoder struct {
-	codes   [][]byte
+	sets    []codeSet
 	decoder panda.Decoder
 }
 
-func NewDecoder(cs []uint64) panda.Decoder {
+func NewDecoder(cs []Code, opts ...panda.DecodeOption) panda.Decoder {
 	if len(cs) == 0 {
-		return panda.DecodePP()
+		return panda.DecodePP(opts...)
 	}
-	d := panda.DecodePP()
-	var vs [][]byte
+	var sets []codeSet
 	for _, c := range cs {
-		vs = append(vs, Itob(c))
+		i := 0
+		for ; i < len(sets); i++ {
+			if sets[i].mask == c.Mask {
+				break
+			}
+		}
+		if i == len(sets) {
+			sets = append(sets, codeSet{mask: c.Mask, codes: make(map[[6]byte]struct{})})
+		}
+		sets[i].codes[c.Value] = struct{}{}
 	}
-	return Decoder{vs, d}
+	return Decoder{sets, panda.DecodePP(opts...)}
+}
+
+// NewPredicate gives the header check of NewDecoder to be used with
+// panda.WithPredicate. It returns nil when every packet would be accepted.
+func NewPredicate(cs []Code) func([]byte) bool {
+	if d, ok := NewDecoder(cs).(Decoder); ok {
+		return d.Accept
+	}
+	return nil
 }
 
 func (d Decoder) Decode(bs []byte) (int, panda.Packet, error) {
+	if !d.Accept(bs) {
+		return len(bs), nil, panda.ErrSkip
+	}
 	i, p, err := d.decoder.Decode(bs)
 	if err != nil {
 		return i, nil, err
 	}
-	u, ok := p.(panda.Parameter)
-	if !ok {
+	if _, ok := p.(panda.Parameter); !ok {
 		return i, nil, panda.ErrSkip
 	}
-	for _, c := range d.codes {
-		if bytes.Equal(c, u.Code[:]) {
-			return i, p, nil
-		}
-	}
-	return i, nil, panda.ErrSkip
+	return i, p, nil
 }
 
-func Itob(v uint64) []byte {
-	bs := make([]byte, 16)
-
-	i := len(bs) - 1
-	for ; v > 0; i-- {
-		bs[i], v = byte(v&0xFF), v>>8
+// Accept reports whether the code found in the UMI header starting bs matches
+// one of the codes of d. Packets too short to be checked are accepted so that
+// decoding them fails.
+func (d Decoder) Accept(bs []byte) bool {
+	if len(bs) < umiCode+len(Code{}.Value) {
+		return true
+	}
+	for _, s := range d.sets {
+		var k [6]byte
+		copy(k[:], bs[umiCode:])
+		for j := range k {
+			k[j] &= s.mask[j]
+		}
+		if _, ok := s.codes[k]; ok {
+			return true
+		}
 	}
-	return bs[i+1:]
+	return false
 }
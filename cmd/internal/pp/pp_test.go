@@ -0,0 +1,174 @@
+package pp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/busoc/panda"
+)
+
+// testHeaders gives n UMI headers, half of them holding one of the codes
+// of cs.
+func testHeaders(cs []Code, n int) [][]byte {
+	r := rand.New(rand.NewSource(1))
+	hs := make([][]byte, n)
+	for i := range hs {
+		bs := make([]byte, panda.UMILength)
+		if i%2 == 0 {
+			c := cs[r.Intn(len(cs))].Value
+			copy(bs[umiCode:], c[:])
+		} else {
+			r.Read(bs[umiCode : umiCode+6])
+		}
+		hs[i] = bs
+	}
+	return hs
+}
+
+func testCodes(n int) []Code {
+	r := rand.New(rand.NewSource(0))
+	cs := make([]Code, n)
+	for i := range cs {
+		cs[i] = Exact(uint64(r.Int63n(1 << 48)))
+	}
+	return cs
+}
+
+func TestPredicate(t *testing.T) {
+	cs := testCodes(1000)
+	p, err := ParseCode("0xabcd*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accept := NewPredicate(append(cs, p))
+	for i, h := range testHeaders(cs, 1000) {
+		var k [6]byte
+		copy(k[:], h[umiCode:])
+		want := p.Match(k)
+		for _, c := range cs {
+			want = want || c.Match(k)
+		}
+		if got := accept(h); got != want {
+			t.Errorf("header %d (%x): want %t, got %t", i, k, want, got)
+		}
+	}
+	h := make([]byte, panda.UMILength)
+	copy(h[umiCode:], []byte{0xab, 0xcd, 0x01, 0x02, 0x03, 0x04})
+	if !accept(h) {
+		t.Errorf("prefix 0xabcd*: code %x not accepted", h[umiCode:umiCode+6])
+	}
+	if NewPredicate(nil) != nil {
+		t.Errorf("empty code set: predicate should be nil")
+	}
+}
+
+func BenchmarkPredicate10k(b *testing.B) {
+	cs := testCodes(10000)
+	hs := testHeaders(cs, 1024)
+	accept := NewPredicate(cs)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		accept(hs[i%len(hs)])
+	}
+}
+
+// BenchmarkPredicate10kLinear is the comparison of the code of each packet
+// with every code of the set that NewPredicate replaces.
+func BenchmarkPredicate10kLinear(b *testing.B) {
+	cs := testCodes(10000)
+	hs := testHeaders(cs, 1024)
+	accept := func(bs []byte) bool {
+		var k [6]byte
+		copy(k[:], bs[umiCode:])
+		for _, c := range cs {
+			if c.Match(k) {
+				return true
+			}
+		}
+		return false
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		accept(hs[i%len(hs)])
+	}
+}
+
+// writeArchive writes an archive file of n parameters with 512 bytes of data,
+// one in hundred having the code of cs.
+func writeArchive(b *testing.B, cs []Code, n int) string {
+	b.Helper()
+	r := rand.New(rand.NewSource(2))
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		p := panda.Parameter{Data: make([]byte, 512)}
+		if i%100 == 0 {
+			p.Code = cs[r.Intn(len(cs))].Value
+		} else {
+			r.Read(p.Code[:])
+		}
+		p.Type, p.Length = panda.BinaryN, uint16(len(p.Data))
+		bs, _ := p.Bytes()
+		binary.Write(&buf, binary.LittleEndian, uint32(len(bs)))
+		buf.Write(bs)
+	}
+	file := filepath.Join(b.TempDir(), "rt_00_04.dat")
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		b.Fatal(err)
+	}
+	return file
+}
+
+// BenchmarkFilter compares the ways of keeping the parameters of a narrow set
+// of codes: decoding all of them and discarding the ones not matching
+// (discard), checking the code in the decoder (decoder) and checking the code
+// in the reader before decoding (predicate), as Packets does.
+func BenchmarkFilter(b *testing.B) {
+	const n = 100000
+
+	cs := testCodes(10)
+	file := writeArchive(b, cs, n)
+	accept := NewPredicate(cs)
+	data := []struct {
+		Name    string
+		Decoder panda.Decoder
+		Options []panda.ReaderOption
+		Discard bool
+	}{
+		{Name: "discard", Decoder: panda.DecodePP(), Discard: true},
+		{Name: "decoder", Decoder: NewDecoder(cs)},
+		{Name: "predicate", Decoder: panda.DecodePP(), Options: []panda.ReaderOption{panda.WithPredicate(accept)}},
+	}
+	for _, d := range data {
+		b.Run(d.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r, err := Open(file)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var count int
+				for p := range FilterContext(context.Background(), r, d.Decoder, d.Options...) {
+					if d.Discard {
+						bs, _ := p.Bytes()
+						if !accept(bs) {
+							continue
+						}
+					}
+					count++
+				}
+				if count != n/100 {
+					b.Fatalf("want %d parameters, got %d", n/100, count)
+				}
+			}
+		})
+	}
+}
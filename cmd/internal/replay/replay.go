@@ -0,0 +1,74 @@
+package replay
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/busoc/panda/cmd/internal/opts"
+)
+
+// Pacer spaces the packets replayed as they were when acquired, rate times
+// faster. Delays longer than an hour are skipped.
+type Pacer struct {
+	rate int
+	prev time.Time
+}
+
+func NewPacer(rate int) *Pacer {
+	if rate <= 0 {
+		rate = 1
+	}
+	return &Pacer{rate: rate}
+}
+
+// Wait sleeps until the packet acquired at t can be sent.
+func (p *Pacer) Wait(t time.Time) {
+	if !p.prev.IsZero() {
+		if d := t.Sub(p.prev); d > 0 && d <= time.Hour {
+			time.Sleep(d / time.Duration(p.rate))
+		}
+	}
+	p.prev = t
+}
+
+// Conn simulates AOS and LOS periods on c: bytes written during a LOS are
+// silently discarded.
+func Conn(c net.Conn, g *opts.Gap) net.Conn {
+	if g.IsZero() {
+		return c
+	}
+	return &conn{
+		Conn:   c,
+		gap:    g,
+		writer: c,
+		after:  time.After(g.Next()),
+	}
+}
+
+type conn struct {
+	net.Conn
+	gap *opts.Gap
+
+	writer io.Writer
+	after  <-chan time.Time
+}
+
+func (c *conn) Write(bs []byte) (int, error) {
+	select {
+	case <-c.after:
+		var (
+			d time.Duration
+			w io.Writer
+		)
+		if t, ok := c.gap.Wait(); !ok {
+			w, d = ioutil.Discard, t
+		} else {
+			w, d = c.Conn, t
+		}
+		c.writer, c.after = w, time.After(d)
+	default:
+	}
+	return c.writer.Write(bs)
+}
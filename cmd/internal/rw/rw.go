@@ -6,19 +6,25 @@ import (
 	"io"
 )
 
-type noDuplicate struct {
+type Dedup struct {
 	digests map[string]struct{}
 	inner   io.Writer
+	dropped int
 }
 
-func NoDuplicate(w io.Writer) io.Writer {
+func NoDuplicate(w io.Writer) *Dedup {
 	ds := make(map[string]struct{})
-	return &noDuplicate{digests: ds, inner: w}
+	return &Dedup{digests: ds, inner: w}
 }
 
-func (n *noDuplicate) Write(bs []byte) (int, error) {
+func (n *Dedup) Dropped() int {
+	return n.dropped
+}
+
+func (n *Dedup) Write(bs []byte) (int, error) {
 	s := fmt.Sprintf("%x", md5.Sum(bs))
 	if _, ok := n.digests[s]; ok {
+		n.dropped++
 		return len(bs), nil
 	}
 	n.digests[s] = struct{}{}
@@ -0,0 +1,57 @@
+package rw
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/busoc/panda"
+)
+
+type Session struct {
+	file   *os.File
+	writer *panda.Writer
+}
+
+// Record creates a new file in dir recording the packets sent to a client
+// with the framing used by the realtime streams. It returns a nil Session
+// when dir is empty.
+func Record(dir, kind, name, id string) (*Session, error) {
+	if len(dir) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name = strings.Replace(strings.Trim(name, "/"), "/", "_", -1)
+	if len(name) == 0 {
+		name = kind
+	}
+	n := fmt.Sprintf("%s_%s_%s.dat", name, time.Now().UTC().Format("20060102_150405"), id)
+	f, err := os.Create(filepath.Join(dir, n))
+	if err != nil {
+		return nil, err
+	}
+	w, err := panda.NewWriter(kind, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Session{file: f, writer: w}, nil
+}
+
+func (s *Session) Write(p panda.Packet) error {
+	if s == nil {
+		return nil
+	}
+	return s.writer.Write(p)
+}
+
+func (s *Session) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.file.Close()
+}
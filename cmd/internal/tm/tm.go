@@ -2,91 +2,238 @@ package tm
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"io"
 	"net"
+	"os"
+	"time"
 
 	"github.com/busoc/panda"
+	"github.com/busoc/panda/hrdp"
 )
 
-func Open(a string) (io.Reader, error) {
+func Open(a string, opts ...panda.WalkOption) (io.Reader, error) {
+	return OpenContext(context.Background(), a, opts...)
+}
+
+func OpenContext(ctx context.Context, a string, opts ...panda.WalkOption) (io.Reader, error) {
+	if a == "-" {
+		return panda.Stream("tm", os.Stdin)
+	}
 	if _, _, err := net.SplitHostPort(a); err == nil {
-		return panda.Listen("tm", a)
+		return panda.ListenContext(ctx, "tm", a)
+	}
+	return panda.WalkContext(ctx, "tm", a, opts...)
+}
+
+// OpenBetween is like Open but only reads the files of the archive a covering
+// [start, end) when a is a directory. A zero end stands for now; a zero start
+// reads the whole archive.
+func OpenBetween(a string, start, end time.Time, opts ...panda.WalkOption) (io.Reader, error) {
+	if i, err := os.Stat(a); start.IsZero() || err != nil || !i.IsDir() {
+		return Open(a, opts...)
 	}
-	return panda.Walk("tm", a)
+	return hrdp.NewReader("tm", a, start, end, opts...)
+}
+
+func OpenRaw(a string, opts ...panda.WalkOption) (io.Reader, int, error) {
+	var (
+		r   io.Reader
+		n   = panda.FrameTM
+		err error
+	)
+	if a == "-" {
+		r, err = panda.StreamRaw("tm", os.Stdin)
+	} else if _, _, e := net.SplitHostPort(a); e == nil {
+		r, err = panda.ListenRaw("tm", a)
+	} else {
+		r, err = panda.WalkRaw("tm", a, opts...)
+		n = panda.RecordLengthSize + panda.RecordHeaderTM
+	}
+	return r, n, err
+}
+
+func Watch(a string, opts ...panda.WalkOption) (io.Reader, error) {
+	return panda.Watch("tm", a, opts...)
+}
+
+func WatchRaw(a string, opts ...panda.WalkOption) (io.Reader, int, error) {
+	r, err := panda.WatchRaw("tm", a, opts...)
+	return r, panda.RecordLengthSize + panda.RecordHeaderTM, err
 }
 
 func Filter(r io.Reader, d panda.Decoder) <-chan panda.Telemetry {
+	return FilterContext(context.Background(), r, d)
+}
+
+func FilterContext(ctx context.Context, r io.Reader, d panda.Decoder, opts ...panda.ReaderOption) <-chan panda.Telemetry {
+	return FilterReport(ctx, r, d, nil, opts...)
+}
+
+// FilterReport is like FilterContext but gives the packets that could not be
+// decoded to report before skipping them.
+func FilterReport(ctx context.Context, r io.Reader, d panda.Decoder, report func(error), opts ...panda.ReaderOption) <-chan panda.Telemetry {
 	q := make(chan panda.Telemetry)
 	go func() {
-		source := panda.NewReader(r, d)
+		source := panda.NewReaderContext(ctx, r, d, opts...)
 		defer func() {
 			close(q)
 			source.Close()
 		}()
 		for {
-			p, err := source.Read()
+			p, err := source.ReadPacket()
 			switch err {
 			case nil:
 				if p, ok := p.(panda.Telemetry); ok {
-					q <- p
+					select {
+					case q <- p:
+					case <-ctx.Done():
+						return
+					}
 				}
 			case panda.ErrDone:
 				return
+			default:
+				if report != nil {
+					report(err)
+				}
 			}
 		}
 	}()
 	return q
 }
 
-func Packets(addr string, apid int, pids []uint32) (<-chan panda.Telemetry, error) {
-	r, err := Open(addr)
+func Packets(addr string, apid int, pids []uint32, opts ...panda.DecodeOption) (<-chan panda.Telemetry, error) {
+	return PacketsContext(context.Background(), addr, apid, pids, opts...)
+}
+
+func PacketsContext(ctx context.Context, addr string, apid int, pids []uint32, opts ...panda.DecodeOption) (<-chan panda.Telemetry, error) {
+	r, err := OpenContext(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
-	return Filter(r, NewDecoder(apid, pids)), nil
+	return FilterContext(ctx, r, panda.DecodeTM(opts...), panda.WithPredicate(NewPredicate(apid, pids))), nil
+}
+
+type Match struct {
+	Apids    []int
+	Sources  []uint32
+	Any      bool
+	Segments []panda.CCSDSPacketSegmentation
 }
 
+// pidMask and pidBits are the bits of the first word of the CCSDS header
+// checked on top of the apid: version (0), type (1) and secondary header flag
+// (1). The secondary header flag is ignored when matching Any.
+const (
+	pidMask = 0xE000 | 1<<12 | 1<<11
+	pidBits = 1<<12 | 1<<11
+	anyMask = 0xE000 | 1<<12
+	anyBits = 1 << 12
+)
+
 type Decoder struct {
-	pid     []byte
-	sources [][]byte
-	decoder panda.Decoder
+	pid      []byte
+	apids    map[int]struct{}
+	any      bool
+	segments uint8
+	sources  [][]byte
+	decoder  panda.Decoder
 }
 
-func NewDecoder(apid int, ps []uint32) panda.Decoder {
-	d := panda.DecodeTM()
-	if apid <= 0 && len(ps) == 0 {
+func NewDecoder(apid int, ps []uint32, opts ...panda.DecodeOption) panda.Decoder {
+	var m Match
+	if apid > 0 {
+		m.Apids = []int{apid}
+	}
+	m.Sources = ps
+	return NewMatchDecoder(m, opts...)
+}
+
+// NewPredicate gives the header check of NewDecoder to be used with
+// panda.WithPredicate. It returns nil when every packet would be accepted.
+func NewPredicate(apid int, ps []uint32) func([]byte) bool {
+	if d, ok := NewDecoder(apid, ps).(Decoder); ok {
+		return d.Accept
+	}
+	return nil
+}
+
+func NewMatchDecoder(m Match, opts ...panda.DecodeOption) panda.Decoder {
+	d := panda.DecodeTM(opts...)
+	if len(m.Apids) == 0 && len(m.Sources) == 0 && len(m.Segments) == 0 {
 		return d
 	}
 	var is [][]byte
-	for _, p := range ps {
+	for _, p := range m.Sources {
 		bs := make([]byte, 4)
 		binary.BigEndian.PutUint32(bs, p)
 
 		is = append(is, bs)
 	}
-
-	var pid []byte
-	if apid > 0 {
-		pid = make([]byte, 2)
-		binary.BigEndian.PutUint16(pid, uint16(1<<12|1<<11|apid))
+	var segments uint8
+	for _, s := range m.Segments {
+		segments |= 1 << uint8(s)
 	}
-
-	return Decoder{pid, is, d}
+	x := Decoder{sources: is, segments: segments, any: m.Any, decoder: d}
+	switch {
+	case len(m.Apids) == 1 && !m.Any:
+		x.pid = make([]byte, 2)
+		binary.BigEndian.PutUint16(x.pid, uint16(pidBits|m.Apids[0]))
+	case len(m.Apids) > 0:
+		x.apids = make(map[int]struct{})
+		for _, a := range m.Apids {
+			x.apids[a] = struct{}{}
+		}
+	}
+	return x
 }
 
 func (d Decoder) Decode(bs []byte) (int, panda.Packet, error) {
-	if len(d.pid) > 0 && !bytes.HasPrefix(bs, d.pid) {
+	if !d.Accept(bs) {
 		return len(bs), nil, panda.ErrSkip
 	}
+	return d.decoder.Decode(bs)
+}
+
+// Accept reports whether the packet starting bs matches, looking only at its
+// headers. Packets too short to be checked are accepted so that decoding them
+// fails.
+func (d Decoder) Accept(bs []byte) bool {
+	if len(d.pid) > 0 && !bytes.HasPrefix(bs, d.pid) {
+		return false
+	}
+	if len(bs) < panda.CCSDSLength {
+		return true
+	}
+	if len(d.apids) > 0 {
+		mask, bits := uint16(pidMask), uint16(pidBits)
+		if d.any {
+			mask, bits = anyMask, anyBits
+		}
+		h := binary.BigEndian.Uint16(bs)
+		if h&mask != bits {
+			return false
+		}
+		if _, ok := d.apids[int(h&0x07FF)]; !ok {
+			return false
+		}
+	}
+	if d.segments != 0 && d.segments&(1<<(bs[2]>>6)) == 0 {
+		return false
+	}
 	if len(d.sources) == 0 {
-		return d.decoder.Decode(bs)
+		return true
 	}
 	ix := panda.CCSDSLength + panda.ESALength
+	if len(bs) < ix {
+		return true
+	}
 	for _, s := range d.sources {
 		if bytes.Equal(bs[ix-len(s):ix], s) {
-			return d.decoder.Decode(bs)
+			return true
 		}
 	}
-	return len(bs), nil, panda.ErrSkip
+	return false
 }
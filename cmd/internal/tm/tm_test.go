@@ -0,0 +1,33 @@
+package tm
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/busoc/panda"
+)
+
+func TestAcceptPid(t *testing.T) {
+	const apid = 0x386
+
+	var (
+		single = NewMatchDecoder(Match{Apids: []int{apid}}).(Decoder)
+		set    = NewMatchDecoder(Match{Apids: []int{apid, 0x387}}).(Decoder)
+		loose  = NewMatchDecoder(Match{Apids: []int{apid}, Any: true}).(Decoder)
+	)
+	bs := make([]byte, panda.CCSDSLength+panda.ESALength)
+	for h := 0; h <= 0xFFFF; h++ {
+		binary.BigEndian.PutUint16(bs, uint16(h))
+
+		match := h&0x07FF == apid
+		if got, want := single.Accept(bs), match && h>>11 == 0x03; got != want {
+			t.Errorf("%04x: single apid: want %t, got %t", h, want, got)
+		}
+		if got := set.Accept(bs); match && got != single.Accept(bs) {
+			t.Errorf("%04x: apid set and single apid disagree", h)
+		}
+		if got, want := loose.Accept(bs), match && h>>12 == 0x01; got != want {
+			t.Errorf("%04x: any header: want %t, got %t", h, want, got)
+		}
+	}
+}
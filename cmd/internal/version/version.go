@@ -0,0 +1,70 @@
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Commit and Build are set at build time, eg:
+//
+//	go build -ldflags "-X github.com/busoc/panda/cmd/internal/version.Commit=$(git rev-parse --short HEAD) -X github.com/busoc/panda/cmd/internal/version.Build=$(date -u +%FT%TZ)"
+var (
+	Commit = "unknown"
+	Build  = "unknown"
+)
+
+// Protocols lists the formats of packets and records the binaries can read.
+var Protocols = []string{"hrdp/tm", "hrdp/pp", "hrd/v1", "hrd/v2"}
+
+type Info struct {
+	Name      string   `json:"name"`
+	Commit    string   `json:"commit"`
+	Build     string   `json:"build"`
+	Go        string   `json:"go"`
+	Protocols []string `json:"protocols"`
+}
+
+func Get() Info {
+	return Info{
+		Name:      filepath.Base(os.Args[0]),
+		Commit:    Commit,
+		Build:     Build,
+		Go:        runtime.Version(),
+		Protocols: Protocols,
+	}
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("%s %s (built %s, %s) - %s", i.Name, i.Commit, i.Build, i.Go, strings.Join(i.Protocols, ", "))
+}
+
+// Requested reports whether the -version flag is the first of args.
+func Requested(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	return args[0] == "-version" || args[0] == "--version"
+}
+
+func Print(w io.Writer) {
+	fmt.Fprintln(w, Get())
+}
+
+// Handler serves the version information of the running binary as JSON.
+func Handler() http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(Get())
+	}
+	return http.HandlerFunc(f)
+}
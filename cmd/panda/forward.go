@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/pp"
+	"github.com/busoc/panda/cmd/internal/tm"
+	"github.com/midbel/cli"
+	"github.com/midbel/toml"
+)
+
+func runForward(cmd *cli.Command, args []string) error {
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	f, err := os.Open(cmd.Flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	c := struct {
+		Routes []*route `toml:"route"`
+	}{}
+	if err := toml.NewDecoder(f).Decode(&c); err != nil {
+		return err
+	}
+	f.Close()
+
+	if len(c.Routes) == 0 {
+		return fmt.Errorf("no route defined")
+	}
+	for _, r := range c.Routes {
+		if err := r.setup(); err != nil {
+			return fmt.Errorf("%s: %s", r, err)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, r := range c.Routes {
+		wg.Add(1)
+		go func(r *route) {
+			defer wg.Done()
+			log.Printf("%s: forwarding %s packets from %v to %v", r, r.Kind, r.Sources, r.Targets)
+			if err := r.Run(); err != nil {
+				log.Printf("%s: %s", r, err)
+			}
+		}(r)
+	}
+	wg.Wait()
+	return nil
+}
+
+type route struct {
+	Name    string   `toml:"name"`
+	Kind    string   `toml:"kind"`
+	Frame   string   `toml:"frame"`
+	Sources []string `toml:"source"`
+	Targets []string `toml:"target"`
+
+	Apid  int      `toml:"apid"`
+	Sids  []uint32 `toml:"sid"`
+	Codes []string `toml:"codes"`
+
+	codes []pp.Code
+}
+
+func (r *route) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("%s:%v", r.Kind, r.Sources)
+}
+
+func (r *route) setup() error {
+	switch r.Kind {
+	default:
+		return fmt.Errorf("unsupported packet kind %q", r.Kind)
+	case "tm", "pp":
+	}
+	switch r.Frame {
+	default:
+		return fmt.Errorf("unsupported framing %q", r.Frame)
+	case "", "hrdp", "raw":
+	}
+	if len(r.Sources) == 0 || len(r.Targets) == 0 {
+		return fmt.Errorf("no source and/or target given")
+	}
+	for _, c := range r.Codes {
+		v, err := pp.ParseCode(c)
+		if err != nil {
+			return err
+		}
+		r.codes = append(r.codes, v)
+	}
+	return nil
+}
+
+func (r *route) Run() error {
+	var ws []packetWriter
+	for _, t := range r.Targets {
+		c, err := net.Dial("udp", t)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		w, err := r.writer(c)
+		if err != nil {
+			return err
+		}
+		ws = append(ws, w)
+	}
+	queue, err := r.packets()
+	if err != nil {
+		return err
+	}
+	for p := range queue {
+		for i, w := range ws {
+			if err := w.Write(p); err != nil {
+				log.Printf("%s: %s: %s", r, r.Targets[i], err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *route) writer(w io.Writer) (packetWriter, error) {
+	if r.Frame == "raw" {
+		return rawWriter{w}, nil
+	}
+	return panda.NewWriter(r.Kind, w)
+}
+
+func (r *route) packets() (<-chan panda.Packet, error) {
+	var (
+		wg sync.WaitGroup
+		q  = make(chan panda.Packet)
+	)
+	for _, s := range r.Sources {
+		switch r.Kind {
+		case "tm":
+			ps, err := tm.Packets(s, r.Apid, r.Sids)
+			if err != nil {
+				return nil, err
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for p := range ps {
+					q <- p
+				}
+			}()
+		case "pp":
+			ps, err := pp.Packets(s, r.codes)
+			if err != nil {
+				return nil, err
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for p := range ps {
+					q <- p
+				}
+			}()
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(q)
+	}()
+	return q, nil
+}
+
+type packetWriter interface {
+	Write(panda.Packet) error
+}
+
+type rawWriter struct {
+	writer io.Writer
+}
+
+func (w rawWriter) Write(p panda.Packet) error {
+	bs, err := p.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write(bs)
+	return err
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/pp"
+	"github.com/midbel/cli"
+	"github.com/midbel/toml"
+)
+
+func runGenerate(cmd *cli.Command, args []string) error {
+	elapsed := cmd.Flag.Duration("d", 0, "duration")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	f, err := os.Open(cmd.Flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	c := struct {
+		Sources []*source `toml:"source"`
+	}{}
+	if err := toml.NewDecoder(f).Decode(&c); err != nil {
+		return err
+	}
+	f.Close()
+
+	if len(c.Sources) == 0 {
+		return fmt.Errorf("no source defined")
+	}
+	ts := make(targets)
+	defer ts.Close()
+	for _, s := range c.Sources {
+		if err := s.setup(ts); err != nil {
+			return fmt.Errorf("%s: %s", s, err)
+		}
+	}
+	done := make(chan struct{})
+	if *elapsed > 0 {
+		time.AfterFunc(*elapsed, func() { close(done) })
+	}
+	var wg sync.WaitGroup
+	for _, s := range c.Sources {
+		wg.Add(1)
+		go func(s *source) {
+			defer wg.Done()
+			log.Printf("%s: generating %s packets (%.2f/s) to %v", s, s.Kind, s.Rate, s.Targets)
+			n, err := s.Run(done)
+			if err != nil {
+				log.Printf("%s: %s", s, err)
+			}
+			log.Printf("%s: %d packets generated", s, n)
+		}(s)
+	}
+	wg.Wait()
+	return nil
+}
+
+type source struct {
+	Name    string   `toml:"name"`
+	Kind    string   `toml:"kind"`
+	Frame   string   `toml:"frame"`
+	Targets []string `toml:"target"`
+	Rate    float64  `toml:"rate"`
+	Count   int      `toml:"count"`
+
+	// tm
+	Apid   int    `toml:"apid"`
+	Sid    uint32 `toml:"sid"`
+	Length int    `toml:"length"`
+	// pp
+	Code string `toml:"code"`
+	// vmu
+	Channel string `toml:"channel"`
+	Origin  uint8  `toml:"origin"`
+	Size    int    `toml:"size"`
+
+	Items []field `toml:"item"`
+
+	writers []packetWriter
+	code    pp.Code
+	channel panda.Channel
+}
+
+func (s *source) String() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Kind
+}
+
+func (s *source) setup(ts targets) error {
+	if s.Rate <= 0 {
+		s.Rate = 1
+	}
+	switch s.Frame {
+	default:
+		return fmt.Errorf("unsupported framing %q", s.Frame)
+	case "", "hrdp", "raw":
+	}
+	switch s.Kind {
+	default:
+		return fmt.Errorf("unsupported packet kind %q", s.Kind)
+	case "tm":
+		if s.Length <= 0 {
+			s.Length = 64
+		}
+		for _, f := range s.Items {
+			if f.Offset%8 != 0 || f.Offset/8+f.size() > s.Length {
+				return fmt.Errorf("%s: invalid position %d", f.Label, f.Offset)
+			}
+		}
+	case "pp":
+		c, err := pp.ParseCode(s.Code)
+		if err != nil {
+			return err
+		}
+		if len(s.Items) != 1 {
+			return fmt.Errorf("one item expected for parameter %s", s.Code)
+		}
+		switch s.Items[0].Type {
+		case "long", "double":
+		default:
+			return fmt.Errorf("unsupported parameter type %s", s.Items[0].Type)
+		}
+		s.code = c
+	case "vmu":
+		switch s.Channel {
+		case "vic1":
+			s.channel = panda.Video1
+		case "vic2":
+			s.channel = panda.Video2
+		case "lrsd", "":
+			s.channel = panda.Science
+		default:
+			return fmt.Errorf("unknown channel %s", s.Channel)
+		}
+		if s.Origin == 0 {
+			s.Origin = panda.LRSD
+		}
+		if s.Size <= 0 {
+			s.Size = 1024
+		}
+		// VMU packets are sent as is, one packet per datagram.
+		s.Frame = "raw"
+	}
+	if len(s.Targets) == 0 {
+		return fmt.Errorf("no target given")
+	}
+	for _, t := range s.Targets {
+		w, err := ts.Open(t)
+		if err != nil {
+			return err
+		}
+		if s.Frame == "raw" {
+			s.writers = append(s.writers, rawWriter{w})
+			continue
+		}
+		pw, err := panda.NewWriter(s.Kind, w)
+		if err != nil {
+			return err
+		}
+		s.writers = append(s.writers, pw)
+	}
+	return nil
+}
+
+// Run writes a packet to the targets of s at its rate until done is closed or
+// its count is reached.
+func (s *source) Run(done <-chan struct{}) (int, error) {
+	tick := time.NewTicker(time.Duration(float64(time.Second) / s.Rate))
+	defer tick.Stop()
+
+	var n int
+	for s.Count <= 0 || n < s.Count {
+		select {
+		case <-done:
+			return n, nil
+		case t := <-tick.C:
+			p, err := s.generate(n, t)
+			if err != nil {
+				return n, err
+			}
+			for i, w := range s.writers {
+				if err := w.Write(p); err != nil {
+					log.Printf("%s: %s: %s", s, s.Targets[i], err)
+				}
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *source) generate(n int, t time.Time) (panda.Packet, error) {
+	coarse, fine := gpsTime(t)
+	switch s.Kind {
+	case "tm":
+		var p panda.Telemetry
+		p.Data = make([]byte, s.Length)
+		for _, f := range s.Items {
+			if err := f.Put(p.Data[f.Offset/8:], f.Next(n, t)); err != nil {
+				return nil, err
+			}
+		}
+		p.CCSDSHeader = panda.CCSDSHeader{
+			Pid:     1<<12 | 1<<11 | uint16(s.Apid&0x07FF),
+			Segment: 0xC000 | uint16(n&0x3FFF),
+			Length:  uint16(panda.ESALength + len(p.Data) - 1),
+		}
+		p.ESAHeader = panda.ESAHeader{
+			Coarse: coarse,
+			Fine:   fine,
+			Sid:    s.Sid,
+		}
+		return p, nil
+	case "pp":
+		var p panda.Parameter
+		f := s.Items[0]
+		switch f.Type {
+		case "long":
+			p.Type, p.Data = panda.Int32, make([]byte, 4)
+			binary.BigEndian.PutUint32(p.Data, uint32(int32(f.Next(n, t))))
+		case "double":
+			p.Type, p.Data = panda.Float64, make([]byte, 8)
+			binary.BigEndian.PutUint64(p.Data, math.Float64bits(f.Next(n, t)))
+		}
+		p.State, p.Code = panda.NewValue, s.code.Value
+		p.Coarse, p.Fine, p.Length = coarse, fine, uint16(len(p.Data))
+		return p, nil
+	default:
+		return s.vmu(n, t), nil
+	}
+}
+
+func (s *source) vmu(n int, t time.Time) panda.Packet {
+	v := &panda.VMUHeader{
+		Channel:  s.channel,
+		Source:   s.Origin,
+		Sequence: uint32(n),
+		Coarse:   uint32(t.Unix()),
+		Fine:     uint16(t.Nanosecond() / int(time.Millisecond)),
+	}
+	data := make([]byte, s.Size)
+	for i := range data {
+		data[i] = byte(i + n)
+	}
+	acq := t.Sub(panda.GPS)
+	var (
+		p  panda.Packet
+		bs []byte
+	)
+	if s.channel == panda.Science {
+		x := &panda.Table{
+			VMUHeader: v,
+			SDH:       &panda.SDHv2{Originator: uint32(n), Acquisition: acq, Id: s.Origin},
+			Data:      data,
+		}
+		bs, _ = x.Bytes()
+		x.Sum = sum(bs)
+		p = x
+	} else {
+		x := &panda.Image{
+			VMUHeader: v,
+			IDH:       &panda.IDHv2{Originator: uint32(n), Acquisition: acq, Id: s.Origin, Pixels: uint32(s.Size)},
+			Data:      data,
+		}
+		bs, _ = x.Bytes()
+		x.Sum = sum(bs)
+		p = x
+	}
+	return p
+}
+
+// sum computes the checksum of the VMU packet bs whose last 4 bytes are the
+// checksum itself.
+func sum(bs []byte) uint32 {
+	var s uint32
+	for _, b := range bs[:len(bs)-4] {
+		s += uint32(b)
+	}
+	return s
+}
+
+// gpsTime gives the coarse time (seconds since the GPS epoch) and the fine
+// time (1/256s) of t.
+func gpsTime(t time.Time) (uint32, uint8) {
+	d := t.Sub(panda.GPS)
+	return uint32(d / time.Second), uint8((d % time.Second) * 256 / time.Second)
+}
+
+// field is an item of the payload of the generated packets with the way its
+// values are produced.
+type field struct {
+	Label     string `toml:"name"`
+	Type      string `toml:"type"`
+	Offset    int    `toml:"position"`
+	Endianess string `toml:"endianess"`
+
+	Generator string  `toml:"generator"`
+	Value     float64 `toml:"value"`
+	Min       float64 `toml:"min"`
+	Max       float64 `toml:"max"`
+	Step      float64 `toml:"step"`
+	Period    float64 `toml:"period"`
+}
+
+// Next gives the n-th value of f, generated at t.
+func (f field) Next(n int, t time.Time) float64 {
+	switch f.Generator {
+	default:
+		return f.Value
+	case "counter":
+		v := f.Value + float64(n)*f.Step
+		if f.Max > f.Min {
+			v = f.Min + math.Mod(v-f.Min, f.Max-f.Min)
+		}
+		return v
+	case "sine":
+		p := f.Period
+		if p <= 0 {
+			p = 60
+		}
+		x := float64(t.UnixNano()) / float64(time.Second) / p
+		return f.Min + (f.Max-f.Min)*(1+math.Sin(2*math.Pi*x))/2
+	case "random":
+		return f.Min + rand.Float64()*(f.Max-f.Min)
+	}
+}
+
+func (f field) size() int {
+	switch f.Type {
+	case "bool", "uchar", "char":
+		return 1
+	case "ulong", "long", "float":
+		return 4
+	default:
+		return 2
+	}
+}
+
+// Put encodes v at the start of bs with the type of f.
+func (f field) Put(bs []byte, v float64) error {
+	var e binary.ByteOrder
+	switch f.Endianess {
+	case "big", "be", "":
+		e = binary.BigEndian
+	case "little", "le":
+		e = binary.LittleEndian
+	default:
+		return fmt.Errorf("unsupported endianess %s", f.Endianess)
+	}
+	switch f.Type {
+	case "bool":
+		if v != 0 {
+			bs[0] = 1
+		}
+	case "uchar":
+		bs[0] = uint8(v)
+	case "char":
+		bs[0] = uint8(int8(v))
+	case "ushort", "":
+		e.PutUint16(bs, uint16(v))
+	case "short":
+		e.PutUint16(bs, uint16(int16(v)))
+	case "ulong":
+		e.PutUint32(bs, uint32(v))
+	case "long":
+		e.PutUint32(bs, uint32(int32(v)))
+	case "float":
+		e.PutUint32(bs, math.Float32bits(float32(v)))
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type)
+	}
+	return nil
+}
+
+// targets shares the destinations of the generated packets between sources:
+// UDP addresses, files or the standard output ("-").
+type targets map[string]*target
+
+type target struct {
+	mu sync.Mutex
+	io.WriteCloser
+}
+
+func (t *target) Write(bs []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.WriteCloser.Write(bs)
+}
+
+func (ts targets) Open(s string) (io.Writer, error) {
+	if t, ok := ts[s]; ok {
+		return t, nil
+	}
+	var (
+		w   io.WriteCloser
+		err error
+	)
+	if s == "-" {
+		w = os.Stdout
+	} else if _, _, e := net.SplitHostPort(s); e == nil {
+		w, err = net.Dial("udp", s)
+	} else {
+		w, err = os.Create(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &target{WriteCloser: w}
+	ts[s] = t
+	return t, nil
+}
+
+func (ts targets) Close() {
+	for _, t := range ts {
+		t.Close()
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/busoc/panda/cmd/internal/version"
+	"github.com/midbel/cli"
+)
+
+var commands = []*cli.Command{
+	{
+		Run:   runForward,
+		Usage: "forward <config.toml>",
+		Short: "forward filtered packets from multicast groups to other destinations",
+	},
+	{
+		Run:   runWatch,
+		Usage: "watch [-k] [-i] [-m] [-cert] [-key] [-ca] [-q] <group...>",
+		Short: "measure rates, gaps and jitter of multicast streams",
+	},
+	{
+		Run:   runGenerate,
+		Usage: "gen [-d] <config.toml>",
+		Short: "generate synthetic TM, PP and VMU packets at configured rates",
+	},
+}
+
+const helpText = `{{.Name}} provides tools around TM and PP streams.
+
+Usage:
+
+  {{.Name}} command [arguments]
+
+The commands are:
+
+{{range .Commands}}{{printf "  %-9s %s" .String .Short}}
+{{end}}
+
+Use {{.Name}} [command] -h for more information about its usage.
+`
+
+func main() {
+	log.SetFlags(0)
+	if version.Requested(os.Args[1:]) {
+		version.Print(os.Stdout)
+		return
+	}
+	if err := cli.Run(commands, usage, nil); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func usage() {
+	data := struct {
+		Name     string
+		Commands []*cli.Command
+	}{
+		Name:     filepath.Base(os.Args[0]),
+		Commands: commands,
+	}
+	t := template.Must(template.New("help").Parse(helpText))
+	t.Execute(os.Stderr, data)
+
+	os.Exit(2)
+}
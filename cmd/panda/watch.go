@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/httpx"
+	"github.com/busoc/panda/cmd/internal/pp"
+	"github.com/busoc/panda/cmd/internal/tm"
+	"github.com/busoc/panda/cmd/internal/version"
+	"github.com/midbel/cli"
+)
+
+func runWatch(cmd *cli.Command, args []string) error {
+	kind := cmd.Flag.String("k", "tm", "packet kind")
+	every := cmd.Flag.Duration("i", time.Second, "refresh interval")
+	var cert httpx.TLS
+	addr := cmd.Flag.String("m", "", "metrics address")
+	cmd.Flag.StringVar(&cert.Cert, "cert", "", "tls certificate")
+	cmd.Flag.StringVar(&cert.Key, "key", "", "tls key")
+	cmd.Flag.StringVar(&cert.CA, "ca", "", "ca of client certificates")
+	quiet := cmd.Flag.Bool("q", false, "quiet")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if cmd.Flag.NArg() == 0 {
+		return fmt.Errorf("no group given")
+	}
+	if *every <= 0 {
+		*every = time.Second
+	}
+	w := &watcher{counters: make(map[string]*counter)}
+
+	var wg sync.WaitGroup
+	for _, g := range cmd.Flag.Args() {
+		switch *kind {
+		default:
+			return fmt.Errorf("unsupported packet kind %q", *kind)
+		case "tm":
+			q, err := tm.Packets(g, 0, nil)
+			if err != nil {
+				return err
+			}
+			wg.Add(1)
+			go func(g string) {
+				defer wg.Done()
+				for p := range q {
+					w.Update(g, fmt.Sprint(p.Apid()), p.Len()+panda.CCSDSLength, p.Sequence(), p.Timestamp())
+				}
+			}(g)
+		case "pp":
+			q, err := pp.Packets(g, nil)
+			if err != nil {
+				return err
+			}
+			wg.Add(1)
+			go func(g string) {
+				defer wg.Done()
+				for p := range q {
+					w.Update(g, fmt.Sprintf("%x", p.Code), panda.UMILength+len(p.Data), -1, p.Timestamp())
+				}
+			}(g)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if len(*addr) > 0 {
+		http.Handle("/metrics", w)
+		http.Handle("/version", version.Handler())
+		s, err := httpx.Server(*addr, httpx.Wrap(http.DefaultServeMux, nil, nil), cert)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := httpx.ListenAndServe(s); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+	tick := time.NewTicker(*every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			w.Tick(*every)
+			if !*quiet {
+				w.Render(os.Stdout)
+			}
+		case <-done:
+			w.Tick(*every)
+			if !*quiet {
+				w.Render(os.Stdout)
+			}
+			return nil
+		}
+	}
+}
+
+type counter struct {
+	Group  string
+	Id     string
+	Count  uint64
+	Size   uint64
+	Gaps   uint64
+	Jitter float64
+
+	Packets float64
+	Bytes   float64
+
+	count   uint64
+	size    uint64
+	seq     int
+	arrival time.Time
+	stamp   time.Time
+}
+
+type watcher struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+}
+
+func (w *watcher) Update(g, id string, size, seq int, t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	k := g + "/" + id
+	c, ok := w.counters[k]
+	if !ok {
+		c = &counter{Group: g, Id: id, seq: -1}
+		w.counters[k] = c
+	}
+	n := time.Now()
+	if !c.arrival.IsZero() {
+		d := n.Sub(c.arrival) - t.Sub(c.stamp)
+		c.Jitter += (math.Abs(d.Seconds()) - c.Jitter) / 16
+	}
+	if seq >= 0 {
+		if c.seq >= 0 && (seq-c.seq)&0x3FFF != 1 {
+			c.Gaps++
+		}
+		c.seq = seq
+	}
+	c.Count++
+	c.Size += uint64(size)
+	c.arrival, c.stamp = n, t
+}
+
+func (w *watcher) Tick(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, c := range w.counters {
+		c.Packets = float64(c.Count-c.count) / d.Seconds()
+		c.Bytes = float64(c.Size-c.size) / d.Seconds()
+		c.count, c.size = c.Count, c.Size
+	}
+}
+
+func (w *watcher) Render(ws io.Writer) {
+	const pattern = "%-24s | %-12s | %10.1f | %12.1f | %10d | %14d | %6d | %10.6f\n"
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	fmt.Fprint(ws, "\x1b[H\x1b[2J")
+	fmt.Fprintf(ws, "%-24s | %-12s | %10s | %12s | %10s | %14s | %6s | %10s\n", "group", "id", "pkts/s", "bytes/s", "packets", "bytes", "gaps", "jitter")
+	for _, c := range w.sorted() {
+		fmt.Fprintf(ws, pattern, c.Group, c.Id, c.Packets, c.Bytes, c.Count, c.Size, c.Gaps, c.Jitter)
+	}
+}
+
+func (w *watcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rw.Header().Set("content-type", "text/plain; version=0.0.4")
+	metrics := []struct {
+		Name  string
+		Type  string
+		Value func(*counter) interface{}
+	}{
+		{"panda_watch_packets_total", "counter", func(c *counter) interface{} { return c.Count }},
+		{"panda_watch_bytes_total", "counter", func(c *counter) interface{} { return c.Size }},
+		{"panda_watch_gaps_total", "counter", func(c *counter) interface{} { return c.Gaps }},
+		{"panda_watch_packets_rate", "gauge", func(c *counter) interface{} { return c.Packets }},
+		{"panda_watch_bytes_rate", "gauge", func(c *counter) interface{} { return c.Bytes }},
+		{"panda_watch_jitter_seconds", "gauge", func(c *counter) interface{} { return c.Jitter }},
+	}
+	cs := w.sorted()
+	for _, m := range metrics {
+		fmt.Fprintf(rw, "# TYPE %s %s\n", m.Name, m.Type)
+		for _, c := range cs {
+			fmt.Fprintf(rw, "%s{group=%q,id=%q} %v\n", m.Name, c.Group, c.Id, m.Value(c))
+		}
+	}
+}
+
+func (w *watcher) sorted() []*counter {
+	cs := make([]*counter, 0, len(w.counters))
+	for _, c := range w.counters {
+		cs = append(cs, c)
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].Group == cs[j].Group {
+			return cs[i].Id < cs[j].Id
+		}
+		return cs[i].Group < cs[j].Group
+	})
+	return cs
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/opts"
+	"github.com/busoc/panda/cmd/internal/pp"
+	"github.com/midbel/cli"
+)
+
+func runCodes(cmd *cli.Command, args []string) error {
+	var (
+		codes opts.UMISet
+		fine  panda.FineTime
+		skip  panda.Flag
+	)
+	cmd.Flag.Var(&codes, "u", "umi code")
+	cmd.Flag.Var(&fine, "f", "fine time scale")
+	cmd.Flag.Var(&skip, "k", "skip flagged packets")
+	gps := cmd.Flag.Bool("g", false, "gps time")
+	format := cmd.Flag.String("format", "", "output format (csv, json)")
+	window := cmd.Flag.Duration("d", 0, "stop reading after duration")
+	from := cmd.Flag.String("from", "", "start time")
+	to := cmd.Flag.String("to", "", "end time")
+	workers := cmd.Flag.Int("j", 1, "files read concurrently")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	var write func(io.Writer, []*codeUsage, string) error
+	switch *format {
+	case "":
+		write = printCodes
+	case "csv":
+		write = printCodesCSV
+	case "json":
+		write = printCodesJSON
+	default:
+		return fmt.Errorf("unsupported format: %s", *format)
+	}
+	dtstart, err := parseTime(*from)
+	if err != nil {
+		return err
+	}
+	dtend, err := parseTime(*to)
+	if err != nil {
+		return err
+	}
+	r, err := pp.OpenBetween(cmd.Flag.Arg(0), dtstart, dtend, panda.SkipFlagged(skip), panda.WithWorkers(*workers))
+	if err != nil {
+		return err
+	}
+	accept := panda.WithPredicate(pp.NewPredicate(codes))
+	queue := pp.FilterReport(context.Background(), r, panda.DecodePP(panda.WithFineTime(fine)), reportError, accept)
+
+	var stop <-chan time.Time
+	if *window > 0 {
+		stop = time.After(*window)
+	}
+	seen := make(map[[6]byte]*codeUsage)
+Loop:
+	for {
+		select {
+		case p, ok := <-queue:
+			if !ok {
+				break Loop
+			}
+			t := p.Timestamp()
+			if !*gps {
+				t = t.Add(panda.GPS.Sub(panda.UNIX))
+			}
+			if (!dtstart.IsZero() && t.Before(dtstart)) || (!dtend.IsZero() && !t.Before(dtend)) {
+				continue
+			}
+			u, ok := seen[p.Code]
+			if !ok {
+				u = &codeUsage{Code: fmt.Sprintf("0x%x", p.Code)}
+				seen[p.Code] = u
+			}
+			u.Update(p, t)
+		case <-stop:
+			break Loop
+		}
+	}
+	us := make([]*codeUsage, 0, len(seen))
+	for _, u := range seen {
+		us = append(us, u)
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].Code < us[j].Code })
+	return write(os.Stdout, us, fine.Layout())
+}
+
+// codeUsage describes the values of one UMI code seen in a source, Sample being
+// the last of them.
+type codeUsage struct {
+	Code   string    `json:"code"`
+	Type   string    `json:"type"`
+	Count  int       `json:"count"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Sample string    `json:"sample"`
+}
+
+func (u *codeUsage) Update(p panda.Parameter, t time.Time) {
+	if u.Count == 0 || t.Before(u.First) {
+		u.First = t
+	}
+	if !t.Before(u.Last) {
+		u.Last = t
+		u.Type, u.Sample = p.Type.String(), fmt.Sprint(p.Value())
+	}
+	u.Count++
+}
+
+func printCodes(w io.Writer, us []*codeUsage, layout string) error {
+	const pattern = "%s | %-12s | %8d | %s | %s | %v\n"
+	for _, u := range us {
+		fmt.Fprintf(w, pattern, u.Code, u.Type, u.Count, u.First.Format(layout), u.Last.Format(layout), u.Sample)
+	}
+	return nil
+}
+
+func printCodesCSV(w io.Writer, us []*codeUsage, layout string) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"code", "type", "count", "first", "last", "sample"})
+	for _, u := range us {
+		c.Write([]string{
+			u.Code,
+			u.Type,
+			strconv.Itoa(u.Count),
+			u.First.Format(layout),
+			u.Last.Format(layout),
+			u.Sample,
+		})
+	}
+	c.Flush()
+	return c.Error()
+}
+
+func printCodesJSON(w io.Writer, us []*codeUsage, _ string) error {
+	return json.NewEncoder(w).Encode(us)
+}
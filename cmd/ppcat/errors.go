@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/busoc/panda"
+)
+
+type ErrorCodes map[uint32]string
+
+func LoadErrorCodes(file string) (ErrorCodes, error) {
+	es := make(ErrorCodes)
+	if file == "" {
+		return es, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		t := strings.TrimSpace(s.Text())
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		vs := strings.SplitN(t, " ", 2)
+		c, err := strconv.ParseUint(vs[0], 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		if len(vs) > 1 {
+			es[uint32(c)] = strings.TrimSpace(vs[1])
+		} else {
+			es[uint32(c)] = ""
+		}
+	}
+	return es, s.Err()
+}
+
+func (e ErrorCodes) Describe(c uint32) string {
+	if d, ok := e[c]; ok && d != "" {
+		return d
+	}
+	return "unknown error"
+}
+
+type occurrence struct {
+	Error uint32
+	Code  [6]byte
+	Count int
+	First time.Time
+	Last  time.Time
+}
+
+type ErrorReport struct {
+	codes ErrorCodes
+	items map[string]*occurrence
+}
+
+func NewErrorReport(es ErrorCodes) *ErrorReport {
+	return &ErrorReport{codes: es, items: make(map[string]*occurrence)}
+}
+
+func (r *ErrorReport) Update(p panda.Parameter, t time.Time) {
+	c := binary.BigEndian.Uint32(p.Orbit[:])
+	if c == 0 {
+		return
+	}
+	k := fmt.Sprintf("%08x:%x", c, p.Code)
+	o, ok := r.items[k]
+	if !ok {
+		o = &occurrence{Error: c, Code: p.Code, First: t}
+		r.items[k] = o
+	}
+	o.Count++
+	o.Last = t
+}
+
+func (r *ErrorReport) Print(layout string) {
+	const pattern = "%08x | %-32s | %x | %6d | %s | %s\n"
+
+	vs := make([]*occurrence, 0, len(r.items))
+	for _, o := range r.items {
+		vs = append(vs, o)
+	}
+	sort.Slice(vs, func(i, j int) bool {
+		if vs[i].Error == vs[j].Error {
+			return string(vs[i].Code[:]) < string(vs[j].Code[:])
+		}
+		return vs[i].Error < vs[j].Error
+	})
+	for _, o := range vs {
+		fmt.Printf(pattern, o.Error, r.codes.Describe(o.Error), o.Code, o.Count, o.First.Format(layout), o.Last.Format(layout))
+	}
+}
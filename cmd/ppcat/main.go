@@ -1,21 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"sync/atomic"
 	"text/template"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/auth"
+	"github.com/busoc/panda/cmd/internal/dump"
+	"github.com/busoc/panda/cmd/internal/httpx"
 	"github.com/busoc/panda/cmd/internal/opts"
 	"github.com/busoc/panda/cmd/internal/pp"
+	"github.com/busoc/panda/cmd/internal/replay"
+	"github.com/busoc/panda/cmd/internal/rw"
+	"github.com/busoc/panda/cmd/internal/version"
 	"github.com/midbel/cli"
 	"github.com/midbel/toml"
 )
@@ -23,7 +32,7 @@ import (
 var commands = []*cli.Command{
 	{
 		Run:   runShow,
-		Usage: "show [-u] [-g] [-e] [-c] <source>",
+		Usage: "show [-u] [-g] [-e] [-c] [-f] [-k] [-o] [-errors] [-d] [-from] [-to] [-j] [-m] [-x] <source>",
 		Alias: []string{"dump"},
 		Short: "",
 	},
@@ -32,6 +41,16 @@ var commands = []*cli.Command{
 		Usage: "distrib <config.toml>",
 		Short: "",
 	},
+	{
+		Run:   runReplay,
+		Usage: "replay [-d] [-u] [-r] [-l] [-g] <group>",
+		Short: "",
+	},
+	{
+		Run:   runCodes,
+		Usage: "codes [-u] [-f] [-k] [-g] [-format csv|json] [-d] [-from] [-to] [-j] <source>",
+		Short: "list the UMI codes found in a source",
+	},
 }
 
 const helpText = `{{.Name}} prints PP packet headers.
@@ -50,6 +69,10 @@ Use {{.Name}} [command] -h for more information about its usage.
 
 func main() {
 	log.SetFlags(0)
+	if version.Requested(os.Args[1:]) {
+		version.Print(os.Stdout)
+		return
+	}
 	if err := cli.Run(commands, usage, nil); err != nil {
 		log.Fatalln(err)
 	}
@@ -72,21 +95,85 @@ func usage() {
 func runShow(cmd *cli.Command, args []string) error {
 	const pattern = "%s | %-12s | %x | %x | %-12s | %6d | %5d | %-24x | %-v\n"
 
-	var codes opts.UMISet
+	var (
+		codes opts.UMISet
+		fine  panda.FineTime
+		skip  panda.Flag
+	)
 	cmd.Flag.Var(&codes, "u", "umi code")
+	cmd.Flag.Var(&fine, "f", "fine time scale")
+	cmd.Flag.Var(&skip, "k", "skip flagged packets")
 	gps := cmd.Flag.Bool("g", false, "gps time")
 	all := cmd.Flag.Bool("a", false, "show all")
 	erronly := cmd.Flag.Bool("e", false, "show error only")
 	errcode := cmd.Flag.Uint("c", 0, "show error with code")
+	output := cmd.Flag.String("o", "", "output")
+	errors := cmd.Flag.Bool("errors", false, "report errors")
+	dict := cmd.Flag.String("d", "", "error codes")
+	from := cmd.Flag.String("from", "", "start time")
+	to := cmd.Flag.String("to", "", "end time")
+	hexdump := cmd.Flag.Bool("x", false, "annotated hex dump")
+	workers := cmd.Flag.Int("j", 1, "files read concurrently")
+	merge := cmd.Flag.Bool("m", false, "merge files in time order")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	queue, err := pp.Packets(cmd.Flag.Arg(0), codes)
+	dtstart, err := parseTime(*from)
 	if err != nil {
 		return err
 	}
+	dtend, err := parseTime(*to)
+	if err != nil {
+		return err
+	}
+	es, err := LoadErrorCodes(*dict)
+	if err != nil {
+		return err
+	}
+	walk := []panda.WalkOption{panda.SkipFlagged(skip), panda.WithWorkers(*workers)}
+	if *merge {
+		walk = append(walk, panda.WithMerge())
+	}
+	r, err := pp.OpenBetween(cmd.Flag.Arg(0), dtstart, dtend, walk...)
+	if err != nil {
+		return err
+	}
+	accept := panda.WithPredicate(pp.NewPredicate(codes))
+	queue := pp.FilterReport(context.Background(), r, panda.DecodePP(panda.WithFineTime(fine)), reportError, accept)
+	var ws *panda.Writer
+	if *output != "" {
+		var w io.Writer
+		if *output == "-" {
+			w = os.Stdout
+		} else {
+			f, err := os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if ws, err = panda.NewWriter("pp", w); err != nil {
+			return err
+		}
+	}
+	var report *ErrorReport
+	if *errors {
+		report = NewErrorReport(es)
+	}
 	for p := range queue {
 		u := p.UMIHeader
+		t := u.Timestamp()
+		if !*gps {
+			t = t.Add(panda.GPS.Sub(panda.UNIX))
+		}
+		if (!dtstart.IsZero() && t.Before(dtstart)) || (!dtend.IsZero() && !t.Before(dtend)) {
+			continue
+		}
+		if report != nil {
+			report.Update(p, t)
+			continue
+		}
 		if !*all {
 			orbit := binary.BigEndian.Uint32(u.Orbit[:])
 			if *erronly && orbit == 0 {
@@ -101,13 +188,14 @@ func runShow(cmd *cli.Command, args []string) error {
 				continue
 			}
 		}
-		t := u.Timestamp()
-		if !*gps {
-			t = t.Add(panda.GPS.Sub(panda.UNIX))
+		if ws != nil {
+			if err := ws.Write(p); err != nil {
+				return err
+			}
+			continue
 		}
-
 		fmt.Printf(pattern,
-			t.Format("2006-01-02T15:04:05.000Z"),
+			t.Format(fine.Layout()),
 			u.State,
 			u.Orbit,
 			u.Code,
@@ -117,78 +205,250 @@ func runShow(cmd *cli.Command, args []string) error {
 			p.Data,
 			p.Value(),
 		)
+		if *hexdump {
+			dump.Parameter(os.Stdout, p)
+		}
+	}
+	if report != nil {
+		report.Print(fine.Layout())
 	}
 	return nil
 }
 
+func reportError(err error) {
+	fmt.Fprintf(os.Stderr, "skipping packet: %s\n", err)
+}
+
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
 func runDistrib(cmd *cli.Command, args []string) error {
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	f, err := os.Open(cmd.Flag.Arg(0))
+	var (
+		addr    string
+		cert    httpx.TLS
+		proxies []string
+		count   int32
+		guard   = new(auth.Guard)
+	)
+	load := func() (http.Handler, error) {
+		f, err := os.Open(cmd.Flag.Arg(0))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		c := struct {
+			Addr     string         `toml:"server"`
+			Group    string         `toml:"group"`
+			Clients  int32          `toml:"clients"`
+			Cors     []string       `toml:"cors"`
+			Proxies  []string       `toml:"proxies"`
+			Record   string         `toml:"record"`
+			Beat     int            `toml:"heartbeat"`
+			Idle     int            `toml:"idle"`
+			Allow    []string       `toml:"allow"`
+			Accounts []auth.Account `toml:"account"`
+			TLS      httpx.TLS      `toml:"tls"`
+		}{}
+		if err := toml.NewDecoder(f).Decode(&c); err != nil {
+			return nil, err
+		}
+		if addr == "" {
+			addr, cert, proxies = c.Addr, c.TLS, c.Proxies
+		} else if c.Addr != addr || c.TLS != cert {
+			log.Printf("new address or tls settings ignored: restart needed")
+		}
+
+		var allow []pp.Code
+		for _, v := range c.Allow {
+			a, err := pp.ParseCode(v)
+			if err != nil {
+				return nil, err
+			}
+			allow = append(allow, a)
+		}
+		mux := http.NewServeMux()
+		h := distribute(c.Group, c.Clients, &count, c.Record, allow, time.Duration(c.Beat)*time.Second, time.Duration(c.Idle)*time.Second)
+		mux.Handle("/", guard.Protect(h, nil))
+		mux.Handle("/version", version.Handler())
+
+		// the accounts are only replaced once the rest of the configuration
+		// is known to be valid.
+		if err := guard.Load(c.Accounts); err != nil {
+			return nil, err
+		}
+		return httpx.CORS(c.Cors)(mux), nil
+	}
+	h, err := httpx.Reload("/reload", load, guard.Admin)
+	if err != nil {
+		return err
+	}
+	fwd, err := httpx.Forwarded(proxies)
+	if err != nil {
+		return fmt.Errorf("invalid proxies: %s", err)
+	}
+	s, err := httpx.Server(addr, httpx.Chain(h, httpx.RequestID, fwd, httpx.Log, httpx.Recover), cert)
 	if err != nil {
 		return err
 	}
-	c := struct {
-		Addr    string `toml:"server"`
-		Group   string `toml:"group"`
-		Clients int32  `toml:"clients"`
-	}{}
-	if err := toml.NewDecoder(f).Decode(&c); err != nil {
+	return httpx.ListenAndServe(s)
+}
+
+func runReplay(cmd *cli.Command, args []string) error {
+	var (
+		gap   opts.Gap
+		codes opts.UMISet
+	)
+	cmd.Flag.Var(&gap, "g", "gap")
+	cmd.Flag.Var(&codes, "u", "umi code")
+	loop := cmd.Flag.Int("l", 0, "loop")
+	rate := cmd.Flag.Int("r", 0, "rate")
+	datadir := cmd.Flag.String("d", os.TempDir(), "datadir")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	c, err := net.Dial("udp", cmd.Flag.Arg(0))
+	if err != nil {
 		return err
 	}
-	f.Close()
+	defer c.Close()
+	w := replay.Conn(c, &gap)
+	for i := 0; *loop <= 0 || i < *loop; i++ {
+		if err := play(w, *datadir, codes, *rate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	http.Handle("/", distribute(c.Group, c.Clients))
-	return http.ListenAndServe(c.Addr, nil)
+func play(c net.Conn, datadir string, codes []pp.Code, rate int) error {
+	queue, err := pp.Packets(datadir, codes)
+	if err != nil {
+		return err
+	}
+	ws, err := panda.NewWriter("pp", c)
+	if err != nil {
+		return err
+	}
+	pace := replay.NewPacer(rate)
+	for p := range queue {
+		pace.Wait(p.Timestamp())
+		if err := ws.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func distribute(a string, c int32) http.Handler {
+// distribute serves the packets of the group a, count being the number of
+// connected clients shared by the handlers of successive configurations.
+func distribute(a string, c int32, count *int32, rec string, allow []pp.Code, beat, idle time.Duration) http.Handler {
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 		CheckOrigin:     func(r *http.Request) bool { return true },
 	}
-	var count int32
 	f := func(w http.ResponseWriter, r *http.Request) {
-		curr := atomic.AddInt32(&count, 1)
+		curr := atomic.AddInt32(count, 1)
+		defer atomic.AddInt32(count, -1)
 		if c > 0 && curr >= c {
-			w.WriteHeader(http.StatusTooManyRequests)
+			httpx.Error(w, fmt.Errorf("too many clients connected"), http.StatusTooManyRequests)
 			return
 		}
-		defer atomic.AddInt32(&count, -1)
 
 		q := r.URL.Query()
-		var cs []uint64
+		var cs []pp.Code
 		for _, v := range q["umi[]"] {
-			c, err := strconv.ParseUint(v, 0, 64)
+			c, err := pp.ParseCode(v)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				httpx.Error(w, err, http.StatusBadRequest)
 				return
 			}
 			cs = append(cs, c)
 		}
+		s, err := rw.Record(rec, "pp", "umi", httpx.ID(r))
+		if err != nil {
+			httpx.Error(w, err, http.StatusInternalServerError)
+			return
+		}
+		defer s.Close()
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		defer conn.Close()
 
-		queue, err := pp.Packets(a, cs)
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		queue, err := pp.PacketsContext(ctx, a, cs)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			msg := websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error())
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
 			return
 		}
-		for p := range queue {
-			bs, err := p.Bytes()
-			if err != nil {
-				continue
+		if idle > 0 {
+			conn.SetReadDeadline(time.Now().Add(idle))
+			conn.SetPongHandler(func(string) error {
+				return conn.SetReadDeadline(time.Now().Add(idle))
+			})
+		}
+		go func() {
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					conn.Close()
+					return
+				}
 			}
-			if err := conn.WriteMessage(websocket.BinaryMessage, bs); err != nil {
-				return
+		}()
+		var tick <-chan time.Time
+		if beat > 0 {
+			t := time.NewTicker(beat)
+			defer t.Stop()
+			tick = t.C
+		}
+		for {
+			select {
+			case p, ok := <-queue:
+				if !ok {
+					return
+				}
+				if !allowCode(allow, p.Code) {
+					continue
+				}
+				bs, err := p.Bytes()
+				if err != nil {
+					continue
+				}
+				if err := conn.WriteMessage(websocket.BinaryMessage, bs); err != nil {
+					return
+				}
+				s.Write(p)
+			case <-tick:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(beat)); err != nil {
+					return
+				}
 			}
 		}
 	}
 	return http.HandlerFunc(f)
 }
+
+func allowCode(cs []pp.Code, c [6]byte) bool {
+	if len(cs) == 0 {
+		return true
+	}
+	for _, a := range cs {
+		if a.Match(c) {
+			return true
+		}
+	}
+	return false
+}
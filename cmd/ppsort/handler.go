@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,20 +9,30 @@ import (
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
-	"strconv"
 	"time"
 
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/client"
+	"github.com/busoc/panda/cmd/internal/auth"
+	"github.com/busoc/panda/cmd/internal/httpx"
 	"github.com/busoc/panda/cmd/internal/pool"
 	"github.com/busoc/panda/cmd/internal/pp"
+	"github.com/busoc/panda/cmd/internal/rw"
+	"github.com/busoc/panda/hrdp"
 )
 
+const slice = hrdp.Slot
+
 type query struct {
-	Codes []uint64  `json:"codes"`
-	Start time.Time `json:"dtstart"`
-	End   time.Time `json:"dtend"`
+	Codes  []pp.Code `json:"codes"`
+	Start  time.Time `json:"dtstart"`
+	End    time.Time `json:"dtend"`
+	Local  bool      `json:"local"`
+	Framed bool      `json:"framed"`
 
 	filename string
+	skip     panda.Flag
+	client   *http.Client
 }
 
 func Validate(r io.Reader, d, i time.Duration) (*query, error) {
@@ -40,37 +50,128 @@ func Validate(r io.Reader, d, i time.Duration) (*query, error) {
 	return &q, nil
 }
 
-func (q *query) Write(d string, w io.Writer) error {
-	for p := range q.next(d) {
-		r, err := pp.Packets(p, q.Codes)
-		if err != nil {
-			return err
+func (q *query) Write(ctx context.Context, d string, peers []string, w io.Writer) (httpx.Provenance, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var origins httpx.Provenance
+
+	if q.Framed {
+		w = frames{w}
+	}
+	w = rw.NoDuplicate(w)
+	for s := range q.next(ctx, d) {
+		var (
+			r   <-chan panda.Parameter
+			src = "local"
+		)
+		switch {
+		case s.File != "":
+			x, err := pp.OpenContext(ctx, s.File, panda.SkipFlagged(q.skip))
+			if err != nil {
+				return origins, err
+			}
+			r = pp.FilterContext(ctx, x, panda.DecodePP(), panda.WithPredicate(pp.NewPredicate(q.Codes)))
+		case !q.Local:
+			r, src = q.fetch(ctx, s.When, peers)
+		}
+		if r == nil {
+			continue
 		}
+		var count int
 		for p := range r {
 			bs, e := p.Bytes()
 			if e != nil {
 				continue
 			}
 			if _, err := w.Write(bs); err != nil {
-				return err
+				return origins, err
 			}
+			count++
+		}
+		if count > 0 {
+			origins = append(origins, httpx.Origin{Start: s.When, Source: src})
 		}
 	}
-	return nil
+	return origins, ctx.Err()
+}
+
+// frames writes the PP packets it is given preceded by the header of the HRD
+// frames.
+type frames struct {
+	io.Writer
+}
+
+func (f frames) Write(bs []byte) (int, error) {
+	_, p, err := panda.DecodePP().Decode(bs)
+	if err != nil {
+		return 0, err
+	}
+	fs, err := panda.Frame(p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Writer.Write(fs); err != nil {
+		return 0, err
+	}
+	return len(bs), nil
+}
+
+// fetch asks the peers in turn for the packets of the slice starting at t that
+// is missing from the local archive. The packets of the first peer having some
+// are given with its address.
+func (q *query) fetch(ctx context.Context, t time.Time, peers []string) (<-chan panda.Parameter, string) {
+	v := query{
+		Codes: q.Codes,
+		Start: t,
+		End:   t.Add(slice - time.Nanosecond),
+		Local: true,
+	}
+	for _, p := range peers {
+		rc, err := httpx.Fetch(ctx, q.client, p, v)
+		if err != nil {
+			log.Printf("fail to fetch %s from peer: %s", t.Format(time.RFC3339), err)
+			continue
+		}
+		if rc == nil {
+			continue
+		}
+		r, _ := client.Reader("pp", rc)
+		x := struct {
+			io.Reader
+			io.Closer
+		}{r, rc}
+		return pp.FilterContext(ctx, x, pp.NewDecoder(q.Codes)), p
+	}
+	return nil, ""
+}
+
+func isFile(p string) bool {
+	i, err := os.Stat(p)
+	return err == nil && i.Mode().IsRegular()
+}
+
+type slot struct {
+	When time.Time
+	File string
 }
 
-func (q *query) next(base string) <-chan string {
-	ch := make(chan string)
+// next gives the slices of the interval of q with the file holding their
+// packets, File being empty when the slice is not in the archive.
+func (q *query) next(ctx context.Context, base string) <-chan slot {
+	ch := make(chan slot)
 	go func() {
 		defer close(ch)
-		for n := q.Start; n.Before(q.End); n = n.Add(time.Minute * 5) {
-			y, d, h := n.Year(), n.YearDay(), n.Hour()
-			n := fmt.Sprintf("rt_%02d_%02d.dat", n.Minute(), n.Minute()+4)
-			p := filepath.Join(base, fmt.Sprintf("%04d", y), fmt.Sprintf("%03d", d), fmt.Sprintf("%02d", h), n)
-			if i, err := os.Stat(p); err != nil || !i.Mode().IsRegular() {
-				continue
+		for n := q.Start; n.Before(q.End); n = n.Add(slice) {
+			s := slot{When: n}
+			if p := hrdp.File(base, n); isFile(p) {
+				s.File = p
+			}
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+				return
 			}
-			ch <- p
 		}
 	}()
 	return ch
@@ -78,10 +179,12 @@ func (q *query) next(base string) <-chan string {
 
 func (q *query) UnmarshalJSON(bs []byte) error {
 	v := struct {
-		Codes []string  `json:"codes"`
-		Start time.Time `json:"dtstart"`
-		End   time.Time `json:"dtend"`
-		Name  string    `json:"filename"`
+		Codes  []string  `json:"codes"`
+		Start  time.Time `json:"dtstart"`
+		End    time.Time `json:"dtend"`
+		Name   string    `json:"filename"`
+		Local  bool      `json:"local"`
+		Framed bool      `json:"framed"`
 	}{}
 	if err := json.Unmarshal(bs, &v); err != nil {
 		return err
@@ -90,7 +193,7 @@ func (q *query) UnmarshalJSON(bs []byte) error {
 		return fmt.Errorf("no umi codes provided")
 	}
 	for _, c := range v.Codes {
-		if c, err := strconv.ParseUint(c, 0, 64); err != nil {
+		if c, err := pp.ParseCode(c); err != nil {
 			return err
 		} else {
 			q.Codes = append(q.Codes, c)
@@ -99,8 +202,8 @@ func (q *query) UnmarshalJSON(bs []byte) error {
 	if v.Start.After(v.End) {
 		return fmt.Errorf("invalid interval: %s < %s", q.End, q.Start)
 	}
-	t := time.Minute * 5
-	q.filename, q.Start, q.End = v.Name, v.Start.Truncate(t), v.End.Add(t).Truncate(t)
+	q.filename, q.Start, q.End = v.Name, v.Start.Truncate(slice), v.End.Add(slice).Truncate(slice)
+	q.Local, q.Framed = v.Local, v.Framed
 	return nil
 }
 
@@ -116,6 +219,10 @@ type Archive struct {
 	Datadir  string
 	Delay    time.Duration
 	Interval time.Duration
+	Peers    []string
+	Client   *http.Client
+	Skip     panda.Flag
+	Audit    *log.Logger
 }
 
 func (a *Archive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -130,33 +237,58 @@ func (a *Archive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	q, err := Validate(r.Body, a.Delay, a.Interval)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpx.Error(w, err, http.StatusBadRequest)
 		return
 	}
-	// buf := new(bytes.Buffer)
-	var buf bytes.Buffer
-	defer buf.Reset()
-	if err := q.Write(a.Datadir, &buf); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	q.skip, q.client = a.Skip, a.Client
+	if a.Audit != nil {
+		a.Audit.Printf("id=%s client=%s account=%s codes=%s dtstart=%s dtend=%s", httpx.ID(r), httpx.Client(r), auth.Name(r), q.Codes, q.Start.Format(time.RFC3339), q.End.Format(time.RFC3339))
+	}
+	ws := &stream{ResponseWriter: w, request: r, filename: q.String()}
+	w.Header().Set("Trailer", httpx.ProvenanceHeader)
+	origins, err := q.Write(r.Context(), a.Datadir, a.Peers, ws)
+	if err != nil {
+		if !ws.written && err == auth.ErrQuota {
+			httpx.Error(w, err, http.StatusTooManyRequests)
+		} else if !ws.written {
+			httpx.Error(w, err, http.StatusInternalServerError)
+		} else {
+			log.Printf("id=%s: %s", httpx.ID(r), err)
+		}
 		return
 	}
-	if buf.Len() == 0 {
+	if !ws.written {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	w.Header().Set("content-type", "application/octet-stream")
-	w.Header().Set("content-length", fmt.Sprint(buf.Len()))
-	w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", q.String()))
-	if _, err := io.Copy(w, &buf); err != nil {
-		log.Println(err)
+	w.Header().Set(httpx.ProvenanceHeader, origins.String())
+}
+
+type stream struct {
+	http.ResponseWriter
+	request  *http.Request
+	filename string
+	written  bool
+}
+
+func (s *stream) Write(bs []byte) (int, error) {
+	if err := auth.Use(s.request, len(bs)); err != nil {
+		return 0, err
 	}
+	if !s.written {
+		s.Header().Set("content-type", "application/octet-stream")
+		s.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", s.filename))
+		s.written = true
+	}
+	return s.ResponseWriter.Write(bs)
 }
 
 func (a *Archive) UnmarshalJSON(bs []byte) error {
 	v := struct {
-		Datadir  string `json:"datadir"`
-		Delay    uint   `json:"delay"`
-		Interval uint   `json:"interval"`
+		Datadir  string   `json:"datadir"`
+		Delay    uint     `json:"delay"`
+		Interval uint     `json:"interval"`
+		Peers    []string `json:"peers"`
 	}{}
 	if err := json.Unmarshal(bs, &v); err != nil {
 		return err
@@ -167,7 +299,7 @@ func (a *Archive) UnmarshalJSON(bs []byte) error {
 	if a == nil {
 		a = new(Archive)
 	}
-	a.Datadir = v.Datadir
+	a.Datadir, a.Peers = v.Datadir, v.Peers
 	if v.Interval == 0 {
 		v.Interval = 6 * 3600
 	}
@@ -189,16 +321,6 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		err  error
 		data interface{}
 	)
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	if h := r.Header.Get("Access-Control-Request-Headers"); len(h) > 0 {
-		w.Header().Set("Access-Control-Allow-Headers", h)
-	}
-	if r.Method == http.MethodOptions {
-		w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 	switch r.Method {
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -216,15 +338,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		err = h.Stop(n)
 	}
 	if err != nil {
-		v := struct {
-			Err  string `json:"error"`
-			Code int    `json:"code"`
-		}{
-			Err:  err.Error(),
-			Code: http.StatusBadRequest,
-		}
-		json.NewEncoder(w).Encode(v)
-		w.WriteHeader(http.StatusBadRequest)
+		httpx.Error(w, err, http.StatusBadRequest)
 		return
 	}
 	if data == nil {
@@ -13,8 +13,13 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/auth"
+	"github.com/busoc/panda/cmd/internal/httpx"
 	"github.com/busoc/panda/cmd/internal/opts"
 	"github.com/busoc/panda/cmd/internal/pool"
+	"github.com/busoc/panda/cmd/internal/pp"
+	"github.com/busoc/panda/cmd/internal/version"
 	"github.com/midbel/cli"
 	"github.com/midbel/toml"
 )
@@ -26,7 +31,7 @@ var commands = []*cli.Command{
 		Run:   runDispatch,
 	},
 	{
-		Usage: "filter [-u] [-n] [-d] [-c] [-e] <path...>",
+		Usage: "filter [-u] [-n] [-d] [-c] [-e] [-s] [-p] [-w] [-l] [-f] <path...>",
 		Short: "filter PP packets from the HRDP archive",
 		Run:   runFilter,
 	},
@@ -72,6 +77,10 @@ func main() {
 
 		os.Exit(2)
 	}
+	if version.Requested(os.Args[1:]) {
+		version.Print(os.Stdout)
+		return
+	}
 	if err := cli.Run(commands, usage, nil); err != nil {
 		log.Fatalln(err)
 	}
@@ -88,11 +97,18 @@ func runDistrib(cmd *cli.Command, args []string) error {
 	defer f.Close()
 
 	c := struct {
-		Addr     string `toml:"address"`
-		Prefix   string `toml:"prefix"`
-		Datadir  string `toml:"datadir"`
-		Delay    int    `toml:"delay"`
-		Interval int    `toml:"interval"`
+		Addr     string         `toml:"address"`
+		Prefix   string         `toml:"prefix"`
+		Datadir  string         `toml:"datadir"`
+		Delay    int            `toml:"delay"`
+		Interval int            `toml:"interval"`
+		Cors     []string       `toml:"cors"`
+		Proxies  []string       `toml:"proxies"`
+		Audit    string         `toml:"audit"`
+		Peers    []string       `toml:"peers"`
+		Skip     panda.Flag     `toml:"skip"`
+		Accounts []auth.Account `toml:"account"`
+		TLS      httpx.TLS      `toml:"tls"`
 	}{}
 	if err := toml.NewDecoder(f).Decode(&c); err != nil {
 		return fmt.Errorf("invalid settings provided: %s", err)
@@ -101,9 +117,30 @@ func runDistrib(cmd *cli.Command, args []string) error {
 		Datadir:  c.Datadir,
 		Delay:    time.Duration(c.Delay) * time.Second,
 		Interval: time.Duration(c.Interval) * time.Second,
+		Peers:    c.Peers,
+		Skip:     c.Skip,
+	}
+	if a.Client, err = c.TLS.Client(); err != nil {
+		return err
+	}
+	if a.Audit, err = httpx.Audit(c.Audit); err != nil {
+		return fmt.Errorf("unable to open audit file: %s", err)
+	}
+	guard, err := auth.New(c.Accounts)
+	if err != nil {
+		return fmt.Errorf("invalid accounts: %s", err)
+	}
+	http.Handle("/", guard.Protect(a, nil))
+	http.Handle("/version", version.Handler())
+	fwd, err := httpx.Forwarded(c.Proxies)
+	if err != nil {
+		return fmt.Errorf("invalid proxies: %s", err)
 	}
-	http.Handle("/", a)
-	return http.ListenAndServe(c.Addr, nil)
+	s, err := httpx.Server(c.Addr, httpx.Wrap(http.DefaultServeMux, c.Cors, fwd), c.TLS)
+	if err != nil {
+		return err
+	}
+	return httpx.ListenAndServe(s)
 }
 
 func runDispatch(cmd *cli.Command, args []string) error {
@@ -122,7 +159,10 @@ func runDispatch(cmd *cli.Command, args []string) error {
 		Prefix  string    `json:"prefix"`
 		Monitor string    `json:"monitor"`
 		Auto    bool      `json:"auto"`
+		Cors    []string  `json:"cors"`
+		Proxies []string  `json:"proxies"`
 		Workers []*Worker `json:"workers"`
+		TLS     httpx.TLS `json:"tls"`
 	}{}
 	if err := json.NewDecoder(f).Decode(&v); err != nil {
 		log.Fatalln(err)
@@ -138,11 +178,20 @@ func runDispatch(cmd *cli.Command, args []string) error {
 	}
 	if _, _, err := net.SplitHostPort(v.Monitor); err == nil {
 		http.Handle(joinPath(v.Prefix, "workers"), &Handler{Pool: p, now: time.Now()})
-		s := &http.Server{Addr: v.Monitor, Handler: nil}
+		http.Handle(path.Join("/", v.Prefix, "metrics"), pool.Metrics(p, "ppsort"))
+		http.Handle(path.Join("/", v.Prefix, "version"), version.Handler())
+		fwd, err := httpx.Forwarded(v.Proxies)
+		if err != nil {
+			return fmt.Errorf("invalid proxies: %s", err)
+		}
+		s, err := httpx.Server(v.Monitor, httpx.Wrap(http.DefaultServeMux, v.Cors, fwd), v.TLS)
+		if err != nil {
+			return err
+		}
 		go func() {
 			defer s.Close()
 			log.Printf("start monitoring and controlling at %s", s.Addr)
-			if err := s.ListenAndServe(); err != nil {
+			if err := httpx.ListenAndServe(s); err != nil {
 				log.Println(err)
 			}
 		}()
@@ -164,8 +213,11 @@ func runFilter(cmd *cli.Command, args []string) error {
 	label := cmd.Flag.String("n", "umi", "label")
 	datadir := cmd.Flag.String("d", os.TempDir(), "datadir")
 	every := cmd.Flag.Duration("e", time.Minute*5, "every")
+	split := cmd.Flag.Bool("s", false, "split by code")
 	parallel := cmd.Flag.Int("p", 4, "parallel processing")
 	when := cmd.Flag.Duration("w", 0, "when")
+	live := cmd.Flag.Bool("l", false, "watch for new files")
+	framed := cmd.Flag.Bool("f", false, "write HRD frames")
 
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
@@ -175,10 +227,13 @@ func runFilter(cmd *cli.Command, args []string) error {
 		*parallel = 1
 	}
 
+	if err := pool.Recover(*datadir); err != nil {
+		return err
+	}
 	var wg sync.WaitGroup
 	sema := make(chan struct{}, *parallel)
 	for _, a := range cmd.Flag.Args() {
-		if *when > 0 {
+		if *when > 0 && !*live {
 			t := time.Now().UTC().Add(-*when).Truncate(time.Hour)
 			a = filepath.Join(a, fmt.Sprintf("%04d", t.Year()), fmt.Sprintf("%03d", t.YearDay()), fmt.Sprintf("%02d", t.Hour()))
 		}
@@ -186,7 +241,8 @@ func runFilter(cmd *cli.Command, args []string) error {
 		wg.Add(1)
 		go func(a string) {
 			log.Printf("start sorting PPs from %s (stored to %s)", a, *datadir)
-			w, _ := NewWorker(*label, []uint64(codes), *every)
+			w, _ := NewWorker(*label, []pp.Code(codes), *every)
+			w.Split, w.Live, w.Framed = *split, *live, *framed
 			log.Printf("start sorting packets from %s with code(s) %v", a, codes)
 			if err := w.Run(a, *datadir, false); err != nil {
 				log.Println(err)
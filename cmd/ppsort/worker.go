@@ -6,9 +6,12 @@ import (
 	"io"
 	"log"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/busoc/panda"
 	"github.com/busoc/panda/cmd/internal/buffer"
 	"github.com/busoc/panda/cmd/internal/pool"
 	"github.com/busoc/panda/cmd/internal/pp"
@@ -17,18 +20,31 @@ import (
 type Worker struct {
 	Id string
 
-	Every time.Duration
-	Codes []uint64
+	Every   time.Duration
+	Codes   []pp.Code
+	Split   bool
+	Naming  string
+	UTC     bool
+	Raw     bool
+	Framed  bool
+	Live    bool
+	Forward string
+	Skip    panda.Flag
+	Archive bool
 
 	Count uint64
 	Size  uint64
 	Last  time.Time
 
 	reader io.Reader
+	stats  pool.Counter
+	naming *template.Template
 	logger *log.Logger
+
+	envelope int
 }
 
-func NewWorker(n string, cs []uint64, e time.Duration) (*Worker, error) {
+func NewWorker(n string, cs []pp.Code, e time.Duration) (*Worker, error) {
 	if len(n) == 0 {
 		return nil, fmt.Errorf("empty id")
 	}
@@ -41,13 +57,14 @@ func NewWorker(n string, cs []uint64, e time.Duration) (*Worker, error) {
 }
 
 func (w *Worker) Status() pool.State {
-	return pool.State{
+	s := pool.State{
 		Id:      w.Id,
 		Count:   int(w.Count),
 		Size:    int(w.Size),
 		Last:    w.Last,
 		Running: w.reader != nil,
 	}
+	return w.stats.Update(s, w.reader)
 }
 
 func (w *Worker) String() string {
@@ -58,24 +75,34 @@ func (w *Worker) Run(a, d string, c bool) error {
 	if w.reader != nil {
 		return fmt.Errorf("%s already running", w.Id)
 	}
-	if r, err := pp.Open(a); err != nil {
+	buf, err := w.buffer(d, c)
+	if err != nil {
+		return err
+	}
+	if len(w.Forward) > 0 {
+		f, err := buffer.Forward(buf, "pp", w.Forward)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		buf = f
+	}
+	if err := w.open(a); err != nil {
 		return err
-	} else {
-		w.reader = r
 	}
 	var prev time.Time
 
 	w.logger.Printf("start sorting packets from %s", a)
 	defer w.logger.Printf("done sorting packets from %s", a)
 
-	buf := buffer.New(w.Id, d, c)
-	for p := range pp.Filter(w.reader, pp.NewDecoder(w.Codes)) {
+	for p := range pp.Filter(w.reader, w.decoder()) {
 		t := p.Timestamp()
 		if prev.IsZero() {
 			prev = t
 		}
 		if t.Sub(prev) >= w.Every {
 			if err := buf.Flush(prev); err != nil {
+				w.stats.Fail()
 				w.logger.Printf("failed to write packets: %s", err)
 			} else {
 				if w.Count > 0 {
@@ -90,6 +117,58 @@ func (w *Worker) Run(a, d string, c bool) error {
 	return buf.Flush(prev)
 }
 
+func (w *Worker) open(a string) error {
+	var (
+		r   io.Reader
+		err error
+	)
+	skip := panda.SkipFlagged(w.Skip)
+	switch {
+	case w.Raw && w.Live:
+		r, w.envelope, err = pp.WatchRaw(a, skip)
+	case w.Raw:
+		r, w.envelope, err = pp.OpenRaw(a, skip)
+	case w.Live:
+		r, err = pp.Watch(a, skip)
+	default:
+		r, err = pp.Open(a, skip)
+	}
+	if err == nil {
+		w.reader = r
+	}
+	return err
+}
+
+func (w *Worker) decoder() panda.Decoder {
+	d := w.stats.Decoder(pp.NewDecoder(w.Codes))
+	if w.Raw {
+		d = panda.Enveloped(d, w.envelope)
+	}
+	return d
+}
+
+// buffer creates the buffer receiving the packets sorted by w: an HRDP archive
+// in the directory of w under d when w is configured with archive, files of
+// the packets received during each interval otherwise.
+func (w *Worker) buffer(d string, c bool) (buffer.Buffer, error) {
+	if w.Archive {
+		return buffer.Archive(filepath.Join(d, w.Id))
+	}
+	opts := []buffer.Option{buffer.WithUTC(w.UTC), buffer.WithRaw(w.Raw), buffer.WithFraming(w.Framed)}
+	if w.naming != nil {
+		opts = append(opts, buffer.WithTemplate(w.naming))
+	}
+	if !w.Split {
+		return buffer.New(w.Id, d, c, opts...), nil
+	}
+	return buffer.Split(w.Id, d, c, func(p panda.Packet) string {
+		if p, ok := p.(panda.Parameter); ok {
+			return fmt.Sprintf("%x", p.Code)
+		}
+		return "000000000000"
+	}, opts...), nil
+}
+
 func (w *Worker) Close() error {
 	if w.reader == nil {
 		return fmt.Errorf("%s not yet running", w.Id)
@@ -104,9 +183,18 @@ func (w *Worker) Close() error {
 
 func (w *Worker) UnmarshalJSON(bs []byte) error {
 	v := struct {
-		Prefix string   `json:"prefix"`
-		Every  int      `json:"every"`
-		Codes  []string `json:"codes"`
+		Prefix  string     `json:"prefix"`
+		Every   int        `json:"every"`
+		Codes   []string   `json:"codes"`
+		Split   bool       `json:"split_by_code"`
+		Naming  string     `json:"filename"`
+		Time    string     `json:"timescale"`
+		Raw     bool       `json:"raw"`
+		Framed  bool       `json:"framed"`
+		Live    bool       `json:"watch"`
+		Forward string     `json:"forward"`
+		Skip    panda.Flag `json:"skip"`
+		Archive bool       `json:"archive"`
 	}{}
 	if err := json.Unmarshal(bs, &v); err != nil {
 		return err
@@ -120,8 +208,25 @@ func (w *Worker) UnmarshalJSON(bs []byte) error {
 
 	w.Id = v.Prefix
 	w.Every = time.Second * time.Duration(v.Every)
+	w.Split = v.Split
+	w.Raw, w.Framed, w.Live = v.Raw, v.Framed, v.Live
+	w.Forward, w.Skip, w.Archive = v.Forward, v.Skip, v.Archive
+	if len(v.Naming) > 0 {
+		t, err := template.New(w.Id).Parse(v.Naming)
+		if err != nil {
+			return fmt.Errorf("invalid filename template for %s: %s", w.Id, err)
+		}
+		w.Naming, w.naming = v.Naming, t
+	}
+	switch strings.ToLower(v.Time) {
+	case "", "gps":
+	case "utc":
+		w.UTC = true
+	default:
+		return fmt.Errorf("invalid timescale for %s: %s", w.Id, v.Time)
+	}
 	for _, v := range v.Codes {
-		if c, err := strconv.ParseUint(v, 0, 64); err != nil {
+		if c, err := pp.ParseCode(v); err != nil {
 			return err
 		} else {
 			w.Codes = append(w.Codes, c)
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit checks the calibrated value of an item. Limits are written as
+// comparisons of the value (x) with a constant, joined with && and ||, eg:
+//
+//	x >= 0 && x < 100 || x == 255
+//
+// && binds tighter than ||. Non numeric values can only be compared with ==
+// and !=.
+type Limit struct {
+	expr string
+	eval func(interface{}) bool
+}
+
+func ParseLimit(s string) (*Limit, error) {
+	var any []func(interface{}) bool
+	for _, alt := range strings.Split(s, "||") {
+		var all []func(interface{}) bool
+		for _, c := range strings.Split(alt, "&&") {
+			f, err := parseComparison(strings.TrimSpace(c))
+			if err != nil {
+				return nil, fmt.Errorf("invalid limit %q: %s", s, err)
+			}
+			all = append(all, f)
+		}
+		any = append(any, func(v interface{}) bool {
+			for _, f := range all {
+				if !f(v) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	eval := func(v interface{}) bool {
+		for _, f := range any {
+			if f(v) {
+				return true
+			}
+		}
+		return false
+	}
+	return &Limit{expr: strings.TrimSpace(s), eval: eval}, nil
+}
+
+func (l *Limit) Check(v interface{}) bool {
+	return l.eval(v)
+}
+
+func (l *Limit) String() string {
+	return l.expr
+}
+
+func parseComparison(s string) (func(interface{}) bool, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(s, "x"))
+
+	var op string
+	for _, o := range []string{">=", "<=", "==", "!=", ">", "<"} {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return nil, fmt.Errorf("missing operator in %q", s)
+	}
+	s = strings.TrimSpace(s[len(op):])
+	if s == "" {
+		return nil, fmt.Errorf("missing operand")
+	}
+	if w, err := strconv.ParseFloat(s, 64); err == nil {
+		return func(v interface{}) bool {
+			f, err := toFloat(v)
+			if err != nil {
+				return false
+			}
+			switch op {
+			case ">=":
+				return f >= w
+			case "<=":
+				return f <= w
+			case "==":
+				return f == w
+			case "!=":
+				return f != w
+			case ">":
+				return f > w
+			default:
+				return f < w
+			}
+		}, nil
+	}
+	w := strings.Trim(s, "\"")
+	switch op {
+	case "==":
+		return func(v interface{}) bool { return fmt.Sprint(v) == w }, nil
+	case "!=":
+		return func(v interface{}) bool { return fmt.Sprint(v) != w }, nil
+	default:
+		return nil, fmt.Errorf("%s can not be used with %q", op, s)
+	}
+}
+
+type Alarm struct {
+	When  time.Time   `json:"timestamp"`
+	Apid  int         `json:"apid"`
+	Sid   uint32      `json:"sid"`
+	Item  string      `json:"item"`
+	Value interface{} `json:"value"`
+	Limit string      `json:"limit"`
+}
+
+func (a Alarm) String() string {
+	return fmt.Sprintf("%s | %4d | %9d | %s = %v out of limit (%s)", a.When.Format(time.RFC3339), a.Apid, a.Sid, a.Item, a.Value, a.Limit)
+}
+
+type Notifier interface {
+	Notify(Alarm) error
+}
+
+// Targets holds where alarms are sent: "-" for stderr, udp://host:port to
+// send each alarm as a JSON datagram or an http(s) URL where they are posted.
+type Targets []string
+
+func (t *Targets) Set(v string) error {
+	*t = append(*t, v)
+	return nil
+}
+
+func (t *Targets) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t Targets) Notifiers() ([]Notifier, error) {
+	var ns []Notifier
+	for _, v := range t {
+		if v == "-" {
+			ns = append(ns, writer{os.Stderr})
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "udp":
+			c, err := net.Dial("udp", u.Host)
+			if err != nil {
+				return nil, err
+			}
+			ns = append(ns, datagram{c})
+		case "http", "https":
+			ns = append(ns, webhook(u.String()))
+		default:
+			return nil, fmt.Errorf("unsupported notification target: %s", v)
+		}
+	}
+	return ns, nil
+}
+
+type writer struct {
+	io.Writer
+}
+
+func (w writer) Notify(a Alarm) error {
+	_, err := fmt.Fprintln(w, a)
+	return err
+}
+
+type datagram struct {
+	net.Conn
+}
+
+func (d datagram) Notify(a Alarm) error {
+	return json.NewEncoder(d).Encode(a)
+}
+
+type webhook string
+
+func (w webhook) Notify(a Alarm) error {
+	bs, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	rs, err := http.Post(string(w), "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	rs.Body.Close()
+	if rs.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s: %s", w, rs.Status)
+	}
+	return nil
+}
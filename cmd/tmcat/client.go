@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Drop policies of the clients whose send buffer is full.
+const (
+	DropOldest = "oldest"
+	DropNewest = "newest"
+	DropClose  = "close"
+)
+
+const defaultBuffer = 256
+
+// client holds the frames waiting to be written to one websocket connection so
+// that a slow client does not block the packets of its group.
+type client struct {
+	Id string
+
+	conn   *websocket.Conn
+	queue  chan []byte
+	policy string
+	done   chan struct{}
+	once   sync.Once
+
+	sent    uint64
+	dropped uint64
+}
+
+func newClient(id string, conn *websocket.Conn, size int, policy string) *client {
+	if size <= 0 {
+		size = defaultBuffer
+	}
+	return &client{
+		Id:     id,
+		conn:   conn,
+		queue:  make(chan []byte, size),
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+}
+
+// Push queues bs to be sent. It returns false once the client is gone or has
+// to be disconnected because its buffer is full.
+func (c *client) Push(bs []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	case c.queue <- bs:
+		return true
+	default:
+	}
+	switch c.policy {
+	case DropClose:
+		atomic.AddUint64(&c.dropped, 1)
+		c.Close()
+		return false
+	case DropNewest:
+		atomic.AddUint64(&c.dropped, 1)
+		return true
+	}
+	for {
+		select {
+		case c.queue <- bs:
+			return true
+		case <-c.queue:
+			atomic.AddUint64(&c.dropped, 1)
+		}
+	}
+}
+
+// Run writes the queued frames and pings the client every beat. The client is
+// disconnected when no pong is received within timeout or when a write takes
+// longer than idle.
+func (c *client) Run(beat, timeout, idle time.Duration) {
+	defer c.Close()
+	if timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		c.conn.SetPongHandler(func(string) error {
+			return c.conn.SetReadDeadline(time.Now().Add(timeout))
+		})
+	}
+	go func() {
+		defer c.Close()
+		for {
+			if _, _, err := c.conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	var tick <-chan time.Time
+	if beat > 0 {
+		t := time.NewTicker(beat)
+		defer t.Stop()
+		tick = t.C
+	}
+	deadline := func() time.Time {
+		if idle <= 0 {
+			return time.Time{}
+		}
+		return time.Now().Add(idle)
+	}
+	for {
+		select {
+		case <-c.done:
+			return
+		case bs := <-c.queue:
+			c.conn.SetWriteDeadline(deadline())
+			if err := c.conn.WriteMessage(websocket.BinaryMessage, bs); err != nil {
+				return
+			}
+			atomic.AddUint64(&c.sent, 1)
+		case <-tick:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(beat)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *client) Close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *client) String() string {
+	return fmt.Sprintf("%s (%d sent, %d dropped)", c.Id, atomic.LoadUint64(&c.sent), atomic.LoadUint64(&c.dropped))
+}
+
+type clients struct {
+	mu sync.Mutex
+	cs map[*client]string
+}
+
+func (cs *clients) Register(g string, c *client) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.cs == nil {
+		cs.cs = make(map[*client]string)
+	}
+	cs.cs[c] = g
+}
+
+func (cs *clients) Unregister(c *client) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.cs, c)
+}
+
+// ServeHTTP reports the frames sent and dropped for each connected client.
+func (cs *clients) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	type entry struct {
+		Group string
+		*client
+	}
+	es := make([]entry, 0, len(cs.cs))
+	for c, g := range cs.cs {
+		es = append(es, entry{g, c})
+	}
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].Group == es[j].Group {
+			return es[i].Id < es[j].Id
+		}
+		return es[i].Group < es[j].Group
+	})
+	metrics := []struct {
+		Name  string
+		Type  string
+		Value func(*client) interface{}
+	}{
+		{"tmcat_distrib_frames_total", "counter", func(c *client) interface{} { return atomic.LoadUint64(&c.sent) }},
+		{"tmcat_distrib_dropped_total", "counter", func(c *client) interface{} { return atomic.LoadUint64(&c.dropped) }},
+		{"tmcat_distrib_queued", "gauge", func(c *client) interface{} { return len(c.queue) }},
+	}
+	w.Header().Set("content-type", "text/plain; version=0.0.4")
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.Name, m.Type)
+		for _, e := range es {
+			fmt.Fprintf(w, "%s{group=%q,client=%q} %v\n", m.Name, e.Group, e.Id, m.Value(e.client))
+		}
+	}
+}
+
+// closeWith ends the session of conn before it starts, giving err to the
+// client as the reason.
+func closeWith(conn *websocket.Conn, code int, err error) {
+	msg := websocket.FormatCloseMessage(code, err.Error())
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/internal/buffer"
+	"github.com/midbel/cli"
+	"github.com/midbel/toml"
+)
+
+type Event struct {
+	Id      uint64 `toml:"id"`
+	Message string `toml:"message"`
+	Items   []Item `toml:"item"`
+
+	template *template.Template
+}
+
+func (e Event) Render(b *buffer.Buffer) (string, error) {
+	vs := make(map[string]interface{})
+	for _, i := range e.Items {
+		i, err := i.Extract(b)
+		if err != nil {
+			return "", err
+		}
+		v, err := i.Calibrate()
+		if err != nil {
+			return "", err
+		}
+		vs[i.Label] = v
+	}
+	var buf bytes.Buffer
+	if err := e.template.Execute(&buf, vs); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type Dictionary struct {
+	Position  int     `toml:"position"`
+	Length    int     `toml:"length"`
+	Endianess string  `toml:"endianess"`
+	Events    []Event `toml:"event"`
+
+	events map[uint64]Event
+}
+
+func LoadDictionary(file string) (*Dictionary, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var d Dictionary
+	if err := toml.NewDecoder(f).Decode(&d); err != nil {
+		return nil, err
+	}
+	if d.Length == 0 {
+		d.Length = 16
+	}
+	d.events = make(map[uint64]Event)
+	for _, e := range d.Events {
+		t, err := template.New(fmt.Sprint(e.Id)).Option("missingkey=zero").Parse(e.Message)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %s", e.Id, err)
+		}
+		e.template = t
+		d.events[e.Id] = e
+	}
+	return &d, nil
+}
+
+func (d *Dictionary) Render(p panda.Telemetry) (uint64, string, error) {
+	var e binary.ByteOrder
+	switch d.Endianess {
+	case "big", "be", "":
+		e = binary.BigEndian
+	case "little", "le":
+		e = binary.LittleEndian
+	default:
+		return 0, "", fmt.Errorf("unsupported endianess %s", d.Endianess)
+	}
+	b := buffer.NewBuffer(p.Payload())
+	if err := b.SeekBit(d.Position); err != nil {
+		return 0, "", err
+	}
+	id, err := b.ReadBits(d.Length, e)
+	if err != nil {
+		return 0, "", err
+	}
+	v, ok := d.events[id]
+	if !ok {
+		return id, fmt.Sprintf("unknown event (% x)", p.Payload()), nil
+	}
+	m, err := v.Render(b)
+	return id, m, err
+}
+
+func runEvents(cmd *cli.Command, args []string) error {
+	const pattern = "%s | %4d | %9d | %-12s | %6d | %s\n"
+
+	var (
+		fine panda.FineTime
+		skip panda.Flag
+	)
+	cmd.Flag.Var(&fine, "f", "fine time scale")
+	cmd.Flag.Var(&skip, "k", "skip flagged packets")
+	apid := cmd.Flag.Int("a", -1, "apid")
+	config := cmd.Flag.String("c", "", "events")
+	gps := cmd.Flag.Bool("g", false, "gps")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	d, err := LoadDictionary(*config)
+	if err != nil {
+		return err
+	}
+	queue, err := FetchFlagged(cmd.Flag.Arg(0), skip, *apid, nil, panda.WithFineTime(fine))
+	if err != nil {
+		return err
+	}
+	for p := range queue {
+		switch p.PacketType() {
+		case panda.Report, panda.Exception, panda.Acknowledge:
+		default:
+			continue
+		}
+		id, m, err := d.Render(p)
+		if err != nil {
+			m = fmt.Sprintf("invalid event: %s", err)
+		}
+		fmt.Printf(pattern,
+			panda.AdjustTime(p.Timestamp(), *gps).Format(fine.Layout()),
+			p.Apid(),
+			p.Sid,
+			p.PacketType(),
+			id,
+			m,
+		)
+	}
+	return nil
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
-	"errors"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -11,21 +11,24 @@ import (
 	"os"
 	"reflect"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/busoc/panda"
+	"github.com/busoc/panda/internal/buffer"
 	"github.com/midbel/cli"
 	"github.com/midbel/toml"
 )
 
-var ErrInvalidPosition = errors.New("invalid position")
-
 func runExtract(cmd *cli.Command, args []string) error {
 	log.SetOutput(os.Stdout)
 
 	zero := cmd.Flag.Bool("z", false, "")
 	count := cmd.Flag.Uint("n", 0, "count")
 	config := cmd.Flag.String("c", "", "config")
+	format := cmd.Flag.String("f", "", "output format (csv, json)")
+	var targets Targets
+	cmd.Flag.Var(&targets, "notify", "send alarms to (-, udp://host:port, http://url)")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
@@ -38,6 +41,31 @@ func runExtract(cmd *cli.Command, args []string) error {
 		return err
 	}
 	f.Close()
+	if err := s.compile(); err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		targets = Targets{"-"}
+	}
+	notifiers, err := targets.Notifiers()
+	if err != nil {
+		return err
+	}
+	var alarms int
+
+	var rec recorder
+	switch *format {
+	case "":
+	case "csv":
+		rec = newCSVRecorder(os.Stdout, s)
+	case "json":
+		rec = newJSONRecorder(os.Stdout)
+	default:
+		return fmt.Errorf("unsupported format: %s", *format)
+	}
+	if rec != nil {
+		defer rec.Flush()
+	}
 
 	queue, err := FetchPackets(cmd.Flag.Arg(0), 0, nil)
 	if err != nil {
@@ -48,10 +76,22 @@ func runExtract(cmd *cli.Command, args []string) error {
 	if !*zero {
 		index++
 	}
+	gaps := panda.NewGapTracker()
 	for p := range queue {
 		if *count > 0 && n >= *count {
 			break
 		}
+		var flags Flags
+		g, ok := gaps.Update(p)
+		if ok {
+			flags |= FlagGap
+		}
+		if p.Timestamp().Before(g.Starts) {
+			flags |= FlagTime
+		}
+		if !p.Verify() {
+			flags |= FlagSum
+		}
 		vs, err := s.Extract(p)
 		switch err {
 		case nil:
@@ -61,6 +101,20 @@ func runExtract(cmd *cli.Command, args []string) error {
 		default:
 			return err
 		}
+		for _, a := range s.Check(p, vs) {
+			alarms++
+			for _, n := range notifiers {
+				if err := n.Notify(a); err != nil {
+					fmt.Fprintf(os.Stderr, "fail to notify alarm: %s\n", err)
+				}
+			}
+		}
+		if rec != nil {
+			if err := rec.Record(p, vs); err != nil {
+				return err
+			}
+			continue
+		}
 		for _, v := range vs {
 			if v.Length == 0 {
 				v.Length = binary.Size(v.Raw) * 8
@@ -70,13 +124,114 @@ func runExtract(cmd *cli.Command, args []string) error {
 			if err != nil {
 				return err
 			}
-			log.Printf("%3d | %2d | %2d | %-32s | %-6s | %16v | %16v", w, v.Length, v.Offset%16, v.Label, v.Type, v.Raw, result)
+			log.Printf("%3d | %2d | %2d | %-32s | %-6s | %16v | %16v | %s", w, v.Length, v.Offset%16, v.Label, v.Type, v.Raw, result, flags)
 		}
 		log.Println("===")
 	}
+	if alarms > 0 {
+		return fmt.Errorf("%d value(s) out of limits", alarms)
+	}
 	return nil
 }
 
+type recorder interface {
+	Record(panda.Telemetry, []Item) error
+	Flush() error
+}
+
+type csvRecorder struct {
+	writer *csv.Writer
+}
+
+func newCSVRecorder(w io.Writer, s Schema) recorder {
+	header := []string{"timestamp", "apid", "sid"}
+	for _, i := range s.Items {
+		if !i.Ignore {
+			header = append(header, i.Label)
+		}
+	}
+	c := csvRecorder{writer: csv.NewWriter(w)}
+	c.writer.Write(header)
+	return &c
+}
+
+func (c *csvRecorder) Record(p panda.Telemetry, vs []Item) error {
+	row := []string{
+		panda.AdjustTime(p.Timestamp(), false).Format(time.RFC3339Nano),
+		strconv.Itoa(p.Apid()),
+		strconv.FormatUint(uint64(p.Sid), 10),
+	}
+	for _, v := range vs {
+		r, err := v.Calibrate()
+		if err != nil {
+			return err
+		}
+		row = append(row, fmt.Sprint(r))
+	}
+	return c.writer.Write(row)
+}
+
+func (c *csvRecorder) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type jsonRecorder struct {
+	encoder *json.Encoder
+}
+
+func newJSONRecorder(w io.Writer) recorder {
+	return &jsonRecorder{encoder: json.NewEncoder(w)}
+}
+
+func (j *jsonRecorder) Record(p panda.Telemetry, vs []Item) error {
+	v := struct {
+		When  time.Time              `json:"timestamp"`
+		Apid  int                    `json:"apid"`
+		Sid   uint32                 `json:"sid"`
+		Items map[string]interface{} `json:"items"`
+	}{
+		When:  panda.AdjustTime(p.Timestamp(), false),
+		Apid:  p.Apid(),
+		Sid:   p.Sid,
+		Items: make(map[string]interface{}),
+	}
+	for _, i := range vs {
+		r, err := i.Calibrate()
+		if err != nil {
+			return err
+		}
+		v.Items[i.Label] = r
+	}
+	return j.encoder.Encode(v)
+}
+
+func (j *jsonRecorder) Flush() error {
+	return nil
+}
+
+type Flags uint8
+
+const (
+	FlagGap Flags = 1 << iota
+	FlagSum
+	FlagTime
+)
+
+func (f Flags) String() string {
+	bs := []byte("---")
+	if f&FlagGap == FlagGap {
+		bs[0] = 'g'
+	}
+	if f&FlagSum == FlagSum {
+		bs[1] = 'c'
+	}
+	if f&FlagTime == FlagTime {
+		bs[2] = 't'
+	}
+	return string(bs)
+}
+
 type Item struct {
 	Label     string `toml:"name" json:"name"`
 	Comment   string `toml:"comment" json:"comment"`
@@ -85,10 +240,13 @@ type Item struct {
 	Length    int    `toml:"length" json:"length"`
 	Ignore    bool   `toml:"ignore" json:"-"`
 	Endianess string `toml:"endianess" json:"-"`
+	Limit     string `toml:"limit" json:"limit,omitempty"`
 
 	Method *Domain `toml:"calibration"`
 
 	Raw interface{} `toml:"-" json:"-"`
+
+	limit *Limit
 }
 
 func (i Item) Calibrate() (interface{}, error) {
@@ -103,43 +261,19 @@ func (i Item) Position() int {
 	return ix
 }
 
-func (i Item) Extract(b *buffer) (Item, error) {
-	var e binary.ByteOrder
-	switch i.Endianess {
-	case "big", "be", "":
-		e = binary.BigEndian
-	case "little", "le":
-		e = binary.LittleEndian
-	default:
-		return i, fmt.Errorf("unsupported endianess %s", i.Endianess)
+func (i Item) Extract(b *buffer.Buffer) (Item, error) {
+	v := panda.Item{
+		Type:      i.Type,
+		Offset:    i.Offset,
+		Length:    i.Length,
+		Endianess: i.Endianess,
 	}
-	var err error
-
-	v := i
-	switch i.Type {
-	case "bool":
-		b, err := b.ReadUint8(v.Offset, v.Length, e)
-		if b == 1 && err == nil {
-			v.Raw = true
-		}
-	case "uchar":
-		v.Raw, err = b.ReadUint8(v.Offset, v.Length, e)
-	case "ushort", "":
-		v.Raw, err = b.ReadUint16(v.Offset, v.Length, e)
-	case "ulong":
-		v.Raw, err = b.ReadUint32(v.Offset, v.Length, e)
-	case "char":
-		v.Raw, err = b.ReadInt8(v.Offset, v.Length, e)
-	case "short":
-		v.Raw, err = b.ReadInt16(v.Offset, v.Length, e)
-	case "long":
-		v.Raw, err = b.ReadInt32(v.Offset, v.Length, e)
-	case "float":
-		v.Raw, err = b.ReadFloat(v.Offset, e)
-	default:
-		return i, fmt.Errorf("unsupported type %s", i.Type)
+	v, err := v.Extract(b)
+	if err != nil {
+		return i, err
 	}
-	return v, err
+	i.Raw = v.Raw
+	return i, nil
 }
 
 type Schema struct {
@@ -153,6 +287,44 @@ type Schema struct {
 	Sum     string    `json:"md5sum"`
 }
 
+func (s Schema) compile() error {
+	for i, t := range s.Items {
+		if t.Limit == "" {
+			continue
+		}
+		l, err := ParseLimit(t.Limit)
+		if err != nil {
+			return fmt.Errorf("%s: %s", t.Label, err)
+		}
+		s.Items[i].limit = l
+	}
+	return nil
+}
+
+// Check gives an alarm for each of the items of p whose calibrated value is out
+// of its limit.
+func (s Schema) Check(p panda.Telemetry, vs []Item) []Alarm {
+	var as []Alarm
+	for _, i := range vs {
+		if i.limit == nil {
+			continue
+		}
+		v, err := i.Calibrate()
+		if err == nil && i.limit.Check(v) {
+			continue
+		}
+		as = append(as, Alarm{
+			When:  panda.AdjustTime(p.Timestamp(), false),
+			Apid:  p.Apid(),
+			Sid:   p.Sid,
+			Item:  i.Label,
+			Value: v,
+			Limit: i.limit.String(),
+		})
+	}
+	return as
+}
+
 func (s Schema) Extract(p panda.Telemetry) ([]Item, error) {
 	sort.Slice(s.Sources, func(i, j int) bool { return s.Sources[i] < s.Sources[j] })
 	ix := sort.Search(len(s.Sources), func(i int) bool {
@@ -162,7 +334,7 @@ func (s Schema) Extract(p panda.Telemetry) ([]Item, error) {
 		return nil, panda.ErrSkip
 	}
 
-	buf := NewBuffer(p.Payload())
+	buf := buffer.NewBuffer(p.Payload())
 	if err := buf.Discard(s.Offset / 8); err != nil {
 		return nil, err
 	}
@@ -179,136 +351,6 @@ func (s Schema) Extract(p panda.Telemetry) ([]Item, error) {
 	return is, nil
 }
 
-type buffer struct {
-	inner *bytes.Reader
-	index int64
-}
-
-func NewBuffer(bs []byte) *buffer {
-	return &buffer{inner: bytes.NewReader(bs)}
-}
-
-func (b *buffer) Discard(n int64) error {
-	bs := make([]byte, b.inner.Size()-n)
-	if _, err := b.inner.ReadAt(bs, n); err != nil {
-		return err
-	}
-	b.inner.Reset(bs)
-	return nil
-}
-
-func (b *buffer) ReadFloat(pos int, order binary.ByteOrder) (i float32, err error) {
-	ix, _ := index(pos)
-
-	var u uint32
-	if err = b.readValue(&u, ix, binary.Size(u)*8, order); err != nil {
-		return
-	}
-	i = math.Float32frombits(u)
-	return
-}
-
-func (b *buffer) ReadInt32(pos, count int, order binary.ByteOrder) (i int32, err error) {
-	ix, offset := index(pos)
-	if err = b.readValue(&i, ix, count, order); err != nil {
-		return
-	}
-	if count == 0 {
-		return
-	}
-	delta := uint32(binary.Size(i)*8 - offset - count)
-	mask := ((1 << uint32(count)) - 1) << delta
-	i = (i & int32(mask)) >> delta
-
-	return
-}
-
-func (b *buffer) ReadInt16(pos, count int, order binary.ByteOrder) (i int16, err error) {
-	ix, offset := index(pos)
-	if err = b.readValue(&i, ix, count, order); err != nil {
-		return
-	}
-	if count == 0 {
-		return
-	}
-	delta := uint16(binary.Size(i)*8 - offset - count)
-	mask := ((1 << uint16(count)) - 1) << delta
-	i = (i & int16(mask)) >> delta
-	return
-}
-
-func (b *buffer) ReadInt8(pos, count int, order binary.ByteOrder) (i int8, err error) {
-	ix, offset := index(pos)
-	if err = b.readValue(&i, ix, count, order); err != nil {
-		return
-	}
-	if count == 0 {
-		return
-	}
-	delta := uint8(binary.Size(i)*8 - offset - count)
-	mask := ((1 << uint8(count)) - 1) << delta
-	i = (i & int8(mask)) >> delta
-
-	return
-}
-
-func (b *buffer) ReadUint32(pos, count int, order binary.ByteOrder) (i uint32, err error) {
-	ix, offset := index(pos)
-	if err = b.readValue(&i, ix, count, order); err != nil {
-		return
-	}
-	if count == 0 {
-		return
-	}
-	delta := uint32(binary.Size(i)*8 - offset - count)
-	mask := ((1 << uint32(count)) - 1) << delta
-	i = (i & uint32(mask)) >> delta
-
-	return
-}
-
-func (b *buffer) ReadUint16(pos, count int, order binary.ByteOrder) (i uint16, err error) {
-	ix, offset := index(pos)
-	if err = b.readValue(&i, ix, count, order); err != nil {
-		return
-	}
-	if count == 0 {
-		return
-	}
-	delta := uint16(binary.Size(i)*8 - offset - count)
-	mask := ((1 << uint16(count)) - 1) << delta
-	i = (i & uint16(mask)) >> delta
-	return
-}
-
-func (b *buffer) ReadUint8(pos, count int, order binary.ByteOrder) (i uint8, err error) {
-	ix, offset := index(pos)
-	if err = b.readValue(&i, ix, count, order); err != nil {
-		return
-	}
-	if count == 0 {
-		return
-	}
-	delta := uint8(binary.Size(i)*8 - offset - count)
-	mask := ((1 << uint8(count)) - 1) << delta
-	i = (i & uint8(mask)) >> delta
-
-	return
-}
-
-func (b *buffer) readValue(i interface{}, x, n int, e binary.ByteOrder) error {
-	if int64(x) >= b.inner.Size() {
-		return ErrInvalidPosition
-	}
-	if s := binary.Size(i) * 8; s <= 0 || n > s {
-		return fmt.Errorf("unsupported type %T", i)
-	}
-	if _, err := b.inner.Seek(int64(x), io.SeekStart); err != nil {
-		return err
-	}
-	return binary.Read(b.inner, e, i)
-}
-
 type Transformer interface {
 	Transform(interface{}) (interface{}, error)
 }
@@ -478,7 +520,3 @@ func (t *Domain) UnmarshalTOML(d *toml.Decoder) error {
 	t.Transformer = v.Domain.Transform
 	return nil
 }
-
-func index(n int) (int, int) {
-	return n / 8, n % 8
-}
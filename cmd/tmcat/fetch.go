@@ -1,36 +1,57 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/busoc/panda"
 	"github.com/busoc/panda/cmd/internal/tm"
 	"golang.org/x/net/websocket"
 )
 
-func FetchPackets(s string, apid int, pids []uint32) (<-chan panda.Telemetry, error) {
-	if i, err := os.Stat(s); err == nil && i.IsDir() {
-		return tm.Packets(s, apid, pids)
-	}
-	if u, err := url.Parse(s); err == nil && u.Scheme == "ws" {
+func FetchPackets(s string, apid int, pids []uint32, opts ...panda.DecodeOption) (<-chan panda.Telemetry, error) {
+	return FetchFlagged(s, 0, apid, pids, opts...)
+}
+
+func FetchFlagged(s string, skip panda.Flag, apid int, pids []uint32, opts ...panda.DecodeOption) (<-chan panda.Telemetry, error) {
+	walk := []panda.WalkOption{panda.SkipFlagged(skip)}
+	return FetchBetween(s, time.Time{}, time.Time{}, walk, apid, pids, opts...)
+}
+
+// FetchBetween is like FetchFlagged but only reads the files covering
+// [start, end) when s is an archive, walking it with the given options.
+func FetchBetween(s string, start, end time.Time, walk []panda.WalkOption, apid int, pids []uint32, opts ...panda.DecodeOption) (<-chan panda.Telemetry, error) {
+	var (
+		r   io.Reader
+		err error
+	)
+	if i, e := os.Stat(s); s == "-" || (e == nil && i.IsDir()) {
+		r, err = tm.OpenBetween(s, start, end, walk...)
+	} else if u, e := url.Parse(s); e == nil && u.Scheme == "ws" {
 		o := *u
 		o.Scheme = "http"
-		c, err := websocket.Dial(u.String(), "", o.String())
-		if err != nil {
-			return nil, err
-		}
-		return tm.Filter(c, tm.NewDecoder(apid, pids)), nil
+		r, err = websocket.Dial(u.String(), "", o.String())
+	} else if i, _, e := net.SplitHostPort(s); e != nil {
+		err = e
+	} else if ip := net.ParseIP(i); ip != nil && ip.IsMulticast() {
+		r, err = tm.Open(s)
+	} else {
+		err = fmt.Errorf("can not fetch packets from %s", s)
 	}
-
-	i, _, err := net.SplitHostPort(s)
 	if err != nil {
 		return nil, err
 	}
-	if ip := net.ParseIP(i); ip != nil && ip.IsMulticast() {
-		return tm.Packets(s, apid, pids)
-	}
-	return nil, fmt.Errorf("can not fetch packets from %s", s)
+	accept := panda.WithPredicate(tm.NewPredicate(apid, pids))
+	return tm.FilterReport(context.Background(), r, panda.DecodeTM(opts...), reportError, accept), nil
+}
+
+// reportError notifies about packets that could not be decoded without
+// interfering with the regular output.
+func reportError(err error) {
+	fmt.Fprintf(os.Stderr, "skipping packet: %s\n", err)
 }
@@ -1,14 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/md5"
-	"encoding/binary"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -21,11 +20,18 @@ import (
 	"text/template"
 	"time"
 
-	"golang.org/x/net/websocket"
+	"github.com/gorilla/websocket"
 
 	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/auth"
+	"github.com/busoc/panda/cmd/internal/dump"
+	"github.com/busoc/panda/cmd/internal/httpx"
 	"github.com/busoc/panda/cmd/internal/opts"
+	"github.com/busoc/panda/cmd/internal/replay"
+	"github.com/busoc/panda/cmd/internal/rw"
 	"github.com/busoc/panda/cmd/internal/tm"
+	"github.com/busoc/panda/cmd/internal/version"
+	"github.com/busoc/panda/hrdp"
 	"github.com/midbel/cli"
 	"github.com/midbel/toml"
 )
@@ -33,7 +39,7 @@ import (
 var commands = []*cli.Command{
 	{
 		Run:   runShow,
-		Usage: "show [-a] [-g] [-s] <source>",
+		Usage: "show [-a] [-g] [-s] [-f] [-k] [-o] [-group apid|sid] [-gaps file] [-back] [-ahead] [-from] [-to] [-j] [-m] [-v] [-x] [-crc] [-pus] <source>",
 		Alias: []string{"dump"},
 		Short: "dump packet headers",
 	},
@@ -44,14 +50,29 @@ var commands = []*cli.Command{
 	},
 	{
 		Run:   runReplay,
-		Usage: "replay [-d] [-r] [-l] <group>",
+		Usage: "replay [-d] [-r] [-l] [-g] <group>",
 		Short: "",
 	},
 	{
 		Run:   runExtract,
-		Usage: "extract [-c] [-n] <source>",
+		Usage: "extract [-c] [-n] [-f csv|json] [-notify target] <source>",
 		Short: "",
 	},
+	{
+		Run:   runEvents,
+		Usage: "events [-a] [-f] [-g] [-k] -c <events.toml> <source>",
+		Short: "print event packets as readable messages",
+	},
+	{
+		Run:   runVerify,
+		Usage: "verify [-n] [-w] <archive>",
+		Short: "annotate duplicated, corrupted, undecodable and out of order packets",
+	},
+	{
+		Run:   runStats,
+		Usage: "stats [-a] [-p] [-k] [-f csv|json] [-d] [-from] [-to] [-j] [-m] <source>",
+		Short: "count packets, bytes, rates and gaps per apid and sid",
+	},
 }
 
 const helpText = `{{.Name}} prints TM packet headers.
@@ -70,6 +91,10 @@ Use {{.Name}} [command] -h for more information about its usage.
 
 func main() {
 	log.SetFlags(0)
+	if version.Requested(os.Args[1:]) {
+		version.Print(os.Stdout)
+		return
+	}
 	if err := cli.Run(commands, usage, nil); err != nil {
 		log.Fatalln(err)
 	}
@@ -90,22 +115,93 @@ func usage() {
 }
 
 func runShow(cmd *cli.Command, args []string) error {
-	const pattern = "%s | %6d | %12s | %4d | %6d | %9d | %-16s | % x | %3s | %6d |%x\n"
+	const (
+		pattern    = "%s | %6d | %12s | %4d | %6d | %9d | %-16s | % x | %3s | %6d |%x\n"
+		patternPUS = "%s | %6d | %12s | %4d | %6d | %3d | %3d | %3d | % x | %3s | %6d |%x\n"
+	)
 
-	var pids opts.SIDSet
+	var (
+		pids opts.SIDSet
+		fine panda.FineTime
+		skip panda.Flag
+	)
 	cmd.Flag.Var(&pids, "p", "type")
+	cmd.Flag.Var(&fine, "f", "fine time scale")
+	cmd.Flag.Var(&skip, "k", "skip flagged packets")
 	apid := cmd.Flag.Int("a", -1, "apid")
 	sum := cmd.Flag.Bool("s", false, "sum")
 	gps := cmd.Flag.Bool("g", false, "gps")
 	debug := cmd.Flag.Bool("b", false, "debug")
+	verbose := cmd.Flag.Bool("v", false, "verbose")
+	hexdump := cmd.Flag.Bool("x", false, "annotated hex dump")
+	crc := cmd.Flag.Bool("crc", false, "verify checksum")
+	pus := cmd.Flag.Bool("pus", false, "pus data field header")
+	output := cmd.Flag.String("o", "", "output")
+	group := cmd.Flag.String("group", "apid", "group")
+	file := cmd.Flag.String("gaps", "", "gaps report")
+	back := cmd.Flag.Duration("back", 0, "report time jumping backward")
+	ahead := cmd.Flag.Duration("ahead", 0, "report time jumping forward")
+	from := cmd.Flag.String("from", "", "start time")
+	to := cmd.Flag.String("to", "", "end time")
+	workers := cmd.Flag.Int("j", 1, "files read concurrently")
+	merge := cmd.Flag.Bool("m", false, "merge files in time order")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	queue, err := FetchPackets(cmd.Flag.Arg(0), *apid, pids)
+	dtstart, err := parseTime(*from)
+	if err != nil {
+		return err
+	}
+	dtend, err := parseTime(*to)
+	if err != nil {
+		return err
+	}
+	if *group != "apid" && *group != "sid" {
+		return fmt.Errorf("invalid group: %s", *group)
+	}
+	check := panda.ChecksumIgnore
+	if *crc {
+		check = panda.ChecksumFlag
+	}
+	walk := []panda.WalkOption{panda.SkipFlagged(skip), panda.WithWorkers(*workers)}
+	if *merge {
+		walk = append(walk, panda.WithMerge())
+	}
+	decoding := []panda.DecodeOption{panda.WithFineTime(fine), panda.WithChecksum(check)}
+	if *pus {
+		decoding = append(decoding, panda.WithPUS())
+	}
+	queue, err := FetchBetween(cmd.Flag.Arg(0), dtstart, dtend, walk, *apid, pids, decoding...)
 	if err != nil {
 		return err
 	}
-	gaps := make(map[int]panda.Telemetry)
+	queue = between(queue, dtstart, dtend)
+	if *output != "" {
+		return writePackets(*output, queue)
+	}
+	var (
+		tracker = panda.NewGapTracker()
+		report  *csv.Writer
+	)
+	if *group == "sid" {
+		tracker = panda.NewSIDGapTracker()
+	}
+	var jumps *panda.JumpTracker
+	if *back > 0 || *ahead > 0 {
+		jumps = panda.NewJumpTracker(*back, *ahead)
+	}
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		report = csv.NewWriter(f)
+		defer report.Flush()
+		report.Write([]string{"apid", "sid", "last", "next", "missing", "dtstart", "dtend", "duration"})
+	}
+	var last panda.Gap
 	for p := range queue {
 		var s []byte
 		if *sum {
@@ -117,31 +213,138 @@ func runShow(cmd *cli.Command, args []string) error {
 		var warning string
 
 		c, e := p.CCSDSHeader, p.ESAHeader
-		prev := gaps[c.Apid()]
-		delta := c.Sequence() - prev.CCSDSHeader.Sequence()
-		if !(delta == 1 || delta == -(1<<14)+1) {
+		g, ok := tracker.Update(p)
+		if *group == "sid" && (g.Last < 0 || last.Apid != g.Apid || last.Sid != g.Sid) {
+			fmt.Printf("# apid %d - sid %d\n", g.Apid, g.Sid)
+		}
+		last = g
+		if jumps != nil {
+			if _, ok := jumps.Update(p); ok {
+				warning = "jmp"
+			}
+		}
+		if ok {
 			warning = "gap"
+			if report != nil {
+				report.Write([]string{
+					strconv.Itoa(g.Apid),
+					strconv.FormatUint(uint64(g.Sid), 10),
+					strconv.Itoa(g.Last),
+					strconv.Itoa(g.Next),
+					strconv.Itoa(g.Missing()),
+					g.Starts.Format(time.RFC3339Nano),
+					g.Ends.Format(time.RFC3339Nano),
+					g.Duration().String(),
+				})
+			}
+		}
+		if *crc {
+			fmt.Printf("%-3s | ", checksumStatus(p))
 		}
-		if delta < 0 && e.Timestamp().Sub(prev.ESAHeader.Timestamp()) >= time.Second {
-			delta = (1 << 14) - 1 + c.Sequence() - prev.CCSDSHeader.Sequence()
+		if h := p.PUS; h != nil {
+			fmt.Printf(patternPUS,
+				panda.AdjustTime(h.Timestamp(), *gps).Format(fine.Layout()),
+				c.Sequence(),
+				c.SegmentationFlag(),
+				c.Apid(),
+				c.Len(),
+				h.Service,
+				h.Subtype,
+				h.Destination,
+				p.Data[:4],
+				warning,
+				g.Delta(),
+				s,
+			)
+			if *verbose {
+				fmt.Printf("  version: %d\n", h.Version)
+			}
+		} else {
+			fmt.Printf(pattern,
+				panda.AdjustTime(e.Timestamp(), *gps).Format(fine.Layout()),
+				c.Sequence(),
+				c.SegmentationFlag(),
+				c.Apid(),
+				c.Len(),
+				e.Sid,
+				e.PacketType(),
+				p.Data[:4],
+				warning,
+				g.Delta(),
+				s,
+			)
+			if *verbose {
+				fmt.Printf("  control: %08b | time: %s | checksum: %t | zoe: %t\n", e.Control, e.PacketTime(), e.Sum(), e.ZOE())
+			}
+		}
+		if *hexdump {
+			dump.Telemetry(os.Stdout, p)
 		}
-		fmt.Printf(pattern,
-			panda.AdjustTime(e.Timestamp(), *gps).Format("2006-01-02T15:04:05.000Z"),
-			c.Sequence(),
-			c.SegmentationFlag(),
-			c.Apid(),
-			c.Len(),
-			e.Sid,
-			e.PacketType(),
-			p.Data[:4],
-			warning,
-			delta,
-			s,
-		)
 		if *debug {
 			fmt.Println(hex.Dump(p.Payload()))
 		}
-		gaps[c.Apid()] = p
+	}
+	return nil
+}
+
+func checksumStatus(p panda.Telemetry) string {
+	switch {
+	case !p.ESAHeader.Sum():
+		return "---"
+	case p.Corrupted:
+		return "bad"
+	default:
+		return "ok"
+	}
+}
+
+// between drops the packets of queue outside [start, end), zero bounds being
+// ignored.
+func between(queue <-chan panda.Telemetry, start, end time.Time) <-chan panda.Telemetry {
+	if start.IsZero() && end.IsZero() {
+		return queue
+	}
+	q := make(chan panda.Telemetry)
+	go func() {
+		defer close(q)
+		for p := range queue {
+			t := panda.AdjustTime(p.Timestamp(), false)
+			if (!start.IsZero() && t.Before(start)) || (!end.IsZero() && !t.Before(end)) {
+				continue
+			}
+			q <- p
+		}
+	}()
+	return q
+}
+
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func writePackets(file string, queue <-chan panda.Telemetry) error {
+	var w io.Writer
+	if file == "-" {
+		w = os.Stdout
+	} else {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	ws, err := panda.NewWriter("tm", w)
+	if err != nil {
+		return err
+	}
+	for p := range queue {
+		if err := ws.Write(p); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -150,30 +353,98 @@ func runDistrib(cmd *cli.Command, args []string) error {
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	f, err := os.Open(cmd.Flag.Arg(0))
+	var (
+		addr    string
+		cert    httpx.TLS
+		proxies []string
+		cs      clients
+		counts  = make(map[string]*int32)
+		guard   = new(auth.Guard)
+	)
+	load := func() (http.Handler, error) {
+		c, err := loadDistrib(cmd.Flag.Arg(0))
+		if err != nil {
+			return nil, err
+		}
+		if addr == "" {
+			addr, cert, proxies = c.Addr, c.TLS, c.Proxies
+		} else if c.Addr != addr || c.TLS != cert {
+			log.Printf("new address or tls settings ignored: restart needed")
+			c.Addr = addr
+		}
+		h, err := c.Handler(&cs, counts, guard)
+		if err != nil {
+			return nil, err
+		}
+		// the accounts are only replaced once the rest of the configuration
+		// is known to be valid.
+		if err := guard.Load(c.Accounts); err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+	h, err := httpx.Reload("/reload", load, guard.Admin)
+	if err != nil {
+		return err
+	}
+	fwd, err := httpx.Forwarded(proxies)
+	if err != nil {
+		return fmt.Errorf("invalid proxies: %s", err)
+	}
+	s, err := httpx.Server(addr, httpx.Chain(h, httpx.RequestID, fwd, httpx.Log, httpx.Recover), cert)
 	if err != nil {
 		return err
 	}
-	c := struct {
-		Addr   string   `toml:"addr"`
-		Client int32    `toml:"client"`
-		Groups []*group `toml:"group"`
-		Paths  []string `toml:"schemas"`
-	}{}
+	return httpx.ListenAndServe(s)
+}
+
+type distribConfig struct {
+	Addr     string         `toml:"addr"`
+	Client   int32          `toml:"client"`
+	Groups   []*group       `toml:"group"`
+	Paths    []string       `toml:"schemas"`
+	Cors     []string       `toml:"cors"`
+	Proxies  []string       `toml:"proxies"`
+	Record   string         `toml:"record"`
+	Accounts []auth.Account `toml:"account"`
+	TLS      httpx.TLS      `toml:"tls"`
+}
+
+func loadDistrib(file string) (*distribConfig, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var c distribConfig
 	if err := toml.NewDecoder(f).Decode(&c); err != nil {
-		return err
+		return nil, err
 	}
-	f.Close()
+	return &c, nil
+}
 
+// Handler gives the routes of the groups of c. counts holds the number of
+// clients connected to each group, kept from one configuration to the next so
+// that the limit applies to the clients connected before a reload too.
+func (c *distribConfig) Handler(cs *clients, counts map[string]*int32, guard *auth.Guard) (http.Handler, error) {
+	mux := http.NewServeMux()
 	if h, err := handleSchemas(c.Paths); err == nil {
-		http.Handle("/mdb/", h)
+		mux.Handle("/mdb/", guard.Protect(h, nil))
 	} else {
-		return err
+		return nil, err
 	}
 
 	routes := make(map[string][]*group)
 	for _, g := range c.Groups {
-		g.limit = c.Client
+		g.limit, g.record, g.clients = c.Client, c.Record, cs
+		switch g.Drop {
+		case "":
+			g.Drop = DropOldest
+		case DropOldest, DropNewest, DropClose:
+		default:
+			return nil, fmt.Errorf("%s: unknown drop policy %s", g.Name, g.Drop)
+		}
 		var prefix string
 		if _, _, err := net.SplitHostPort(g.Addr); err == nil {
 			prefix = "/realtime/"
@@ -187,15 +458,22 @@ func runDistrib(cmd *cli.Command, args []string) error {
 			Path:   filepath.Clean(g.Name),
 		}
 		g.Endpoint = u.String()
-		http.Handle(u.Path, websocket.Handler(g.Handle))
+		if counts[u.Path] == nil {
+			counts[u.Path] = new(int32)
+		}
+		g.count = counts[u.Path]
+		mux.Handle(u.Path, guard.Protect(g, g.Accounts))
 	}
 	for r, gs := range routes {
 		gs := gs
-		http.HandleFunc(r, func(w http.ResponseWriter, r *http.Request) {
+		f := func(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(gs)
-		})
+		}
+		mux.Handle(r, guard.Protect(http.HandlerFunc(f), nil))
 	}
-	return http.ListenAndServe(c.Addr, nil)
+	mux.Handle("/metrics", guard.Protect(cs, nil))
+	mux.Handle("/version", version.Handler())
+	return httpx.CORS(c.Cors)(mux), nil
 }
 
 func handleSchemas(ps []string) (http.Handler, error) {
@@ -233,15 +511,10 @@ func handleSchemas(ps []string) (http.Handler, error) {
 	return http.HandlerFunc(f), nil
 }
 
-var codec = websocket.Codec{
-	Marshal: func(v interface{}) ([]byte, byte, error) {
-		p, ok := v.(panda.Telemetry)
-		if !ok {
-			return nil, websocket.UnknownFrame, fmt.Errorf("%T", p)
-		}
-		bs, err := p.Bytes()
-		return bs, websocket.BinaryFrame, err
-	},
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
 type group struct {
@@ -253,91 +526,184 @@ type group struct {
 	Date     time.Time `toml:"limit" json:"-"`
 	Delay    int64     `toml:"delay" json:"-"`
 	Interval int64     `toml:"interval" json:"-"`
-
-	limit int32
-	count int32
+	Beat     int64     `toml:"heartbeat" json:"-"`
+	Idle     int64     `toml:"idle" json:"-"`
+	Timeout  int64     `toml:"timeout" json:"-"`
+	Buffer   int       `toml:"buffer" json:"-"`
+	Drop     string    `toml:"drop" json:"-"`
+	Allow    []int     `toml:"allow-apid" json:"-"`
+	AllowSid []uint32  `toml:"allow-source" json:"-"`
+	Accounts []string  `toml:"accounts" json:"-"`
+
+	limit   int32
+	count   *int32
+	record  string
+	clients *clients
 }
 
-func (g *group) handleRealtime(r *http.Request) (<-chan panda.Telemetry, int, error) {
-	q, err := tm.Packets(g.Addr, g.Apid, g.Sources)
+func (g *group) handleRealtime(ctx context.Context, r *http.Request) (<-chan panda.Telemetry, int, error) {
+	q, err := tm.PacketsContext(ctx, g.Addr, g.Apid, g.Sources)
 	return q, 0, err
 }
 
-func (g *group) handleReplay(r *http.Request) (<-chan panda.Telemetry, int, error) {
+func (g *group) handleReplay(ctx context.Context, r *http.Request) (<-chan panda.Telemetry, int, error) {
 	rate := 1
 	q := r.URL.Query()
 	rate = 1
 	if r, err := strconv.ParseUint(q.Get("rate"), 10, 64); err == nil && (r >= 1 && r <= 5) {
 		rate = int(r)
 	}
-	n := time.Now().UTC().Truncate(time.Minute * 5)
+	n := time.Now().UTC().Truncate(hrdp.Slot)
 	dtend := n.Add(time.Duration(-g.Delay) * time.Second)
 	dtstart := dtend.Add(time.Duration(-g.Interval) * time.Second)
 	if d, err := time.Parse(time.RFC3339, q.Get("dtstart")); err == nil {
-		dtstart = d.Truncate(time.Minute * 5)
+		dtstart = d.Truncate(hrdp.Slot)
 	}
 	if d, err := time.Parse(time.RFC3339, q.Get("dtend")); err == nil {
-		dtend = d.Add(time.Minute * 5).Truncate(time.Minute * 5)
+		dtend = d.Add(hrdp.Slot).Truncate(hrdp.Slot)
 	}
 	apid := g.Apid
 	if r, err := strconv.ParseInt(q.Get("apid"), 10, 64); err == nil {
 		apid = int(r)
 	}
+	if apid != g.Apid && !g.allowApid(apid) {
+		return nil, 0, fmt.Errorf("apid %d not allowed", apid)
+	}
 	queue := make(chan panda.Telemetry)
 	go func() {
 		defer close(queue)
-		for w := dtstart; w.Before(dtend); w = w.Add(time.Minute * 5) {
-			y, d, h, m := w.Year(), w.YearDay(), w.Hour(), w.Minute()
-			n := fmt.Sprintf("rt_%02d_%02d.dat", m, m+4)
-			p := filepath.Join(g.Addr, fmt.Sprintf("%04d", y), fmt.Sprintf("%03d", d), fmt.Sprintf("%02d", h), n)
-			q, err := tm.Packets(p, apid, g.Sources)
+		for w := dtstart; w.Before(dtend); w = w.Add(hrdp.Slot) {
+			q, err := tm.PacketsContext(ctx, hrdp.File(g.Addr, w), apid, g.Sources)
 			if err != nil {
 				return
 			}
 			for p := range q {
-				queue <- p
+				select {
+				case queue <- p:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
 	}()
 	return queue, rate, nil
 }
 
-func (g *group) Handle(ws *websocket.Conn) {
-	defer ws.Close()
-
-	curr := atomic.AddInt32(&g.count, 1)
-	defer atomic.AddInt32(&g.count, -1)
+func (g *group) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	curr := atomic.AddInt32(g.count, 1)
+	defer atomic.AddInt32(g.count, -1)
 	if g.limit > 0 && curr >= int32(g.limit) {
+		httpx.Error(w, fmt.Errorf("too many clients connected"), http.StatusTooManyRequests)
+		return
+	}
+	rec, err := rw.Record(g.record, "tm", g.Name, httpx.ID(r))
+	if err != nil {
+		log.Printf("%s: fail to record session: %s", g.Name, err)
+		httpx.Error(w, err, http.StatusInternalServerError)
 		return
 	}
+	defer rec.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	// the sources are only opened once the connection is upgraded and are
+	// closed with the session, whatever the way it ends.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
 	var (
 		prev  time.Time
 		delta time.Duration
 		rate  int
-		err   error
 		queue <-chan panda.Telemetry
 	)
-	if r := ws.Request(); strings.HasPrefix(r.URL.Path, "/replay/") {
-		queue, rate, err = g.handleReplay(r)
+	code := websocket.CloseInternalServerErr
+	replay := strings.HasPrefix(r.URL.Path, "/replay/")
+	if replay {
+		code = websocket.ClosePolicyViolation
+		queue, rate, err = g.handleReplay(ctx, r)
 	} else {
-		queue, rate, err = g.handleRealtime(r)
+		queue, rate, err = g.handleRealtime(ctx, r)
 	}
 	if err != nil {
+		closeWith(conn, code, err)
 		return
 	}
-	for p := range queue {
-		if !prev.IsZero() && rate == 0 && prev.After(p.Timestamp()) {
-			continue
-		}
-		wait(delta, rate)
-		if err := codec.Send(ws, p); err != nil {
+	c := newClient(httpx.ID(r), conn, g.Buffer, g.Drop)
+	defer c.Close()
+
+	g.clients.Register(g.Name, c)
+	defer g.clients.Unregister(c)
+
+	beat := time.Duration(g.Beat) * time.Second
+	timeout := time.Duration(g.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 2 * beat
+	}
+	go c.Run(beat, timeout, time.Duration(g.Idle)*time.Second)
+	defer log.Printf("%s: client %s disconnected", g.Name, c)
+
+	for {
+		select {
+		case p, ok := <-queue:
+			if !ok {
+				return
+			}
+			if !g.allow(p) || (!prev.IsZero() && rate == 0 && prev.After(p.Timestamp())) {
+				continue
+			}
+			wait(delta, rate)
+			bs, err := p.Bytes()
+			if err != nil {
+				continue
+			}
+			if replay {
+				if err := auth.Use(r, len(bs)); err != nil {
+					log.Printf("%s: client %s: %s", g.Name, c, err)
+					return
+				}
+			}
+			if !c.Push(bs) {
+				return
+			}
+			rec.Write(p)
+			if !prev.IsZero() && rate > 0 {
+				delta = p.Timestamp().Sub(prev)
+			}
+			prev = p.Timestamp()
+		case <-c.Done():
 			return
 		}
-		if !prev.IsZero() && rate > 0 {
-			delta = p.Timestamp().Sub(prev)
+	}
+}
+
+func (g *group) allow(p panda.Telemetry) bool {
+	if !g.allowApid(p.Apid()) {
+		return false
+	}
+	if len(g.AllowSid) == 0 {
+		return true
+	}
+	for _, s := range g.AllowSid {
+		if s == p.Sid {
+			return true
 		}
-		prev = p.Timestamp()
 	}
+	return false
+}
+
+func (g *group) allowApid(a int) bool {
+	if len(g.Allow) == 0 {
+		return true
+	}
+	for _, v := range g.Allow {
+		if v == a {
+			return true
+		}
+	}
+	return false
 }
 
 func wait(d time.Duration, r int) {
@@ -365,8 +731,9 @@ func runReplay(cmd *cli.Command, args []string) error {
 		return err
 	}
 	defer c.Close()
+	w := replay.Conn(c, &gap)
 	for i := 0; *loop <= 0 || i < *loop; i++ {
-		if err := replay(c, *datadir, *rate, gap); err != nil {
+		if err := play(w, *datadir, *rate); err != nil {
 			return err
 		}
 	}
@@ -374,78 +741,21 @@ func runReplay(cmd *cli.Command, args []string) error {
 	return nil
 }
 
-func replay(c net.Conn, datadir string, rate int, gap opts.Gap) error {
-	if rate == 0 {
-		rate++
-	}
-	if !gap.IsZero() {
-		c = &conn{
-			Conn:   c,
-			gap:    gap,
-			writer: c,
-			after:  time.After(gap.Next()),
-		}
-	}
+func play(c net.Conn, datadir string, rate int) error {
 	queue, err := tm.Packets(datadir, 0, nil)
 	if err != nil {
 		return err
 	}
-	var (
-		prev  time.Time
-		delta time.Duration
-		buf   bytes.Buffer
-	)
+	ws, err := panda.NewWriter("tm", c)
+	if err != nil {
+		return err
+	}
+	pace := replay.NewPacer(rate)
 	for p := range queue {
-		time.Sleep(delta / time.Duration(rate))
-
-		n := time.Duration(time.Now().UnixNano())
-		s, ns := n/time.Second, n/time.Millisecond
-
-		binary.Write(&buf, binary.BigEndian, uint8(panda.TagTM))
-		binary.Write(&buf, binary.BigEndian, uint32(s))
-		binary.Write(&buf, binary.BigEndian, uint8(ns)%255)
-		binary.Write(&buf, binary.BigEndian, uint32(0))
-		bs, err := p.Bytes()
-		if err != nil {
+		pace.Wait(p.Timestamp())
+		if err := ws.Write(p); err != nil {
 			return err
 		}
-		buf.Write(bs)
-		if _, err := io.Copy(c, &buf); err != nil {
-			return err
-		}
-		if !prev.IsZero() {
-			delta = p.Timestamp().Sub(prev)
-			if delta > time.Hour {
-				delta = 0
-			}
-		}
-		prev = p.Timestamp()
 	}
 	return nil
 }
-
-type conn struct {
-	net.Conn
-	gap opts.Gap
-
-	writer io.Writer
-	after  <-chan time.Time
-}
-
-func (c *conn) Write(bs []byte) (int, error) {
-	select {
-	case <-c.after:
-		var (
-			d time.Duration
-			w io.Writer
-		)
-		if t, ok := c.gap.Wait(); !ok {
-			w, d = ioutil.Discard, t
-		} else {
-			w, d = c.Conn, t
-		}
-		c.writer, c.after = w, time.After(d)
-	default:
-	}
-	return c.writer.Write(bs)
-}
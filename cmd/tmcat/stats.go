@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/opts"
+	"github.com/midbel/cli"
+)
+
+func runStats(cmd *cli.Command, args []string) error {
+	var (
+		pids opts.SIDSet
+		skip panda.Flag
+	)
+	cmd.Flag.Var(&pids, "p", "type")
+	cmd.Flag.Var(&skip, "k", "skip flagged packets")
+	apid := cmd.Flag.Int("a", -1, "apid")
+	format := cmd.Flag.String("f", "", "output format (csv, json)")
+	window := cmd.Flag.Duration("d", 0, "stop reading after duration")
+	from := cmd.Flag.String("from", "", "start time")
+	to := cmd.Flag.String("to", "", "end time")
+	workers := cmd.Flag.Int("j", 1, "files read concurrently")
+	merge := cmd.Flag.Bool("m", false, "merge files in time order")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	var write func(io.Writer, []*stat) error
+	switch *format {
+	case "":
+		write = printStats
+	case "csv":
+		write = printStatsCSV
+	case "json":
+		write = printStatsJSON
+	default:
+		return fmt.Errorf("unsupported format: %s", *format)
+	}
+	dtstart, err := parseTime(*from)
+	if err != nil {
+		return err
+	}
+	dtend, err := parseTime(*to)
+	if err != nil {
+		return err
+	}
+	walk := []panda.WalkOption{panda.SkipFlagged(skip), panda.WithWorkers(*workers)}
+	if *merge {
+		walk = append(walk, panda.WithMerge())
+	}
+	queue, err := FetchBetween(cmd.Flag.Arg(0), dtstart, dtend, walk, *apid, pids)
+	if err != nil {
+		return err
+	}
+	queue = between(queue, dtstart, dtend)
+
+	var stop <-chan time.Time
+	if *window > 0 {
+		stop = time.After(*window)
+	}
+	var (
+		tracker = panda.NewGapTracker()
+		stats   = make(map[uint64]*stat)
+	)
+Loop:
+	for {
+		select {
+		case p, ok := <-queue:
+			if !ok {
+				break Loop
+			}
+			k := uint64(p.Apid())<<32 | uint64(p.Sid)
+			s, ok := stats[k]
+			if !ok {
+				s = &stat{Apid: p.Apid(), Sid: p.Sid}
+				stats[k] = s
+			}
+			// sequence counters being maintained per apid, a gap is accounted to the
+			// sid of the packet following it.
+			g, gap := tracker.Update(p)
+			s.Update(p, g, gap)
+		case <-stop:
+			break Loop
+		}
+	}
+	ss := make([]*stat, 0, len(stats))
+	for _, s := range stats {
+		ss = append(ss, s)
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		if ss[i].Apid == ss[j].Apid {
+			return ss[i].Sid < ss[j].Sid
+		}
+		return ss[i].Apid < ss[j].Apid
+	})
+	return write(os.Stdout, ss)
+}
+
+type stat struct {
+	Apid    int       `json:"apid"`
+	Sid     uint32    `json:"sid"`
+	Count   int       `json:"count"`
+	Bytes   int       `json:"bytes"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	Rate    float64   `json:"rate"`
+	Gaps    int       `json:"gaps"`
+	Missing int       `json:"missing"`
+}
+
+func (s *stat) Update(p panda.Telemetry, g panda.Gap, gap bool) {
+	t := panda.AdjustTime(p.Timestamp(), false)
+	if s.Count == 0 || t.Before(s.First) {
+		s.First = t
+	}
+	if t.After(s.Last) {
+		s.Last = t
+	}
+	s.Count++
+	s.Bytes += panda.CCSDSLength + p.Len()
+	if gap {
+		s.Gaps++
+		s.Missing += g.Missing()
+	}
+	if d := s.Last.Sub(s.First); d > 0 {
+		s.Rate = float64(s.Count-1) / d.Seconds()
+	}
+}
+
+func printStats(w io.Writer, ss []*stat) error {
+	const pattern = "%4d | %9d | %8d | %10d | %s | %s | %8.2f | %6d | %8d\n"
+	for _, s := range ss {
+		fmt.Fprintf(w, pattern,
+			s.Apid,
+			s.Sid,
+			s.Count,
+			s.Bytes,
+			s.First.Format(time.RFC3339),
+			s.Last.Format(time.RFC3339),
+			s.Rate,
+			s.Gaps,
+			s.Missing,
+		)
+	}
+	return nil
+}
+
+func printStatsCSV(w io.Writer, ss []*stat) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"apid", "sid", "count", "bytes", "first", "last", "rate", "gaps", "missing"})
+	for _, s := range ss {
+		c.Write([]string{
+			strconv.Itoa(s.Apid),
+			strconv.FormatUint(uint64(s.Sid), 10),
+			strconv.Itoa(s.Count),
+			strconv.Itoa(s.Bytes),
+			s.First.Format(time.RFC3339Nano),
+			s.Last.Format(time.RFC3339Nano),
+			strconv.FormatFloat(s.Rate, 'f', 3, 64),
+			strconv.Itoa(s.Gaps),
+			strconv.Itoa(s.Missing),
+		})
+	}
+	c.Flush()
+	return c.Error()
+}
+
+func printStatsJSON(w io.Writer, ss []*stat) error {
+	return json.NewEncoder(w).Encode(ss)
+}
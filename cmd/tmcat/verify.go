@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/busoc/panda"
+	"github.com/midbel/cli"
+)
+
+func runVerify(cmd *cli.Command, args []string) error {
+	const pattern = "%s | %8d | %8d | %8d | %8d | %8d\n"
+
+	dry := cmd.Flag.Bool("n", false, "dry run")
+	size := cmd.Flag.Int("w", 1<<16, "number of packets checked for duplicates")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	var (
+		digests = newWindow(*size)
+		times   = make(map[uint64]time.Time)
+		decoder = panda.DecodeTM()
+	)
+	return filepath.Walk(cmd.Flag.Arg(0), func(p string, i os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if i.IsDir() || filepath.Ext(p) == panda.AnnotationExt {
+			return nil
+		}
+		var (
+			count int
+			as    = make(panda.Annotations)
+		)
+		err = panda.Records("tm", p, func(at int64, bs []byte) error {
+			count++
+			_, x, err := decoder.Decode(bs)
+			if err != nil {
+				as[at] |= panda.FlagDecode
+				return nil
+			}
+			t := x.(panda.Telemetry)
+			if !t.Verify() {
+				as[at] |= panda.FlagChecksum
+			}
+			if !digests.Add(md5.Sum(bs)) {
+				as[at] |= panda.FlagDuplicate
+				return nil
+			}
+
+			k := uint64(t.Apid())<<32 | uint64(t.Sid)
+			if w, ok := times[k]; ok && t.Timestamp().Before(w) {
+				as[at] |= panda.FlagTime
+			} else {
+				times[k] = t.Timestamp()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		var ds, cs, es, ts int
+		for _, f := range as {
+			if f&panda.FlagDuplicate != 0 {
+				ds++
+			}
+			if f&panda.FlagChecksum != 0 {
+				cs++
+			}
+			if f&panda.FlagDecode != 0 {
+				es++
+			}
+			if f&panda.FlagTime != 0 {
+				ts++
+			}
+		}
+		fmt.Printf(pattern, p, count, ds, cs, es, ts)
+		if *dry {
+			return nil
+		}
+		return as.Save(p)
+	})
+}
+
+// window remembers the digests of the last packets seen. Once full, the oldest
+// digest is forgotten to make room for the new one.
+type window struct {
+	seen map[[md5.Size]byte]struct{}
+	ring [][md5.Size]byte
+	next int
+}
+
+func newWindow(n int) *window {
+	if n <= 0 {
+		n = 1
+	}
+	return &window{
+		seen: make(map[[md5.Size]byte]struct{}, n),
+		ring: make([][md5.Size]byte, 0, n),
+	}
+}
+
+// Add records s and reports whether it was not already in the window.
+func (w *window) Add(s [md5.Size]byte) bool {
+	if _, ok := w.seen[s]; ok {
+		return false
+	}
+	if len(w.ring) < cap(w.ring) {
+		w.ring = append(w.ring, s)
+	} else {
+		delete(w.seen, w.ring[w.next])
+		w.ring[w.next] = s
+		w.next = (w.next + 1) % len(w.ring)
+	}
+	w.seen[s] = struct{}{}
+	return true
+}
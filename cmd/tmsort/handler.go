@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,15 +14,26 @@ import (
 	"sort"
 	"time"
 
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/client"
+	"github.com/busoc/panda/cmd/internal/auth"
+	"github.com/busoc/panda/cmd/internal/httpx"
 	"github.com/busoc/panda/cmd/internal/pool"
+	"github.com/busoc/panda/cmd/internal/rw"
 	"github.com/busoc/panda/cmd/internal/tm"
+	"github.com/busoc/panda/hrdp"
 )
 
+const slice = hrdp.Slot
+
 type query struct {
 	Apid     int
 	Start    time.Time
 	End      time.Time
+	Local    bool
 	filename string
+	skip     panda.Flag
+	client   *http.Client
 }
 
 func Validate(r io.Reader, t time.Time, d, i time.Duration, ids []int) (*query, error) {
@@ -46,35 +58,134 @@ func Validate(r io.Reader, t time.Time, d, i time.Duration, ids []int) (*query,
 	return &q, nil
 }
 
-func (q *query) Write(d string, w io.Writer) error {
-	for p := range q.next(d) {
-		r, err := tm.Packets(p, q.Apid, nil)
-		if err != nil {
-			return err
+type manifest struct {
+	Packets    int
+	Duplicates int
+	Files      int
+	Gaps       int
+	Origins    httpx.Provenance
+}
+
+func (m manifest) Set(h http.Header) {
+	h.Set("X-Panda-Packets", fmt.Sprint(m.Packets))
+	h.Set("X-Panda-Duplicates", fmt.Sprint(m.Duplicates))
+	h.Set("X-Panda-Files", fmt.Sprint(m.Files))
+	h.Set("X-Panda-Gaps", fmt.Sprint(m.Gaps))
+	h.Set(httpx.ProvenanceHeader, m.Origins.String())
+	h.Set("Access-Control-Expose-Headers", "X-Panda-Packets, X-Panda-Duplicates, X-Panda-Files, X-Panda-Gaps, "+httpx.ProvenanceHeader)
+}
+
+func (q *query) Write(ctx context.Context, d string, peers []string, w io.Writer) (manifest, error) {
+	var m manifest
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ws, gs := rw.NoDuplicate(w), panda.NewGapTracker()
+	for s := range q.next(ctx, d) {
+		var (
+			r   <-chan panda.Telemetry
+			src = "local"
+		)
+		switch {
+		case s.File != "":
+			x, err := tm.OpenContext(ctx, s.File, panda.SkipFlagged(q.skip))
+			if err != nil {
+				return m, err
+			}
+			r = tm.FilterContext(ctx, x, panda.DecodeTM(), panda.WithPredicate(tm.NewPredicate(q.Apid, nil)))
+			m.Files++
+		case !q.Local:
+			r, src = q.fetch(ctx, s.When, peers)
+		}
+		if r == nil {
+			continue
 		}
+		count := m.Packets
 		for p := range r {
 			bs, e := p.Bytes()
 			if e != nil {
 				continue
 			}
-			if _, err := w.Write(bs); err != nil {
-				return err
+			n := ws.Dropped()
+			if _, err := ws.Write(bs); err != nil {
+				return m, err
+			}
+			if ws.Dropped() > n {
+				continue
+			}
+			m.Packets++
+			if _, ok := gs.Update(p); ok {
+				m.Gaps++
 			}
 		}
+		if m.Packets > count {
+			m.Origins = append(m.Origins, httpx.Origin{Start: s.When, Source: src})
+		}
 	}
-	return nil
+	m.Duplicates = ws.Dropped()
+	return m, ctx.Err()
 }
 
-func (q *query) next(base string) <-chan string {
-	ch := make(chan string)
+// fetch asks the peers in turn for the packets of the slice starting at t that
+// is missing from the local archive. The packets of the first peer having some
+// are given with its address.
+func (q *query) fetch(ctx context.Context, t time.Time, peers []string) (<-chan panda.Telemetry, string) {
+	v := struct {
+		Apid  int       `json:"apid"`
+		Start time.Time `json:"dtstart"`
+		End   time.Time `json:"dtend"`
+		Local bool      `json:"local"`
+	}{
+		Apid:  q.Apid,
+		Start: t,
+		End:   t.Add(slice - time.Nanosecond),
+		Local: true,
+	}
+	for _, p := range peers {
+		rc, err := httpx.Fetch(ctx, q.client, p, v)
+		if err != nil {
+			log.Printf("fail to fetch %s from peer: %s", t.Format(time.RFC3339), err)
+			continue
+		}
+		if rc == nil {
+			continue
+		}
+		r, _ := client.Reader("tm", rc)
+		x := struct {
+			io.Reader
+			io.Closer
+		}{r, rc}
+		return tm.FilterContext(ctx, x, tm.NewDecoder(q.Apid, nil)), p
+	}
+	return nil, ""
+}
+
+func isFile(p string) bool {
+	i, err := os.Stat(p)
+	return err == nil && i.Mode().IsRegular()
+}
+
+type slot struct {
+	When time.Time
+	File string
+}
+
+// next gives the slices of the interval of q with the file holding their
+// packets, File being empty when the slice is not in the archive.
+func (q *query) next(ctx context.Context, base string) <-chan slot {
+	ch := make(chan slot)
 	go func() {
 		defer close(ch)
-		for n := q.Start; n.Before(q.End); n = n.Add(time.Minute * 5) {
-			y, d, h := n.Year(), n.YearDay(), n.Hour()
-			n := fmt.Sprintf("rt_%02d_%02d.dat", n.Minute(), n.Minute()+4)
-			p := filepath.Join(base, fmt.Sprintf("%04d", y), fmt.Sprintf("%03d", d), fmt.Sprintf("%02d", h), n)
-			if i, err := os.Stat(p); err == nil && i.Mode().IsRegular() {
-				ch <- p
+		for n := q.Start; n.Before(q.End); n = n.Add(slice) {
+			s := slot{When: n}
+			if p := hrdp.File(base, n); isFile(p) {
+				s.File = p
+			}
+			select {
+			case ch <- s:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -87,6 +198,7 @@ func (q *query) UnmarshalJSON(bs []byte) error {
 		Start time.Time `json:"dtstart"`
 		End   time.Time `json:"dtend"`
 		Name  string    `json:"filename"`
+		Local bool      `json:"local"`
 	}{}
 	if err := json.Unmarshal(bs, &v); err != nil {
 		return err
@@ -94,8 +206,8 @@ func (q *query) UnmarshalJSON(bs []byte) error {
 	if v.Start.After(v.End) {
 		return fmt.Errorf("invalid interval: %s < %s", q.End, q.Start)
 	}
-	t := time.Minute * 5
-	q.filename, q.Apid, q.Start, q.End = v.Name, v.Apid, v.Start.Truncate(t), v.End.Add(t).Truncate(t)
+	q.filename, q.Apid, q.Start, q.End = v.Name, v.Apid, v.Start.Truncate(slice), v.End.Add(slice).Truncate(slice)
+	q.Local = v.Local
 	return nil
 }
 
@@ -113,6 +225,10 @@ type Archive struct {
 	Interval time.Duration
 	Apids    []int
 	Date     time.Time
+	Peers    []string
+	Client   *http.Client
+	Skip     panda.Flag
+	Audit    *log.Logger
 }
 
 func (a *Archive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -128,25 +244,35 @@ func (a *Archive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	q, err := Validate(r.Body, a.Date, a.Delay, a.Interval, a.Apids)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpx.Error(w, err, http.StatusBadRequest)
 		return
 	}
+	q.skip, q.client = a.Skip, a.Client
+	if a.Audit != nil {
+		a.Audit.Printf("id=%s client=%s account=%s apid=%d dtstart=%s dtend=%s", httpx.ID(r), httpx.Client(r), auth.Name(r), q.Apid, q.Start.Format(time.RFC3339), q.End.Format(time.RFC3339))
+	}
 	// buf := new(bytes.Buffer)
 	var buf bytes.Buffer
 	defer buf.Reset()
-	if err := q.Write(a.Datadir, &buf); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	m, err := q.Write(r.Context(), a.Datadir, a.Peers, &buf)
+	if err != nil {
+		httpx.Error(w, err, http.StatusInternalServerError)
 		return
 	}
+	m.Set(w.Header())
 	if buf.Len() == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if err := auth.Use(r, buf.Len()); err != nil {
+		httpx.Error(w, err, http.StatusTooManyRequests)
+		return
+	}
 	w.Header().Set("content-type", "application/octet-stream")
 	w.Header().Set("content-length", fmt.Sprint(buf.Len()))
 	w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", q.String()))
 	if _, err := io.Copy(w, &buf); err != nil {
-		log.Println(err)
+		log.Printf("id=%s: %s", httpx.ID(r), err)
 	}
 }
 
@@ -157,6 +283,7 @@ func (a *Archive) UnmarshalJSON(bs []byte) error {
 		Interval uint      `json:"interval"`
 		Date     time.Time `json:"date"`
 		Apids    []uint16  `json:"apid"`
+		Peers    []string  `json:"peers"`
 	}{}
 	if err := json.Unmarshal(bs, &v); err != nil {
 		return err
@@ -169,6 +296,7 @@ func (a *Archive) UnmarshalJSON(bs []byte) error {
 	}
 	a.Datadir = v.Datadir
 	a.Date = v.Date
+	a.Peers = v.Peers
 	for _, i := range v.Apids {
 		a.Apids = append(a.Apids, int(i))
 	}
@@ -195,16 +323,6 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		err  error
 		data interface{}
 	)
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	if h := r.Header.Get("Access-Control-Request-Headers"); len(h) > 0 {
-		w.Header().Set("Access-Control-Allow-Headers", h)
-	}
-	if r.Method == http.MethodOptions {
-		w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
-		w.WriteHeader(http.StatusOK)
-		return
-	}
 	switch r.Method {
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -222,15 +340,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		err = h.Stop(n)
 	}
 	if err != nil {
-		v := struct {
-			Err  string `json:"error"`
-			Code int    `json:"code"`
-		}{
-			Err:  err.Error(),
-			Code: http.StatusBadRequest,
-		}
-		json.NewEncoder(w).Encode(v)
-		w.WriteHeader(http.StatusBadRequest)
+		httpx.Error(w, err, http.StatusBadRequest)
 		return
 	}
 	if data == nil {
@@ -13,7 +13,11 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/busoc/panda"
+	"github.com/busoc/panda/cmd/internal/auth"
+	"github.com/busoc/panda/cmd/internal/httpx"
 	"github.com/busoc/panda/cmd/internal/pool"
+	"github.com/busoc/panda/cmd/internal/version"
 	"github.com/midbel/cli"
 	"github.com/midbel/toml"
 )
@@ -25,7 +29,7 @@ var commands = []*cli.Command{
 		Run:   runDispatch,
 	},
 	{
-		Usage: "filter [-a] [-n] [-d] [-c] [-e] <path...>",
+		Usage: "filter [-a] [-n] [-d] [-c] [-e] [-s] <path...>",
 		Short: "filter telemetry packets from the HRDP archive",
 		Run:   runFilter,
 	},
@@ -71,6 +75,10 @@ func main() {
 
 		os.Exit(2)
 	}
+	if version.Requested(os.Args[1:]) {
+		version.Print(os.Stdout)
+		return
+	}
 	if err := cli.Run(commands, usage, nil); err != nil {
 		log.Fatalln(err)
 	}
@@ -87,12 +95,19 @@ func runDistrib(cmd *cli.Command, args []string) error {
 	defer f.Close()
 
 	c := struct {
-		Addr     string `toml:"address"`
-		Prefix   string `toml:"prefix"`
-		Datadir  string `toml:"datadir"`
-		Apids    []int  `toml:"apids"`
-		Delay    int    `toml:"delay"`
-		Interval int    `toml:"interval"`
+		Addr     string         `toml:"address"`
+		Prefix   string         `toml:"prefix"`
+		Datadir  string         `toml:"datadir"`
+		Apids    []int          `toml:"apids"`
+		Delay    int            `toml:"delay"`
+		Interval int            `toml:"interval"`
+		Cors     []string       `toml:"cors"`
+		Proxies  []string       `toml:"proxies"`
+		Audit    string         `toml:"audit"`
+		Peers    []string       `toml:"peers"`
+		Skip     panda.Flag     `toml:"skip"`
+		Accounts []auth.Account `toml:"account"`
+		TLS      httpx.TLS      `toml:"tls"`
 	}{}
 	if err := toml.NewDecoder(f).Decode(&c); err != nil {
 		return fmt.Errorf("invalid settings provided: %s", err)
@@ -102,9 +117,30 @@ func runDistrib(cmd *cli.Command, args []string) error {
 		Apids:    c.Apids,
 		Delay:    time.Duration(c.Delay) * time.Second,
 		Interval: time.Duration(c.Interval) * time.Second,
+		Peers:    c.Peers,
+		Skip:     c.Skip,
+	}
+	if a.Client, err = c.TLS.Client(); err != nil {
+		return err
+	}
+	if a.Audit, err = httpx.Audit(c.Audit); err != nil {
+		return fmt.Errorf("unable to open audit file: %s", err)
+	}
+	guard, err := auth.New(c.Accounts)
+	if err != nil {
+		return fmt.Errorf("invalid accounts: %s", err)
+	}
+	http.Handle("/", guard.Protect(a, nil))
+	http.Handle("/version", version.Handler())
+	fwd, err := httpx.Forwarded(c.Proxies)
+	if err != nil {
+		return fmt.Errorf("invalid proxies: %s", err)
 	}
-	http.Handle("/", a)
-	return http.ListenAndServe(c.Addr, nil)
+	s, err := httpx.Server(c.Addr, httpx.Wrap(http.DefaultServeMux, c.Cors, fwd), c.TLS)
+	if err != nil {
+		return err
+	}
+	return httpx.ListenAndServe(s)
 }
 
 func runDispatch(cmd *cli.Command, args []string) error {
@@ -123,7 +159,10 @@ func runDispatch(cmd *cli.Command, args []string) error {
 		Monitor string    `json:"monitor"`
 		Prefix  string    `json:"prefix"`
 		Auto    bool      `json:"auto"`
+		Cors    []string  `json:"cors"`
+		Proxies []string  `json:"proxies"`
 		Workers []*Worker `json:"workers"`
+		TLS     httpx.TLS `json:"tls"`
 	}{}
 	if err := json.NewDecoder(f).Decode(&v); err != nil {
 		return fmt.Errorf("invalid settings provided: %s", err)
@@ -138,11 +177,20 @@ func runDispatch(cmd *cli.Command, args []string) error {
 	}
 	if _, _, err := net.SplitHostPort(v.Monitor); err == nil {
 		http.Handle(joinPath(v.Prefix, "workers"), &Handler{now: time.Now(), Pool: p})
-		s := &http.Server{Addr: v.Monitor, Handler: nil}
+		http.Handle(path.Join("/", v.Prefix, "metrics"), pool.Metrics(p, "tmsort"))
+		http.Handle(path.Join("/", v.Prefix, "version"), version.Handler())
+		fwd, err := httpx.Forwarded(v.Proxies)
+		if err != nil {
+			return fmt.Errorf("invalid proxies: %s", err)
+		}
+		s, err := httpx.Server(v.Monitor, httpx.Wrap(http.DefaultServeMux, v.Cors, fwd), v.TLS)
+		if err != nil {
+			return err
+		}
 		go func() {
 			defer s.Close()
 			log.Printf("start monitoring and controlling at %s", s.Addr)
-			if err := s.ListenAndServe(); err != nil {
+			if err := httpx.ListenAndServe(s); err != nil {
 				log.Println(err)
 			}
 		}()
@@ -162,8 +210,10 @@ func runFilter(cmd *cli.Command, args []string) error {
 	label := cmd.Flag.String("n", "", "label")
 	datadir := cmd.Flag.String("d", os.TempDir(), "datadir")
 	every := cmd.Flag.Duration("e", time.Minute*5, "every")
+	split := cmd.Flag.Bool("s", false, "split by apid")
 	parallel := cmd.Flag.Int("p", 4, "parallel processing")
 	when := cmd.Flag.Duration("w", 0, "when")
+	live := cmd.Flag.Bool("l", false, "watch for new files")
 	//flat := cmd.Flag.Bool("f", true, "flat layout")
 
 	if err := cmd.Flag.Parse(args); err != nil {
@@ -174,11 +224,14 @@ func runFilter(cmd *cli.Command, args []string) error {
 		*parallel = 1
 	}
 
+	if err := pool.Recover(*datadir); err != nil {
+		return err
+	}
 	var wg sync.WaitGroup
 
 	sema := make(chan struct{}, *parallel)
 	for _, a := range cmd.Flag.Args() {
-		if *when > 0 {
+		if *when > 0 && !*live {
 			t := time.Now().UTC().Add(-*when).Truncate(time.Hour)
 			a = filepath.Join(a, fmt.Sprintf("%04d", t.Year()), fmt.Sprintf("%03d", t.YearDay()), fmt.Sprintf("%02d", t.Hour()))
 		}
@@ -187,6 +240,7 @@ func runFilter(cmd *cli.Command, args []string) error {
 		go func(a string) {
 			log.Printf("start sorting TMs from %s (stored to %s)", a, *datadir)
 			w := NewWorker(*label, *apid, *every)
+			w.Split, w.Live = *split, *live
 			if err := w.Run(a, *datadir, false); err != nil {
 				log.Println(err)
 			}
@@ -6,6 +6,9 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/busoc/panda"
@@ -17,9 +20,26 @@ import (
 type Worker struct {
 	Id string
 
-	Apid    int
-	Sources []uint32
-	Every   time.Duration
+	Apid     int
+	Apids    []int
+	Any      bool
+	Segments []panda.CCSDSPacketSegmentation
+	Sources  []uint32
+	Types    []string
+	Every    time.Duration
+	Split    bool
+	Naming   string
+	UTC      bool
+	Raw      bool
+	Live     bool
+	Forward  string
+	Backward time.Duration
+	Ahead    time.Duration
+	Arrival  bool
+	Skew     time.Duration
+	Isolate  bool
+	Skip     panda.Flag
+	Archive  bool
 
 	Sequence uint64
 	Count    uint64
@@ -27,6 +47,11 @@ type Worker struct {
 	Last     time.Time
 
 	reader io.Reader
+	stats  pool.Counter
+	naming *template.Template
+	jumps  *panda.JumpTracker
+
+	envelope int
 
 	logger *log.Logger
 }
@@ -48,25 +73,24 @@ func (w *Worker) String() string {
 }
 
 func (w *Worker) Status() pool.State {
-	return pool.State{
+	s := pool.State{
 		Id:      w.Id,
 		Count:   int(w.Count),
 		Size:    int(w.Size),
 		Last:    w.Last,
 		Running: w.reader != nil,
 	}
+	return w.stats.Update(s, w.reader)
 }
 
 func (w *Worker) RunBuffer(a string, b buffer.Buffer) error {
 	if w.reader != nil {
 		return fmt.Errorf("%s already running", w.Id)
 	}
-	if r, err := tm.Open(a); err != nil {
+	if err := w.open(a); err != nil {
 		return err
-	} else {
-		w.reader = r
 	}
-	q := tm.Filter(w.reader, tm.NewDecoder(w.Apid, w.Sources))
+	q := tm.Filter(w.reader, w.decoder())
 	return w.sortPackets(q, b)
 }
 
@@ -74,38 +98,242 @@ func (w *Worker) Run(a, d string, c bool) error {
 	if w.reader != nil {
 		return fmt.Errorf("%s already running", w.Id)
 	}
-	if r, err := tm.Open(a); err != nil {
+	buf, err := w.buffer(d, c)
+	if err != nil {
 		return err
-	} else {
-		w.reader = r
 	}
-	var prev time.Time
+	if len(w.Forward) > 0 {
+		f, err := buffer.Forward(buf, "tm", w.Forward)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		buf = f
+	}
+	if err := w.open(a); err != nil {
+		return err
+	}
+	var (
+		prev time.Time
+		qbuf buffer.Buffer
+	)
+	if w.Skew > 0 && w.Isolate {
+		qbuf = w.quarantine(d, c)
+	}
 
 	w.logger.Printf("start sorting packets from %s", a)
 	defer w.logger.Printf("done sorting packets from %s", a)
 
-	buf := buffer.New(w.Id, d, c)
-	for p := range tm.Filter(w.reader, tm.NewDecoder(w.Apid, w.Sources)) {
-		t := p.Timestamp()
+	if w.Backward > 0 || w.Ahead > 0 {
+		w.jumps = panda.NewJumpTracker(w.Backward, w.Ahead)
+	}
+	for p := range tm.Filter(w.reader, w.decoder()) {
+		if w.skewed(p) {
+			if qbuf != nil {
+				qbuf.Write(p)
+			}
+			continue
+		}
+		t := w.clock(p)
 		if prev.IsZero() {
 			prev = t
 		}
-		if t.Sub(prev) >= w.Every {
-			if err := buf.Flush(prev); err != nil {
-				w.logger.Printf("failed to write packets: %s", err)
-			} else {
-				if w.Count > 0 {
-					w.logger.Printf("%d packets written to %s (%.2fKB)", w.Count, d, float64(w.Size)/1024.0)
-				}
+		jumped := w.jumped(p)
+		if t.Sub(prev) >= w.Every || (jumped && !w.Arrival) {
+			w.flush(buf, prev, d)
+			if qbuf != nil {
+				qbuf.Flush(prev)
 			}
-			w.Count, w.Size, prev = 0, 0, t
+			prev = t
 		}
 		c, s, _ := buf.Write(p)
 		w.Count, w.Size, w.Last = uint64(c), w.Size+uint64(s), t
 	}
+	if qbuf != nil {
+		qbuf.Flush(prev)
+	}
 	return buf.Flush(prev)
 }
 
+// clock gives the time used to bucket p: its own timestamp or, when sorting
+// by reception, the time of its record expressed in the same scale. The
+// current time is used for the packets read without their record.
+func (w *Worker) clock(p panda.Telemetry) time.Time {
+	if !w.Arrival {
+		return p.Timestamp()
+	}
+	t, ok := panda.RecordTime(p)
+	if !ok {
+		t = time.Now()
+	}
+	return t.Add(panda.UNIX.Sub(panda.GPS))
+}
+
+func (w *Worker) jumped(p panda.Telemetry) bool {
+	if w.jumps == nil {
+		return false
+	}
+	j, ok := w.jumps.Update(p)
+	if ok {
+		w.logger.Printf("apid %d (sid %d): time jumped by %s (%s -> %s)", j.Apid, j.Sid, j.Delta(), j.From.Format(time.RFC3339), j.To.Format(time.RFC3339))
+	}
+	return ok
+}
+
+// skewed reports whether the time found in the envelope of p differs from its
+// own timestamp by more than the configured maximum skew, which typically
+// reveals a packet generated with a wrong epoch.
+func (w *Worker) skewed(p panda.Telemetry) bool {
+	if w.Skew <= 0 {
+		return false
+	}
+	r, ok := panda.RecordTime(p)
+	if !ok {
+		return false
+	}
+	t := panda.AdjustTime(p.Timestamp(), false)
+	d := t.Sub(r)
+	if d < 0 {
+		d = -d
+	}
+	if d <= w.Skew {
+		return false
+	}
+	w.stats.Skip()
+	w.logger.Printf("apid %d (sid %d): packet time %s too far from record time %s (%s)", p.Apid(), p.Sid, t.Format(time.RFC3339), r.Format(time.RFC3339), d)
+	return true
+}
+
+func (w *Worker) flush(buf buffer.Buffer, t time.Time, d string) {
+	if err := buf.Flush(t); err != nil {
+		w.stats.Fail()
+		w.logger.Printf("failed to write packets: %s", err)
+	} else {
+		if w.Count > 0 {
+			w.logger.Printf("%d packets written to %s (%.2fKB)", w.Count, d, float64(w.Size)/1024.0)
+		}
+	}
+	w.Count, w.Size = 0, 0
+}
+
+func (w *Worker) decoder() panda.Decoder {
+	m := tm.Match{
+		Apids:    w.Apids,
+		Sources:  w.Sources,
+		Any:      w.Any,
+		Segments: w.Segments,
+	}
+	if w.Apid > 0 {
+		m.Apids = append(m.Apids, w.Apid)
+	}
+	d := tm.NewMatchDecoder(m)
+	if len(w.Types) > 0 {
+		x := d
+		f := func(bs []byte) (int, panda.Packet, error) {
+			n, p, err := x.Decode(bs)
+			if t, ok := p.(panda.Telemetry); ok && err == nil && !matchPacketType(w.Types, t.PacketType()) {
+				return n, nil, panda.ErrSkip
+			}
+			return n, p, err
+		}
+		d = panda.DecoderFunc(f)
+	}
+	d = w.stats.Decoder(d)
+	if w.envelope > 0 {
+		d = panda.Enveloped(d, w.envelope)
+	}
+	return d
+}
+
+func (w *Worker) open(a string) error {
+	var (
+		r   io.Reader
+		err error
+	)
+	w.envelope = 0
+	skip := panda.SkipFlagged(w.Skip)
+	switch raw := w.Raw || w.Skew > 0 || w.Arrival; {
+	case raw && w.Live:
+		r, w.envelope, err = tm.WatchRaw(a, skip)
+	case raw:
+		r, w.envelope, err = tm.OpenRaw(a, skip)
+	case w.Live:
+		r, err = tm.Watch(a, skip)
+	default:
+		r, err = tm.Open(a, skip)
+	}
+	if err == nil {
+		w.reader = r
+	}
+	return err
+}
+
+func parseSegment(s string) (panda.CCSDSPacketSegmentation, error) {
+	for f := panda.ContinuationPacket; f <= panda.UnsegmentedPacket; f++ {
+		if s == f.String() {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown segmentation flag %s", s)
+}
+
+func isPacketType(s string) bool {
+	for t := panda.Default; t <= panda.Acknowledge; t++ {
+		if matchPacketType([]string{s}, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPacketType(ts []string, t panda.ESAPacketType) bool {
+	for _, s := range ts {
+		switch s {
+		case t.Type(), t.String():
+			return true
+		case "hk":
+			if t >= panda.EssentialHk && t <= panda.PayloadHk {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *Worker) options() []buffer.Option {
+	opts := []buffer.Option{buffer.WithUTC(w.UTC), buffer.WithRaw(w.Raw)}
+	if w.naming != nil {
+		opts = append(opts, buffer.WithTemplate(w.naming))
+	}
+	return opts
+}
+
+// quarantine creates the buffer receiving the packets rejected by skewed. They
+// are always kept with their envelope so that both times can be inspected.
+func (w *Worker) quarantine(d string, c bool) buffer.Buffer {
+	opts := append(w.options(), buffer.WithRaw(true))
+	return buffer.New(w.Id+"_quarantine", d, c, opts...)
+}
+
+// buffer creates the buffer receiving the packets sorted by w: an HRDP archive
+// in the directory of w under d when w is configured with archive, files of
+// the packets received during each interval otherwise.
+func (w *Worker) buffer(d string, c bool) (buffer.Buffer, error) {
+	if w.Archive {
+		return buffer.Archive(filepath.Join(d, w.Id))
+	}
+	opts := w.options()
+	if !w.Split {
+		return buffer.New(w.Id, d, c, opts...), nil
+	}
+	return buffer.Split(w.Id, d, c, func(p panda.Packet) string {
+		if t, ok := p.(panda.Telemetry); ok {
+			return fmt.Sprintf("%04d", t.Apid())
+		}
+		return "0000"
+	}, opts...), nil
+}
+
 func (w *Worker) Close() error {
 	if w.reader == nil {
 		return fmt.Errorf("%s not yet running", w.Id)
@@ -120,10 +348,27 @@ func (w *Worker) Close() error {
 
 func (w *Worker) UnmarshalJSON(bs []byte) error {
 	v := struct {
-		Prefix  string   `json:"prefix"`
-		Apid    int      `json:"apid"`
-		Every   int      `json:"every"`
-		Sources []uint32 `json:"sources"`
+		Prefix   string     `json:"prefix"`
+		Apid     int        `json:"apid"`
+		Apids    []int      `json:"apids"`
+		Any      bool       `json:"any_header"`
+		Segments []string   `json:"segments"`
+		Every    int        `json:"every"`
+		Sources  []uint32   `json:"sources"`
+		Types    []string   `json:"types"`
+		Split    bool       `json:"split_by_apid"`
+		Naming   string     `json:"filename"`
+		Time     string     `json:"timescale"`
+		Raw      bool       `json:"raw"`
+		Live     bool       `json:"watch"`
+		Forward  string     `json:"forward"`
+		Backward int        `json:"max_backward"`
+		Ahead    int        `json:"max_forward"`
+		Clock    string     `json:"clock"`
+		Skew     int        `json:"max_skew"`
+		Isolate  bool       `json:"quarantine"`
+		Skip     panda.Flag `json:"skip"`
+		Archive  bool       `json:"archive"`
 	}{}
 	if err := json.Unmarshal(bs, &v); err != nil {
 		return err
@@ -137,6 +382,48 @@ func (w *Worker) UnmarshalJSON(bs []byte) error {
 	w.Id = v.Prefix
 	w.Apid = v.Apid
 	w.Sources = v.Sources
+	w.Apids, w.Any = v.Apids, v.Any
+	for _, s := range v.Segments {
+		f, err := parseSegment(s)
+		if err != nil {
+			return fmt.Errorf("%s: %s", v.Prefix, err)
+		}
+		w.Segments = append(w.Segments, f)
+	}
+	for _, t := range v.Types {
+		if !isPacketType(t) {
+			return fmt.Errorf("invalid packet type for %s: %s", v.Prefix, t)
+		}
+	}
+	w.Types = v.Types
+	w.Split = v.Split
+	w.Raw, w.Live = v.Raw, v.Live
+	w.Forward = v.Forward
+	w.Backward = time.Second * time.Duration(v.Backward)
+	w.Ahead = time.Second * time.Duration(v.Ahead)
+	w.Skew, w.Isolate = time.Second*time.Duration(v.Skew), v.Isolate
+	w.Skip, w.Archive = v.Skip, v.Archive
+	switch strings.ToLower(v.Clock) {
+	case "", "packet":
+	case "reception":
+		w.Arrival = true
+	default:
+		return fmt.Errorf("invalid clock for %s: %s", w.Id, v.Clock)
+	}
+	if len(v.Naming) > 0 {
+		t, err := template.New(w.Id).Parse(v.Naming)
+		if err != nil {
+			return fmt.Errorf("invalid filename template for %s: %s", w.Id, err)
+		}
+		w.Naming, w.naming = v.Naming, t
+	}
+	switch strings.ToLower(v.Time) {
+	case "", "gps":
+	case "utc":
+		w.UTC = true
+	default:
+		return fmt.Errorf("invalid timescale for %s: %s", w.Id, v.Time)
+	}
 	w.Every = time.Second * time.Duration(v.Every)
 
 	w.logger = log.New(os.Stderr, fmt.Sprintf("[%s] ", w.Id), log.LstdFlags)
@@ -147,6 +434,9 @@ func (w *Worker) UnmarshalJSON(bs []byte) error {
 func (w *Worker) sortPackets(queue <-chan panda.Telemetry, buf buffer.Buffer) error {
 	var prev time.Time
 	for p := range queue {
+		if w.skewed(p) {
+			continue
+		}
 		t := p.Timestamp()
 		if prev.IsZero() {
 			prev = t
@@ -157,6 +447,7 @@ func (w *Worker) sortPackets(queue <-chan panda.Telemetry, buf buffer.Buffer) er
 			continue
 		}
 		if err := buf.Flush(prev); err != nil {
+			w.stats.Fail()
 			w.logger.Printf("failed to write packets: %s", err)
 		} else {
 			if w.Count > 0 {
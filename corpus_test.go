@@ -0,0 +1,229 @@
+package panda
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the files of testdata")
+
+// corpusSize is the number of packets of the TM and PP archive files of
+// testdata.
+const corpusSize = 8
+
+// corpusTime is the reception time of the records of testdata/tm.dat.
+var corpusTime = time.Date(2018, 1, 12, 10, 25, 0, 0, time.UTC)
+
+// corpus gives the content of the files of testdata: the archive files of the
+// TM and PP packets built by testTelemetry and testParameter, and one file per
+// VMU packet built by testHR.
+func corpus() map[string][]byte {
+	var tm, pp bytes.Buffer
+	for i := 0; i < corpusSize; i++ {
+		bs, _ := testTelemetry(i, false).Bytes()
+		binary.Write(&tm, binary.LittleEndian, uint32(len(bs)+RecordHeaderTM))
+		tm.WriteByte(TagTM)
+		binary.Write(&tm, binary.BigEndian, uint32(corpusTime.Unix())+uint32(i))
+		tm.WriteByte(0)
+		tm.Write(bs)
+
+		bs, _ = testParameter(i).Bytes()
+		binary.Write(&pp, binary.LittleEndian, uint32(len(bs)))
+		pp.Write(bs)
+	}
+	fs := map[string][]byte{
+		"tm.dat": tm.Bytes(),
+		"pp.dat": pp.Bytes(),
+	}
+	for n, p := range corpusHR() {
+		fs[n], _ = p.Bytes()
+	}
+	return fs
+}
+
+func corpusHR() map[string]HRPacket {
+	return map[string]HRPacket{
+		"vmu_v1_image.dat": testHR(1, VMUProtocol1, true),
+		"vmu_v1_table.dat": testHR(2, VMUProtocol1, false),
+		"vmu_v2_image.dat": testHR(3, VMUProtocol2, true),
+		"vmu_v2_table.dat": testHR(4, VMUProtocol2, false),
+	}
+}
+
+func corpusVersion(n string) int {
+	if n[:6] == "vmu_v1" {
+		return VMUProtocol1
+	}
+	return VMUProtocol2
+}
+
+// TestMain rewrites the files of testdata from the packets of corpus when the
+// tests are run with -update.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if *update {
+		for n, bs := range corpus() {
+			if err := ioutil.WriteFile(filepath.Join("testdata", n), bs, 0644); err != nil {
+				panic(err)
+			}
+		}
+		bs, err := goldenHR()
+		if err != nil {
+			panic(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join("testdata", "vmu.golden"), bs, 0644); err != nil {
+			panic(err)
+		}
+	}
+	m.Run()
+}
+
+// goldenHR gives the filename and the metadata of the VMU packets of testdata
+// as they are written by the exporters.
+func goldenHR() ([]byte, error) {
+	type meta struct {
+		Filename string          `json:"filename"`
+		Header   json.RawMessage `json:"header"`
+	}
+	vs := make(map[string]meta)
+	for n, p := range corpusHR() {
+		var h interface{}
+		switch p := p.(type) {
+		case *Image:
+			h = p.IDH
+		case *Table:
+			h = p.SDH
+		}
+		bs, err := json.Marshal(h)
+		if err != nil {
+			return nil, err
+		}
+		vs[n] = meta{Filename: p.Filename(), Header: bs}
+	}
+	return json.MarshalIndent(vs, "", "  ")
+}
+
+func TestCorpusFiles(t *testing.T) {
+	for n, want := range corpus() {
+		got, err := ioutil.ReadFile(filepath.Join("testdata", n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: content does not match the encoded packets (run go test -update if the change is intended)", n)
+		}
+	}
+}
+
+func TestCorpusTM(t *testing.T) {
+	r, err := WalkRaw("tm", filepath.Join("testdata", "tm.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := NewReader(r, Enveloped(DecodeTM(), RecordLengthSize+RecordHeaderTM))
+	var i int
+	for ; ; i++ {
+		p, err := rs.ReadPacket()
+		if err == ErrDone {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := p.(Telemetry)
+		if w, ok := RecordTime(got); !ok || !w.Equal(corpusTime.Add(time.Duration(i)*time.Second)) {
+			t.Errorf("packet %d: unexpected record time %s", i, w)
+		}
+		got.raw = nil
+		if want := testTelemetry(i, false); !reflect.DeepEqual(got, want) {
+			t.Errorf("packet %d: packets mismatched\nwant: %+v\ngot:  %+v", i, want, got)
+		}
+		roundTrip(t, EncodeTM(), DecodeTM(), got)
+	}
+	if i != corpusSize {
+		t.Errorf("want %d packets, got %d", corpusSize, i)
+	}
+}
+
+func TestCorpusPP(t *testing.T) {
+	r, err := Walk("pp", filepath.Join("testdata", "pp.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := NewReader(r, DecodePP())
+	var i int
+	for ; ; i++ {
+		p, err := rs.ReadPacket()
+		if err == ErrDone {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := testParameter(i); !reflect.DeepEqual(p, want) {
+			t.Errorf("packet %d: packets mismatched\nwant: %+v\ngot:  %+v", i, want, p)
+		}
+		roundTrip(t, EncodePP(), DecodePP(), p)
+	}
+	if i != corpusSize {
+		t.Errorf("want %d packets, got %d", corpusSize, i)
+	}
+}
+
+func TestCorpusHR(t *testing.T) {
+	for n, want := range corpusHR() {
+		bs, err := ioutil.ReadFile(filepath.Join("testdata", n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := corpusVersion(n)
+		d, _ := DecodeHR(v)
+		e, _ := EncodeHR(v)
+		_, p, err := d.Decode(bs)
+		if err != nil {
+			t.Errorf("%s: %s", n, err)
+			continue
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("%s: packets mismatched\nwant: %+v\ngot:  %+v", n, want, p)
+		}
+		roundTrip(t, e, d, p)
+	}
+
+	want, err := ioutil.ReadFile(filepath.Join("testdata", "vmu.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := goldenHR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("filenames and metadata do not match vmu.golden\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+// roundTrip checks that p is given back when decoding its encoded bytes.
+func roundTrip(t *testing.T, e Encoder, d Decoder, p Packet) {
+	t.Helper()
+	bs, err := e.Encode(p)
+	if err != nil {
+		t.Errorf("encode: %s", err)
+		return
+	}
+	_, x, err := d.Decode(bs)
+	if err != nil {
+		t.Errorf("decode: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(x, p) {
+		t.Errorf("round trip: packets mismatched\nwant: %+v\ngot:  %+v", p, x)
+	}
+}
@@ -40,6 +40,21 @@ const (
 	TimeInvalid
 )
 
+func (e ESAPacketTime) String() string {
+	switch e {
+	default:
+		return "***"
+	case TimeNotUsed:
+		return "none"
+	case TimeGenerated:
+		return "generation"
+	case TimeExecuted:
+		return "execution"
+	case TimeInvalid:
+		return "invalid"
+	}
+}
+
 type ESAPacketType int
 
 const (
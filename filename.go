@@ -0,0 +1,82 @@
+package panda
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// MaxUPILength and MaxFilenameLength limit the length (in bytes) of the UPI in
+// the filenames of the products and of the filenames themselves. Zero means no
+// limit.
+var (
+	MaxUPILength      = 0
+	MaxFilenameLength = 255
+)
+
+func filename(upi, def string, id int, ch Channel, seq int, when string, offset int64, ext string) string {
+	const pattern = "%04x_%s_%d_%06d_%s_%09d.%s"
+
+	limit := MaxUPILength
+	if MaxFilenameLength > 0 {
+		n := MaxFilenameLength - len(fmt.Sprintf(pattern, id, "", ch, seq, when, offset, ext))
+		if n < hashLength {
+			// no room left by the other parts of the name: the UPI is
+			// reduced to its hash rather than being left unbounded.
+			n = hashLength
+		}
+		if limit <= 0 || n < limit {
+			limit = n
+		}
+	}
+	return fmt.Sprintf(pattern, id, sanitizeUPI(upi, def, limit), ch, seq, when, offset, ext)
+}
+
+// hashLength is the length of the hash suffixed to the sanitized UPIs.
+const hashLength = 8
+
+// sanitizeUPI gives a UPI only made of letters, digits, dashes, dots (but
+// for the first character) and underscores. Spaces become dashes and other
+// characters underscores. A UPI having other characters replaced, or cut to
+// limit, is given a suffix made of the hash of the original UPI, so that such
+// UPIs don't share a filename. Spaces alone keep the names given before.
+func sanitizeUPI(upi, def string, limit int) string {
+	if upi == "" {
+		upi = def
+	}
+	var (
+		buf     strings.Builder
+		changed bool
+	)
+	for i := 0; i < len(upi); i++ {
+		switch c := upi[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			buf.WriteByte(c)
+		case c == '.' && i > 0:
+			buf.WriteByte(c)
+		case c == ' ':
+			buf.WriteByte('-')
+		default:
+			buf.WriteByte('_')
+			changed = true
+		}
+	}
+	str := buf.String()
+	if limit > 0 && len(str) > limit {
+		str, changed = str[:limit], true
+	}
+	if !changed {
+		return str
+	}
+	h := fnv.New32a()
+	h.Write([]byte(upi))
+	sum := fmt.Sprintf("%0*x", hashLength, h.Sum32())
+	if limit > 0 && len(str)+len(sum)+1 > limit {
+		if n := limit - len(sum) - 1; n > 0 {
+			str = str[:n]
+		} else {
+			return sum
+		}
+	}
+	return str + "-" + sum
+}
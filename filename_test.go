@@ -0,0 +1,66 @@
+package panda
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"testing"
+)
+
+func testHash(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+func TestSanitizeUPI(t *testing.T) {
+	long := strings.Repeat("A", 40)
+	data := []struct {
+		UPI   string
+		Limit int
+		Want  string
+	}{
+		{UPI: "PANDA_TEST-1.0", Want: "PANDA_TEST-1.0"},
+		{UPI: "", Want: "IMG"},
+		{UPI: "A B", Want: "A-B"},
+		{UPI: "PANDA TEST 1", Want: "PANDA-TEST-1"},
+		{UPI: "A B/C", Want: "A-B_C-" + testHash("A B/C")},
+		{UPI: "A-B", Want: "A-B"},
+		{UPI: "../etc/passwd", Want: "_._etc_passwd-" + testHash("../etc/passwd")},
+		{UPI: `a\b:c*d`, Want: "a_b_c_d-" + testHash(`a\b:c*d`)},
+		{UPI: ".hidden", Want: "_hidden-" + testHash(".hidden")},
+		{UPI: "a.b", Want: "a.b"},
+		{UPI: long, Limit: 40, Want: long},
+		{UPI: long, Limit: 20, Want: "AAAAAAAAAAA-" + testHash(long)},
+		{UPI: long, Limit: 8, Want: testHash(long)},
+		{UPI: "AB", Limit: 8, Want: "AB"},
+	}
+	for _, d := range data {
+		got := sanitizeUPI(d.UPI, "IMG", d.Limit)
+		if got != d.Want {
+			t.Errorf("%q (limit %d): want %q, got %q", d.UPI, d.Limit, d.Want, got)
+		}
+		if d.Limit > 0 && len(got) > d.Limit {
+			t.Errorf("%q (limit %d): %q too long", d.UPI, d.Limit, got)
+		}
+	}
+}
+
+func TestFilenameLength(t *testing.T) {
+	defer func(u, f int) {
+		MaxUPILength, MaxFilenameLength = u, f
+	}(MaxUPILength, MaxFilenameLength)
+
+	upi := strings.Repeat("U", 300)
+	MaxUPILength = 0
+	for _, max := range []int{255, 64, 40, 10, 1} {
+		MaxFilenameLength = max
+		got := filename(upi, "IMG", 0x3A, Video1, 1, "20180114_212003", 0, "jpg")
+		if fixed := len(filename("", "", 0x3A, Video1, 1, "20180114_212003", 0, "jpg")); fixed+hashLength <= max && len(got) > max {
+			t.Errorf("max %d: filename too long (%d bytes): %s", max, len(got), got)
+		}
+		if !strings.Contains(got, testHash(upi)) {
+			t.Errorf("max %d: hash of the upi missing: %s", max, got)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package panda
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzSeeds gives the packets built by the helpers of the tests to f, whole
+// and cut in the middle of their header and of their data.
+func fuzzSeeds(f *testing.F, ps ...Packet) {
+	f.Add([]byte{})
+	for _, p := range ps {
+		bs, err := p.Bytes()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(bs)
+		f.Add(bs[:len(bs)/2])
+		f.Add(bs[:8])
+	}
+}
+
+// fuzzDecode decodes data with d, checking that it never consumes more bytes
+// than given, and gives the packet decoded if any.
+func fuzzDecode(t *testing.T, d Decoder, data []byte) Packet {
+	n, p, err := d.Decode(data)
+	if n > len(data) {
+		t.Fatalf("decoder consumed %d bytes of %d", n, len(data))
+	}
+	if err != nil || p == nil {
+		return nil
+	}
+	if _, err := p.Bytes(); err != nil {
+		t.Fatalf("decoded packet can not be encoded: %s", err)
+	}
+	return p
+}
+
+func FuzzTM(f *testing.F) {
+	fuzzSeeds(f, testTelemetry(1, false), testTelemetry(2, true))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := fuzzDecode(t, DecodeTM(), data)
+		if p == nil {
+			return
+		}
+		bs, _ := p.Bytes()
+		if !bytes.Equal(bs, data[:len(bs)]) {
+			t.Fatalf("encoded packet differs from its input\nwant: %x\ngot:  %x", data[:len(bs)], bs)
+		}
+	})
+}
+
+func FuzzTMPUS(f *testing.F) {
+	fuzzSeeds(f, testTelemetry(1, true), testTelemetry(2, false))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, DecodeTMPUS(), data)
+	})
+}
+
+func FuzzPP(f *testing.F) {
+	fuzzSeeds(f, testParameter(1), testParameter(2))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := fuzzDecode(t, DecodePP(), data)
+		if p == nil {
+			return
+		}
+		bs, _ := p.Bytes()
+		if !bytes.Equal(bs, data[:len(bs)]) {
+			t.Fatalf("encoded packet differs from its input\nwant: %x\ngot:  %x", data[:len(bs)], bs)
+		}
+	})
+}
+
+func FuzzHRv1(f *testing.F) {
+	fuzzSeeds(f, testHR(1, VMUProtocol1, true), testHR(2, VMUProtocol1, false))
+	d, _ := DecodeHR(VMUProtocol1)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, d, data)
+	})
+}
+
+func FuzzHRv2(f *testing.F) {
+	fuzzSeeds(f, testHR(1, VMUProtocol2, true), testHR(2, VMUProtocol2, false))
+	d, _ := DecodeHR(VMUProtocol2)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, d, data)
+	})
+}
@@ -0,0 +1,128 @@
+package panda
+
+import (
+	"time"
+)
+
+const maxSequence = 1 << 14
+
+type Gap struct {
+	Apid   int
+	Sid    uint32
+	Last   int
+	Next   int
+	Starts time.Time
+	Ends   time.Time
+}
+
+func (g Gap) Delta() int {
+	if g.Last < 0 {
+		return 0
+	}
+	return (g.Next - g.Last + maxSequence) % maxSequence
+}
+
+// Missing gives the number of packets lost between Last and Next. Steps
+// going back by less than half the sequence space are packets received out of
+// order (see Reordered), not losses.
+func (g Gap) Missing() int {
+	d := g.Delta()
+	if d <= 1 || g.Reordered() {
+		return 0
+	}
+	return d - 1
+}
+
+// Reordered reports whether Next comes before Last.
+func (g Gap) Reordered() bool {
+	return g.Delta() > maxSequence/2
+}
+
+func (g Gap) Duration() time.Duration {
+	return g.Ends.Sub(g.Starts)
+}
+
+type gapKey struct {
+	apid int
+	sid  uint32
+}
+
+type GapTracker struct {
+	packets map[gapKey]Telemetry
+	sid     bool
+}
+
+func NewGapTracker() *GapTracker {
+	return &GapTracker{packets: make(map[gapKey]Telemetry)}
+}
+
+func NewSIDGapTracker() *GapTracker {
+	return &GapTracker{packets: make(map[gapKey]Telemetry), sid: true}
+}
+
+func (g *GapTracker) Update(t Telemetry) (Gap, bool) {
+	k := gapKey{apid: t.Apid()}
+	if g.sid {
+		k.sid = t.Sid
+	}
+	p, ok := g.packets[k]
+	if !ok {
+		g.packets[k] = t
+		return Gap{Apid: k.apid, Sid: k.sid, Last: -1, Next: t.Sequence(), Ends: t.Timestamp()}, false
+	}
+	gap := Gap{
+		Apid:   k.apid,
+		Sid:    k.sid,
+		Last:   p.Sequence(),
+		Next:   t.Sequence(),
+		Starts: p.Timestamp(),
+		Ends:   t.Timestamp(),
+	}
+	// duplicated and late packets do not move the reference, otherwise the
+	// packet following them would be seen as a gap.
+	if gap.Delta() > 0 && !gap.Reordered() {
+		g.packets[k] = t
+	}
+	return gap, gap.Missing() > 0
+}
+
+type Jump struct {
+	Apid int
+	Sid  uint32
+	From time.Time
+	To   time.Time
+}
+
+func (j Jump) Delta() time.Duration {
+	return j.To.Sub(j.From)
+}
+
+type JumpTracker struct {
+	times    map[gapKey]time.Time
+	backward time.Duration
+	forward  time.Duration
+}
+
+// NewJumpTracker creates a tracker reporting timestamps of an APID/SID going
+// back by more than b or forward by more than f. A zero b or f disables the
+// detection of backward or forward jumps.
+func NewJumpTracker(b, f time.Duration) *JumpTracker {
+	return &JumpTracker{
+		times:    make(map[gapKey]time.Time),
+		backward: b,
+		forward:  f,
+	}
+}
+
+func (j *JumpTracker) Update(t Telemetry) (Jump, bool) {
+	k := gapKey{apid: t.Apid(), sid: t.Sid}
+	w := t.Timestamp()
+	p, ok := j.times[k]
+	j.times[k] = w
+	if !ok {
+		return Jump{Apid: k.apid, Sid: k.sid, From: w, To: w}, false
+	}
+	jump := Jump{Apid: k.apid, Sid: k.sid, From: p, To: w}
+	d := jump.Delta()
+	return jump, (j.backward > 0 && d < -j.backward) || (j.forward > 0 && d > j.forward)
+}
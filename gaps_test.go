@@ -0,0 +1,157 @@
+package panda
+
+import (
+	"testing"
+	"time"
+)
+
+func testSequence(apid, seq int) Telemetry {
+	return Telemetry{
+		CCSDSHeader: CCSDSHeader{
+			Pid:     1<<12 | 1<<11 | uint16(apid),
+			Segment: 0xC000 | uint16(seq),
+		},
+	}
+}
+
+func TestGapTracker(t *testing.T) {
+	data := []struct {
+		Name    string
+		Seqs    []int
+		Missing []int
+	}{
+		{
+			Name:    "first",
+			Seqs:    []int{100},
+			Missing: []int{0},
+		},
+		{
+			Name:    "continuous",
+			Seqs:    []int{1, 2, 3, 4},
+			Missing: []int{0, 0, 0, 0},
+		},
+		{
+			Name:    "wrap",
+			Seqs:    []int{0x3FFE, 0x3FFF, 0, 1},
+			Missing: []int{0, 0, 0, 0},
+		},
+		{
+			Name:    "duplicate",
+			Seqs:    []int{1, 2, 2, 3},
+			Missing: []int{0, 0, 0, 0},
+		},
+		{
+			Name:    "duplicate-wrap",
+			Seqs:    []int{0x3FFF, 0x3FFF, 0},
+			Missing: []int{0, 0, 0},
+		},
+		{
+			Name:    "reorder",
+			Seqs:    []int{1, 3, 2, 4},
+			Missing: []int{0, 1, 0, 0},
+		},
+		{
+			Name:    "reorder-wrap",
+			Seqs:    []int{0x3FFE, 0, 0x3FFF, 1},
+			Missing: []int{0, 1, 0, 0},
+		},
+		{
+			Name:    "gap",
+			Seqs:    []int{10, 20},
+			Missing: []int{0, 9},
+		},
+		{
+			Name:    "gap-wrap",
+			Seqs:    []int{0x3FFD, 2},
+			Missing: []int{0, 4},
+		},
+		{
+			Name:    "gap-wrap-zero",
+			Seqs:    []int{0x3FFF, 1},
+			Missing: []int{0, 1},
+		},
+	}
+	for _, d := range data {
+		g := NewGapTracker()
+		for i, s := range d.Seqs {
+			gap, ok := g.Update(testSequence(0x386, s))
+			if want := d.Missing[i] > 0; ok != want {
+				t.Errorf("%s: packet %d (%04x): want gap %t, got %t", d.Name, i, s, want, ok)
+			}
+			if m := gap.Missing(); m != d.Missing[i] {
+				t.Errorf("%s: packet %d (%04x): want %d missing, got %d", d.Name, i, s, d.Missing[i], m)
+			}
+		}
+	}
+}
+
+func TestGapTrackerApids(t *testing.T) {
+	g := NewGapTracker()
+	g.Update(testSequence(1, 10))
+	g.Update(testSequence(2, 500))
+	if gap, ok := g.Update(testSequence(1, 11)); ok {
+		t.Errorf("apid 1: unexpected gap of %d", gap.Missing())
+	}
+	if gap, ok := g.Update(testSequence(2, 503)); !ok || gap.Missing() != 2 {
+		t.Errorf("apid 2: want 2 missing, got %d", gap.Missing())
+	}
+}
+
+func TestGapTrackerSID(t *testing.T) {
+	g := NewSIDGapTracker()
+	p, q := testSequence(1, 10), testSequence(1, 11)
+	p.Sid, q.Sid = 1, 2
+	g.Update(p)
+	if _, ok := g.Update(q); ok {
+		t.Errorf("first packet of sid 2 seen as a gap")
+	}
+	p.Segment = 0xC000 | 12
+	if gap, ok := g.Update(p); !ok || gap.Missing() != 1 {
+		t.Errorf("sid 1: want 1 missing, got %d", gap.Missing())
+	}
+}
+
+func TestJumpTracker(t *testing.T) {
+	data := []struct {
+		Name     string
+		Backward time.Duration
+		Forward  time.Duration
+		Times    []uint32
+		Jumps    []bool
+	}{
+		{
+			Name:  "disabled",
+			Times: []uint32{100, 50, 200, 10},
+			Jumps: []bool{false, false, false, false},
+		},
+		{
+			Name:     "backward",
+			Backward: 10 * time.Second,
+			Times:    []uint32{100, 95, 80, 1000},
+			Jumps:    []bool{false, false, true, false},
+		},
+		{
+			Name:    "forward",
+			Forward: 10 * time.Second,
+			Times:   []uint32{100, 105, 200, 10},
+			Jumps:   []bool{false, false, true, false},
+		},
+		{
+			Name:     "both",
+			Backward: 10 * time.Second,
+			Forward:  10 * time.Second,
+			Times:    []uint32{100, 105, 200, 10},
+			Jumps:    []bool{false, false, true, true},
+		},
+	}
+	for _, d := range data {
+		j := NewJumpTracker(d.Backward, d.Forward)
+		for i, c := range d.Times {
+			p := testSequence(0x386, i)
+			p.ESAHeader.Coarse = c
+			if _, got := j.Update(p); got != d.Jumps[i] {
+				t.Errorf("%s: packet %d: want jump %t, got %t", d.Name, i, d.Jumps[i], got)
+			}
+		}
+	}
+}
@@ -2,7 +2,10 @@ package panda
 
 import (
 	"bufio"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"time"
 )
@@ -16,6 +19,9 @@ var (
 	ErrDone     = errors.New("done")
 	ErrSkip     = errors.New("skip")
 	ErrTooShort = errors.New("not enough bytes available")
+	ErrChecksum = errors.New("invalid checksum")
+	ErrLength   = errors.New("invalid packet length")
+	ErrChannel  = errors.New("unknown channel")
 )
 
 var BufferSize = 1024 * 1024 * 4
@@ -35,7 +41,152 @@ func (d DecoderFunc) Decode(bs []byte) (int, Packet, error) {
 	return d(bs)
 }
 
-func DecodePP() Decoder {
+type DecodeOption func(*decodeConfig)
+
+type decodeConfig struct {
+	fine  FineTime
+	epoch *Epoch
+	crc   ChecksumPolicy
+	pus   bool
+}
+
+// ChecksumPolicy tells DecodeTM what to do with the packets having an invalid
+// packet error control.
+type ChecksumPolicy int
+
+const (
+	ChecksumIgnore ChecksumPolicy = iota
+	ChecksumFlag
+	ChecksumReject
+)
+
+// WithChecksum makes DecodeTM verify the checksum of the packets having one.
+// Invalid packets are marked as Corrupted (ChecksumFlag) or not decoded at all,
+// ErrChecksum being returned instead (ChecksumReject).
+func WithChecksum(p ChecksumPolicy) DecodeOption {
+	return func(c *decodeConfig) {
+		c.crc = p
+	}
+}
+
+// WithPUS makes DecodeTM read the data field header of the packets as a PUS
+// header instead of an ESA header.
+func WithPUS() DecodeOption {
+	return func(c *decodeConfig) {
+		c.pus = true
+	}
+}
+
+func WithFineTime(f FineTime) DecodeOption {
+	return func(c *decodeConfig) {
+		c.fine = f
+	}
+}
+
+func WithEpoch(base, pivot time.Time) DecodeOption {
+	if pivot.IsZero() {
+		pivot = time.Now()
+	}
+	return func(c *decodeConfig) {
+		c.epoch = &Epoch{Base: base, Pivot: pivot}
+	}
+}
+
+func Enveloped(d Decoder, n int) Decoder {
+	f := func(bs []byte) (int, Packet, error) {
+		if len(bs) < n {
+			return len(bs), nil, ErrTooShort
+		}
+		c, p, err := d.Decode(bs[n:])
+		if err != nil {
+			if err == ErrSkip {
+				c = len(bs) - n
+			}
+			return n + c, p, err
+		}
+		switch x := p.(type) {
+		case Telemetry:
+			if c <= 0 {
+				c = CCSDSLength + x.Len()
+			}
+			x.raw = envelope(bs, n+c)
+			p = x
+		case Parameter:
+			if c <= 0 {
+				c = UMILength + len(x.Data)
+			}
+			x.raw = envelope(bs, n+c)
+			p = x
+		}
+		return n + c, p, nil
+	}
+	return DecoderFunc(f)
+}
+
+func envelope(bs []byte, n int) []byte {
+	if n > len(bs) {
+		n = len(bs)
+	}
+	raw := make([]byte, n)
+	copy(raw, bs)
+	return raw
+}
+
+func RawBytes(p Packet) ([]byte, error) {
+	switch x := p.(type) {
+	case Telemetry:
+		if x.raw != nil {
+			return x.raw, nil
+		}
+	case Parameter:
+		if x.raw != nil {
+			return x.raw, nil
+		}
+	}
+	return p.Bytes()
+}
+
+// RecordTime gives the acquisition time stored in the envelope kept by
+// Enveloped. It handles both archive records and HRD frames of telemetry
+// packets; false is returned when p carries no such time.
+func RecordTime(p Packet) (time.Time, bool) {
+	t, ok := p.(Telemetry)
+	if !ok || t.raw == nil {
+		return time.Time{}, false
+	}
+	n := len(t.raw) - (CCSDSLength + t.Len())
+	if n < FrameTM {
+		return time.Time{}, false
+	}
+	e := t.raw[:n]
+
+	var coarse, fine int64
+	switch z := int(binary.LittleEndian.Uint32(e)); {
+	case e[RecordLengthSize] == TagTM && z == len(t.raw)-RecordLengthSize:
+		coarse = int64(binary.BigEndian.Uint32(e[RecordLengthSize+1:]))
+		fine = int64(e[RecordLengthSize+5]) * 1000 / 256
+	case e[0] == TagTM:
+		coarse = int64(binary.BigEndian.Uint32(e[1:]))
+		fine = int64(e[5])
+	default:
+		return time.Time{}, false
+	}
+	if coarse == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(coarse, fine*int64(time.Millisecond)).UTC(), true
+}
+
+func configure(opts []DecodeOption) decodeConfig {
+	var c decodeConfig
+	for _, o := range opts {
+		o(&c)
+	}
+	return c
+}
+
+func DecodePP(opts ...DecodeOption) Decoder {
+	c := configure(opts)
 	f := func(bs []byte) (int, Packet, error) {
 		if len(bs) < UMILength {
 			return len(bs), nil, ErrTooShort
@@ -47,6 +198,10 @@ func DecodePP() Decoder {
 		if pp.UMIHeader, err = decodeUMI(bs[:UMILength]); err != nil {
 			return len(bs), nil, err
 		}
+		if len(bs) < UMILength+int(pp.UMIHeader.Length) {
+			return len(bs), nil, ErrTooShort
+		}
+		pp.UMIHeader.Scale, pp.UMIHeader.Epoch = c.fine, c.epoch
 		pp.Data = make([]byte, pp.UMIHeader.Length)
 		copy(pp.Data, bs[UMILength:])
 
@@ -55,9 +210,19 @@ func DecodePP() Decoder {
 	return DecoderFunc(f)
 }
 
-func DecodeTM() Decoder {
+// DecodeTMPUS is DecodeTM for packets having a PUS data field header.
+func DecodeTMPUS(opts ...DecodeOption) Decoder {
+	return DecodeTM(append(opts, WithPUS())...)
+}
+
+func DecodeTM(opts ...DecodeOption) Decoder {
+	c := configure(opts)
+	size := ESALength
+	if c.pus {
+		size = PUSLength
+	}
 	f := func(bs []byte) (int, Packet, error) {
-		if len(bs) < CCSDSLength+ESALength {
+		if len(bs) < CCSDSLength+size {
 			return 0, nil, ErrTooShort
 		}
 		var (
@@ -67,11 +232,37 @@ func DecodeTM() Decoder {
 		if tm.CCSDSHeader, err = decodeCCSDS(bs[:CCSDSLength]); err != nil {
 			return len(bs), nil, err
 		}
-		if tm.ESAHeader, err = decodeESA(bs[CCSDSLength : CCSDSLength+ESALength]); err != nil {
-			return len(bs), nil, err
+		if n := int(tm.CCSDSHeader.Length) + 1; n < size {
+			return len(bs), nil, ErrLength
+		} else if len(bs) < CCSDSLength+n {
+			return len(bs), nil, ErrTooShort
+		}
+		if c.pus {
+			h, err := decodePUS(bs[CCSDSLength : CCSDSLength+PUSLength])
+			if err != nil {
+				return len(bs), nil, err
+			}
+			h.Scale, h.Epoch = c.fine, c.epoch
+			tm.PUS = &h
+		} else {
+			if tm.ESAHeader, err = decodeESA(bs[CCSDSLength : CCSDSLength+ESALength]); err != nil {
+				return len(bs), nil, err
+			}
+			tm.ESAHeader.Scale, tm.ESAHeader.Epoch = c.fine, c.epoch
+		}
+		tm.Data = make([]byte, int(tm.CCSDSHeader.Length)+1-size)
+		copy(tm.Data, bs[CCSDSLength+size:])
+		if n := len(tm.Data); tm.ESAHeader.Sum() && n >= 2 {
+			tm.Sum = binary.BigEndian.Uint16(tm.Data[n-2:])
+		}
+		switch c.crc {
+		case ChecksumFlag:
+			tm.Corrupted = !tm.Verify()
+		case ChecksumReject:
+			if !tm.Verify() {
+				return len(bs), nil, ErrChecksum
+			}
 		}
-		tm.Data = make([]byte, tm.CCSDSHeader.Length+1-ESALength)
-		copy(tm.Data, bs[CCSDSLength+ESALength:])
 
 		// return CCSDSLength + ESALength + len(tm.Data), tm, nil
 		return 0, tm, nil
@@ -79,14 +270,90 @@ func DecodeTM() Decoder {
 	return DecoderFunc(f)
 }
 
+type ReaderOption func(*readerConfig)
+
+type readerConfig struct {
+	size   int
+	accept func([]byte) bool
+}
+
+// WithBufferSize sets the size of the buffer packets are read into. It
+// should be at least as large as the largest packet expected.
+func WithBufferSize(n int) ReaderOption {
+	return func(c *readerConfig) {
+		if n > 0 {
+			c.size = n
+		}
+	}
+}
+
+// WithPredicate sets a function called with the bytes of each packet before
+// they are given to the decoder. Packets for which it returns false are skipped
+// without being decoded, so it should only look at their headers.
+func WithPredicate(f func([]byte) bool) ReaderOption {
+	return func(c *readerConfig) {
+		c.accept = f
+	}
+}
+
+// DecodeError describes a packet that could not be decoded. File is only set
+// when the source walks an archive, Offset then being the one of the record in
+// that file; otherwise Offset counts the bytes read from the source.
+type DecodeError struct {
+	File   string
+	Offset int64
+	Raw    []byte
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	if len(e.File) > 0 {
+		return fmt.Sprintf("%s: invalid packet at %d: %s", e.File, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("invalid packet at %d: %s", e.Offset, e.Err)
+}
+
+type item struct {
+	packet Packet
+	err    error
+}
+
 type Reader struct {
 	reader io.Reader
-	queue  <-chan Packet
+	queue  <-chan item
 }
 
-func NewReader(r io.Reader, d Decoder) *Reader {
-	q := make(chan Packet)
-	go readAll(bufio.NewReader(r), d, q)
+func NewReader(r io.Reader, d Decoder, opts ...ReaderOption) *Reader {
+	return NewReaderContext(context.Background(), r, d, opts...)
+}
+
+// NewReaderContext creates a Reader that stops decoding packets when ctx is
+// done. The underlying reader is then closed if it is an io.Closer so that a
+// pending read returns.
+func NewReaderContext(ctx context.Context, r io.Reader, d Decoder, opts ...ReaderOption) *Reader {
+	c := readerConfig{size: BufferSize}
+	for _, o := range opts {
+		o(&c)
+	}
+	q := make(chan item)
+	go func() {
+		done := make(chan struct{})
+		defer close(done)
+		if x, ok := r.(io.Closer); ok && ctx.Done() != nil {
+			go func() {
+				select {
+				case <-ctx.Done():
+					x.Close()
+				case <-done:
+				}
+			}()
+		}
+		x := &source{Reader: bufio.NewReader(r)}
+		if p, ok := r.(positioner); ok {
+			x.pos = p
+		}
+		readAll(ctx, x, d, q, c)
+	}()
 	return &Reader{
 		reader: r,
 		queue:  q,
@@ -97,12 +364,28 @@ func NewReaderWithSize(r io.Reader, s int, d Decoder) *Reader {
 	return NewReader(bufio.NewReaderSize(r, s), d)
 }
 
+// Read returns the next decoded packet, silently skipping the ones that could
+// not be decoded. The errors of the source are returned.
 func (r *Reader) Read() (Packet, error) {
-	p, ok := <-r.queue
+	for {
+		p, err := r.ReadPacket()
+		if _, ok := err.(*DecodeError); ok {
+			continue
+		}
+		return p, err
+	}
+}
+
+// ReadPacket returns the next packet or a *DecodeError for a packet that could
+// not be decoded. Reading can go on after such an error; ErrDone is returned
+// once the source is exhausted. Other errors come from the source and end the
+// reading.
+func (r *Reader) ReadPacket() (Packet, error) {
+	i, ok := <-r.queue
 	if !ok {
 		return nil, ErrDone
 	}
-	return p, nil
+	return i.packet, i.err
 }
 
 func (r *Reader) Close() error {
@@ -112,25 +395,76 @@ func (r *Reader) Close() error {
 	return nil
 }
 
-func readAll(r io.Reader, d Decoder, q chan<- Packet) {
+type positioner interface {
+	Position() (string, int64)
+}
+
+// source keeps track of where the bytes handed to the decoder come from.
+type source struct {
+	io.Reader
+	pos positioner
+
+	offset int64
+	last   int64
+}
+
+func (s *source) Read(bs []byte) (int, error) {
+	n, err := s.Reader.Read(bs)
+	s.last, s.offset = s.offset, s.offset+int64(n)
+	return n, err
+}
+
+func (s *source) Error(err error, bs []byte, i int) *DecodeError {
+	e := DecodeError{Err: err, Offset: s.last + int64(i)}
+	if s.pos != nil {
+		e.File, e.Offset = s.pos.Position()
+	}
+	if len(bs) > i {
+		e.Raw = make([]byte, len(bs)-i)
+		copy(e.Raw, bs[i:])
+	}
+	return &e
+}
+
+func readAll(ctx context.Context, r *source, d Decoder, q chan<- item, cfg readerConfig) {
 	defer close(q)
-	bs := make([]byte, BufferSize)
+	send := func(i item) bool {
+		select {
+		case q <- i:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	bs := make([]byte, cfg.size)
 	for {
 		n, err := r.Read(bs)
 		if err != nil {
+			// errors of the source are not about a packet: they are given
+			// as is and end the reading.
+			if err != io.EOF && err != ErrDone && ctx.Err() == nil {
+				send(item{err: err})
+			}
 			return
 		}
 		if n == 0 {
 			continue
 		}
 		for i, vs := 0, bs[:n]; i < len(vs); {
+			if cfg.accept != nil && !cfg.accept(vs[i:]) {
+				break
+			}
 			c, p, err := d.Decode(vs[i:])
 			switch err {
 			case nil:
-				q <- p
+				if !send(item{packet: p}) {
+					return
+				}
 			case ErrSkip:
 			default:
-				return
+				if !send(item{err: r.Error(err, vs, i)}) {
+					return
+				}
 			}
 			if c <= 0 {
 				break
@@ -0,0 +1,37 @@
+package panda
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type failReader struct {
+	err error
+}
+
+func (f failReader) Read([]byte) (int, error) {
+	return 0, f.err
+}
+
+func TestReaderSourceError(t *testing.T) {
+	bad := errors.New("read failure")
+
+	bs, err := testTelemetry(1, false).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := io.MultiReader(bytes.NewReader(bs), failReader{bad})
+	rs := NewReader(r, DecodeTM())
+
+	if _, err := rs.Read(); err != nil {
+		t.Fatalf("packet before the failure: %s", err)
+	}
+	if _, err := rs.Read(); err != bad {
+		t.Fatalf("want error %q, got %v", bad, err)
+	}
+	if _, err := rs.Read(); err != ErrDone {
+		t.Errorf("want ErrDone after the failure, got %v", err)
+	}
+}
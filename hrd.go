@@ -3,13 +3,13 @@ package panda
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
 	"io"
-	"strings"
 	"time"
 
 	//"github.com/busoc/timutil"
@@ -34,6 +34,23 @@ type HRPacket interface {
 	IsRealtime() bool
 }
 
+// Product is implemented by the HR packets knowing the UPI of the product they
+// carry, as Image and Table do. It is kept apart from HRPacket so that the
+// implementations of HRPacket outside of this package remain valid; use
+// PacketUPI to get the UPI of any HRPacket.
+type Product interface {
+	UPI() string
+}
+
+// PacketUPI gives the UPI of p, or an empty string when p does not implement
+// Product.
+func PacketUPI(p HRPacket) string {
+	if u, ok := p.(Product); ok {
+		return u.UPI()
+	}
+	return ""
+}
+
 type Four interface {
 	FCC() uint32
 }
@@ -110,15 +127,34 @@ func Valid(p HRPacket) bool {
 	return cmp == sum
 }
 
-func DecodeHR(v int) (Decoder, error) {
+func DecodeHR(v int, opts ...DecodeOption) (Decoder, error) {
+	var d DecoderFunc
 	switch v {
 	default:
 		return nil, fmt.Errorf("unsupported vmu protocol version: %d", v)
 	case VMUProtocol1:
-		return DecoderFunc(decodeVMUv1), nil
+		d = decodeVMUv1
 	case VMUProtocol2:
-		return DecoderFunc(decodeVMUv2), nil
+		d = decodeVMUv2
 	}
+	c := configure(opts)
+	if c.fine == FineMillis && c.epoch == nil {
+		return d, nil
+	}
+	f := func(bs []byte) (int, Packet, error) {
+		n, p, err := d(bs)
+		switch p := p.(type) {
+		case *Image:
+			p.VMUHeader.Scale, p.VMUHeader.Epoch = c.fine, c.epoch
+			if i, ok := p.IDH.(*IDHv1); ok {
+				i.Epoch = c.epoch
+			}
+		case *Table:
+			p.VMUHeader.Scale, p.VMUHeader.Epoch = c.fine, c.epoch
+		}
+		return n, p, err
+	}
+	return DecoderFunc(f), nil
 }
 
 func decodeVMUv2(bs []byte) (int, Packet, error) {
@@ -128,7 +164,7 @@ func decodeVMUv2(bs []byte) (int, Packet, error) {
 	// 	return len(bs), nil, fmt.Errorf("packet size too short: %d (sciences: %d bytes, images: %d bytes)", len(bs), sdh, idh)
 	// }
 	if len(bs) < VMUHeaderLength {
-		return len(bs), nil, fmt.Errorf("packet too short: %d (expected at least %d)", len(bs), VMUHeaderLength)
+		return len(bs), nil, ErrTooShort
 	}
 	ix := VMUHeaderLength
 	v, err := decodeVMU(bs[:ix])
@@ -139,11 +175,11 @@ func decodeVMUv2(bs []byte) (int, Packet, error) {
 	var p Packet
 	switch v.Channel {
 	default:
-		return len(bs), nil, fmt.Errorf("unknown channel %d", v.Channel)
+		return len(bs), nil, ErrChannel
 	case Video1, Video2:
 		idh := VMUHeaderLength + IDHeaderLengthV2
 		if len(bs) <= idh {
-			return len(bs), nil, fmt.Errorf("packet too short: %d (expected at least %d)", len(bs), idh)
+			return len(bs), nil, ErrTooShort
 		}
 		h, err := decodeIDHv2(bs[ix : ix+IDHeaderLengthV2])
 		if err != nil {
@@ -152,7 +188,7 @@ func decodeVMUv2(bs []byte) (int, Packet, error) {
 		ix += IDHeaderLengthV2
 		length := len(bs)-ix-4
 		if (length <= 0) {
-			return len(bs), nil, fmt.Errorf("packet too short: %d", len(bs))
+			return len(bs), nil, ErrTooShort
 		}
 		vs := make([]byte, length)
 		copy(vs, bs[ix:len(bs)-4])
@@ -165,7 +201,7 @@ func decodeVMUv2(bs []byte) (int, Packet, error) {
 	case Science:
 		sdh := VMUHeaderLength + SDHeaderLengthV2
 		if len(bs) <= sdh {
-			return len(bs), nil, fmt.Errorf("packet too short: %d (expected at least %d)", len(bs), sdh)
+			return len(bs), nil, ErrTooShort
 		}
 		h, err := decodeSDHv2(bs[ix : ix+SDHeaderLengthV2])
 		if err != nil {
@@ -174,7 +210,7 @@ func decodeVMUv2(bs []byte) (int, Packet, error) {
 		ix += SDHeaderLengthV2
 		length := len(bs)-ix-4
 		if (length <= 0) {
-			return len(bs), nil, fmt.Errorf("packet too short: %d", len(bs))
+			return len(bs), nil, ErrTooShort
 		}
 		vs := make([]byte, length)
 		copy(vs, bs[ix:len(bs)-4])
@@ -189,6 +225,9 @@ func decodeVMUv2(bs []byte) (int, Packet, error) {
 }
 
 func decodeVMUv1(bs []byte) (int, Packet, error) {
+	if len(bs) < VMUHeaderLength {
+		return len(bs), nil, ErrTooShort
+	}
 	ix := VMUHeaderLength
 	v, err := decodeVMU(bs[:ix])
 	if err != nil {
@@ -198,8 +237,11 @@ func decodeVMUv1(bs []byte) (int, Packet, error) {
 	var p Packet
 	switch v.Channel {
 	default:
-		return len(bs), nil, fmt.Errorf("unknown channel %d", v.Channel)
+		return len(bs), nil, ErrChannel
 	case Video1, Video2:
+		if len(bs) < ix+IDHeaderLengthV1+4 {
+			return len(bs), nil, ErrTooShort
+		}
 		h, err := decodeIDHv1(bs[ix : ix+IDHeaderLengthV1])
 		if err != nil {
 			return len(bs), nil, err
@@ -207,8 +249,11 @@ func decodeVMUv1(bs []byte) (int, Packet, error) {
 		ix += IDHeaderLengthV1
 		vs := make([]byte, len(bs)-ix-4)
 		copy(vs, bs[ix:len(bs)-4])
-		p = &Image{VMUHeader: &v, IDH: &h, Data: vs}
+		p = &Image{VMUHeader: &v, IDH: &h, Data: vs, Sum: binary.LittleEndian.Uint32(bs[len(bs)-4:])}
 	case Science:
+		if len(bs) < ix+SDHeaderLengthV1+4 {
+			return len(bs), nil, ErrTooShort
+		}
 		h, err := decodeSDHv1(bs[ix : ix+SDHeaderLengthV1])
 		if err != nil {
 			return len(bs), nil, err
@@ -216,7 +261,7 @@ func decodeVMUv1(bs []byte) (int, Packet, error) {
 		ix += SDHeaderLengthV1
 		vs := make([]byte, len(bs)-ix-4)
 		copy(vs, bs[ix:len(bs)-4])
-		p = &Table{VMUHeader: &v, SDH: &h, Data: vs}
+		p = &Table{VMUHeader: &v, SDH: &h, Data: vs, Sum: binary.LittleEndian.Uint32(bs[len(bs)-4:])}
 	}
 	return len(bs), p, nil
 }
@@ -248,6 +293,9 @@ type VMUHeader struct {
 	Sequence uint32
 	Coarse   uint32
 	Fine     uint16
+
+	Scale FineTime
+	Epoch *Epoch
 }
 
 func (v *VMUHeader) Stream() Channel {
@@ -255,8 +303,7 @@ func (v *VMUHeader) Stream() Channel {
 }
 
 func (v *VMUHeader) Timestamp() time.Time {
-	t := time.Unix(int64(v.Coarse), 0).Add(time.Duration(v.Fine)*time.Millisecond)
-	return t.UTC()
+	return v.Epoch.Time(v.Coarse, v.Scale.Duration(v.Fine))
 	//return timutil.Join6(v.Coarse, v.Fine)
 }
 
@@ -322,6 +369,14 @@ func (s SDHv1) FCC() uint32 {
 	return binary.BigEndian.Uint32(MMA)
 }
 
+func (s SDHv1) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Sequence uint32 `json:"sequence"`
+		Format   string `json:"format"`
+	}{s.Sequence, s.Format()}
+	return json.Marshal(v)
+}
+
 type IDHv1 struct {
 	XMLName xml.Name `xml:"metadata"`
 
@@ -337,6 +392,8 @@ type IDHv1 struct {
 	LineDrop  uint8
 	FrameDrop uint16
 	Info      [32]byte
+
+	Epoch *Epoch
 }
 
 func (i *IDHv1) Format() string {
@@ -416,12 +473,52 @@ func (i *IDHv1) MarshalXML(e *xml.Encoder, s xml.StartElement) error {
 	return nil
 }
 
+func (i *IDHv1) MarshalJSON() ([]byte, error) {
+	rx, ry := uint32(i.Region>>32), uint32(i.Region&0xFFFFFFFF)
+	v := struct {
+		Sequence  uint32    `json:"sequence"`
+		When      time.Time `json:"timestamp"`
+		Part      uint8     `json:"portion"`
+		Video     uint8     `json:"video"`
+		Type      uint8     `json:"type"`
+		Format    string    `json:"format"`
+		Rate      float32   `json:"rate"`
+		X         uint16    `json:"x"`
+		Y         uint16    `json:"y"`
+		OffsetX   uint16    `json:"offset-x"`
+		SizeX     uint16    `json:"size-x"`
+		OffsetY   uint16    `json:"offset-y"`
+		SizeY     uint16    `json:"size-y"`
+		LineDrop  uint8     `json:"line-drop"`
+		FrameDrop uint16    `json:"frame-drop"`
+		Info      string    `json:"info,omitempty"`
+	}{
+		Sequence:  i.Sequence,
+		When:      i.Timestamp(),
+		Part:      i.Part,
+		Video:     i.Video,
+		Type:      i.Type,
+		Format:    i.Format(),
+		Rate:      i.Rate,
+		X:         i.X(),
+		Y:         i.Y(),
+		OffsetX:   uint16(rx >> 16),
+		SizeX:     uint16(rx & 0xFFFF),
+		OffsetY:   uint16(ry >> 16),
+		SizeY:     uint16(ry & 0xFFFF),
+		LineDrop:  i.LineDrop,
+		FrameDrop: i.FrameDrop,
+		Info:      string(bytes.Trim(i.Info[:], "\x00")),
+	}
+	return json.Marshal(v)
+}
+
 func (i *IDHv1) Timestamp() time.Time {
 	ms := time.Duration(i.Fine) * time.Millisecond
-	t := time.Unix(int64(i.Coarse), 0).Add(ms)
+	t := i.Epoch.Time(i.Coarse, ms)
 
 	// return t.Add(epoch).UTC()
-	return t.UTC()
+	return t
 }
 
 func decodeIDHv1(bs []byte) (IDHv1, error) {
@@ -540,6 +637,31 @@ func (s SDHv2) Timestamp() time.Time {
 	return GPS.Add(s.Acquisition).UTC()
 }
 
+func (s SDHv2) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Id         uint8     `json:"properties"`
+		Type       uint8     `json:"type"`
+		Stream     uint16    `json:"stream"`
+		Originator uint32    `json:"originator"`
+		When       time.Time `json:"timestamp"`
+		Auxiliary  time.Time `json:"auxiliary"`
+		Source     uint8     `json:"source"`
+		Format     string    `json:"format"`
+		UPI        string    `json:"upi,omitempty"`
+	}{
+		Id:         s.Properties & 0x0F,
+		Type:       s.Properties >> 4,
+		Stream:     s.Sequence,
+		Originator: s.Originator,
+		When:       s.Timestamp(),
+		Auxiliary:  GPS.Add(s.Auxiliary).UTC(),
+		Source:     s.Id,
+		Format:     s.Format(),
+		UPI:        LookupUPI(s.Info[:]),
+	}
+	return json.Marshal(v)
+}
+
 type IDHv2 struct {
 	XMLName     xml.Name `xml:"metadata"`
 	Properties  uint8
@@ -652,6 +774,50 @@ func (i *IDHv2) MarshalXML(e *xml.Encoder, s xml.StartElement) error {
 	return nil
 }
 
+func (i *IDHv2) MarshalJSON() ([]byte, error) {
+	rx, ry := uint32(i.Region&0xFFFFFFFF), uint32(i.Region>>32)
+	v := struct {
+		Id         uint8     `json:"properties"`
+		Type       uint8     `json:"type"`
+		Stream     uint16    `json:"stream"`
+		Originator uint32    `json:"originator"`
+		When       time.Time `json:"timestamp"`
+		Auxiliary  time.Time `json:"auxiliary"`
+		Format     string    `json:"format"`
+		X          uint16    `json:"x"`
+		Y          uint16    `json:"y"`
+		OffsetX    uint16    `json:"offset-x"`
+		SizeX      uint16    `json:"size-x"`
+		OffsetY    uint16    `json:"offset-y"`
+		SizeY      uint16    `json:"size-y"`
+		Dropping   uint16    `json:"dropping"`
+		ScaleX     uint32    `json:"scaling-x"`
+		ScaleY     uint32    `json:"scaling-y"`
+		Ratio      uint8     `json:"force-aspect-ratio"`
+		UPI        string    `json:"upi,omitempty"`
+	}{
+		Id:         i.Properties & 0x0F,
+		Type:       i.Properties >> 4,
+		Stream:     i.Sequence,
+		Originator: i.Originator,
+		When:       i.Timestamp(),
+		Auxiliary:  GPS.Add(i.Auxiliary).UTC(),
+		Format:     i.Format(),
+		X:          i.X(),
+		Y:          i.Y(),
+		OffsetX:    uint16(rx & 0xFFFF),
+		SizeX:      uint16(rx >> 16),
+		OffsetY:    uint16(ry & 0xFFFF),
+		SizeY:      uint16(ry >> 16),
+		Dropping:   i.Dropping,
+		ScaleX:     i.Scaling & 0x0000FFFF,
+		ScaleY:     i.Scaling >> 16,
+		Ratio:      i.Ratio,
+		UPI:        LookupUPI(i.Info[:]),
+	}
+	return json.Marshal(v)
+}
+
 func (i IDHv2) Format() string {
 	switch i.Type {
 	default:
@@ -776,13 +942,21 @@ func (t *Table) Version() int {
 	}
 }
 
+// UPI gives the UPI of t, the one registered for its Info field if any (see
+// RegisterUPI).
+func (t *Table) UPI() string {
+	if v, ok := t.SDH.(*SDHv2); ok {
+		return LookupUPI(v.Info[:])
+	}
+	return ""
+}
+
 func (t *Table) Filename() string {
 	var (
 		id, seq int
 		delta   time.Duration
 	)
 
-	upi := "SCIENCE"
 	switch v := t.SDH.(type) {
 	default:
 		id = int(t.VMUHeader.Channel)
@@ -791,9 +965,6 @@ func (t *Table) Filename() string {
 		delta = time.Second
 	case *SDHv2:
 		id, seq = int(v.Id), int(v.Originator)
-		if bs := bytes.Trim(v.Info[:], "\x00"); len(bs) > 0 {
-			upi = strings.Replace(string(bs), " ", "-", -1)
-		}
 		delta = AdjustTime(t.VMUHeader.Timestamp(), false).Sub(v.Timestamp())
 	}
 	offset := int64(delta.Minutes())
@@ -803,7 +974,7 @@ func (t *Table) Filename() string {
 	if !Valid(t) {
 		ext += ".bad"
 	}
-	return fmt.Sprintf("%04x_%s_%d_%06d_%s_%09d.%s", id, upi, t.Stream(), seq, n, offset, ext)
+	return filename(t.UPI(), "SCIENCE", id, t.Stream(), seq, n, offset, ext)
 }
 
 func (t *Table) Timestamp() time.Time {
@@ -933,27 +1104,32 @@ func (i *Image) Version() int {
 	}
 }
 
+// UPI gives the UPI of i, the one registered for its Info field if any (see
+// RegisterUPI).
+func (i *Image) UPI() string {
+	switch v := i.IDH.(type) {
+	case *IDHv1:
+		return LookupUPI(v.Info[:])
+	case *IDHv2:
+		return LookupUPI(v.Info[:])
+	}
+	return ""
+}
+
 func (i *Image) Filename() string {
 	var (
 		id, seq int
 		ext     string
 		delta   time.Duration
 	)
-	upi := "IMG"
 	switch v := i.IDH.(type) {
 	default:
 		id, ext = int(i.VMUHeader.Channel), "raw"
 	case *IDHv1:
 		id, ext, seq = int(i.VMUHeader.Channel), v.Format(), int(v.Sequence)
-		if bs := bytes.Trim(v.Info[:], "\x00"); len(bs) > 0 {
-			upi = strings.Replace(string(bs), " ", "-", -1)
-		}
 		delta = AdjustTime(i.VMUHeader.Timestamp(), false).Sub(v.Timestamp())
 	case *IDHv2:
 		id, ext, seq = int(v.Id), v.Format(), int(v.Originator)
-		if bs := bytes.Trim(v.Info[:], "\x00"); len(bs) > 0 {
-			upi = strings.Replace(string(bs), " ", "-", -1)
-		}
 		delta = AdjustTime(i.VMUHeader.Timestamp(), false).Sub(v.Timestamp())
 	}
 	n := i.Timestamp().Format("20060102_150405")
@@ -961,7 +1137,7 @@ func (i *Image) Filename() string {
 	if !Valid(i) {
 		ext += ".bad"
 	}
-	return fmt.Sprintf("%04x_%s_%d_%06d_%s_%09d.%s", id, upi, i.Stream(), seq, n, offset, ext)
+	return filename(i.UPI(), "IMG", id, i.Stream(), seq, n, offset, ext)
 }
 
 func (i *Image) Timestamp() time.Time {
@@ -0,0 +1,131 @@
+package hrdp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/busoc/panda"
+)
+
+// Slot is the time covered by each file of an archive.
+const Slot = panda.ArchiveSlot
+
+// File gives the path of the file of the archive d holding the records
+// received at t: YYYY/DDD/HH/rt_MM_MM.dat.
+func File(d string, t time.Time) string {
+	return panda.ArchiveFile(d, t)
+}
+
+// Record gives the bytes of p as stored in the archive files: the length of the
+// record (little endian) followed, for TM, by the HRDP header (tag, coarse time
+// and fine time of t in 1/256s) and then by the packet itself.
+func Record(p panda.Packet, t time.Time) ([]byte, error) {
+	bs, err := p.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	switch p.(type) {
+	case panda.Parameter:
+		binary.Write(&buf, binary.LittleEndian, uint32(len(bs)))
+	case panda.Telemetry:
+		binary.Write(&buf, binary.LittleEndian, uint32(len(bs)+panda.RecordHeaderTM))
+		binary.Write(&buf, binary.BigEndian, uint8(panda.TagTM))
+		binary.Write(&buf, binary.BigEndian, uint32(t.Unix()))
+		binary.Write(&buf, binary.BigEndian, uint8(t.Nanosecond()/(int(time.Second)>>8)))
+	default:
+		return nil, fmt.Errorf("unsupported: %T", p)
+	}
+	buf.Write(bs)
+	return buf.Bytes(), nil
+}
+
+// Archive appends packets to the files of an archive, switching to the next
+// file each time a new slot of 5 minutes starts.
+type Archive struct {
+	datadir string
+
+	slot   time.Time
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func Create(d string) (*Archive, error) {
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return nil, err
+	}
+	return &Archive{datadir: d}, nil
+}
+
+// Append writes p to the file of the current slot.
+func (a *Archive) Append(p panda.Packet) error {
+	return a.AppendAt(p, time.Now())
+}
+
+// AppendAt writes p to the file of the slot of t, t being also the reception
+// time written in the header of TM records.
+func (a *Archive) AppendAt(p panda.Packet, t time.Time) error {
+	t = t.UTC()
+	bs, err := Record(p, t)
+	if err != nil {
+		return err
+	}
+	if s := t.Truncate(Slot); a.file == nil || !s.Equal(a.slot) {
+		if err := a.rotate(s); err != nil {
+			return err
+		}
+	}
+	_, err = a.writer.Write(bs)
+	return err
+}
+
+func (a *Archive) Flush() error {
+	if a.writer == nil {
+		return nil
+	}
+	return a.writer.Flush()
+}
+
+func (a *Archive) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	err := a.writer.Flush()
+	if e := a.file.Close(); err == nil {
+		err = e
+	}
+	a.file, a.writer = nil, nil
+	return err
+}
+
+func (a *Archive) rotate(s time.Time) error {
+	if err := a.Close(); err != nil {
+		return err
+	}
+	p := File(a.datadir, s)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	a.slot, a.file, a.writer = s, f, bufio.NewWriter(f)
+	return nil
+}
+
+// NewReader reads the records of kind (tm or pp) of the files of the archive d
+// covering [start, end), their envelope being stripped. A zero end stands for
+// now.
+func NewReader(kind, d string, start, end time.Time, opts ...panda.WalkOption) (io.Reader, error) {
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return panda.WalkBetween(kind, d, start, end, opts...)
+}
@@ -0,0 +1,152 @@
+package hrdp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/busoc/panda"
+)
+
+func TestRecordLayout(t *testing.T) {
+	var (
+		datadir = t.TempDir()
+		start   = time.Date(2018, 1, 12, 10, 23, 0, 0, time.UTC)
+		times   = []time.Time{
+			start,
+			start.Add(time.Minute),
+			start.Add(Slot),
+			start.Add(Slot + 90*time.Second),
+		}
+	)
+	for i, w := range times {
+		bs, err := Record(testPacket(i), w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := File(datadir, w)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.Write(bs)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := File(datadir, start); got != filepath.Join(datadir, "2018", "012", "10", "rt_20_24.dat") {
+		t.Errorf("unexpected file %s", got)
+	}
+	if File(datadir, times[1]) != File(datadir, times[0]) || File(datadir, times[2]) == File(datadir, times[1]) {
+		t.Errorf("records not grouped by slot of %s", Slot)
+	}
+
+	data := []struct {
+		Start time.Time
+		End   time.Time
+		Want  []int
+	}{
+		{start, start.Add(2 * Slot), []int{0, 1, 2, 3}},
+		{start.Add(Slot), start.Add(2 * Slot), []int{2, 3}},
+		{start.Add(2 * Slot), start.Add(3 * Slot), nil},
+	}
+	for _, d := range data {
+		checkSequences(t, datadir, d.Start, d.End, d.Want)
+	}
+}
+
+func TestArchiveRotate(t *testing.T) {
+	var (
+		datadir = filepath.Join(t.TempDir(), "archive")
+		start   = time.Date(2018, 1, 12, 10, 23, 0, 0, time.UTC)
+	)
+	a, err := Create(datadir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := []time.Time{
+		start,
+		start.Add(time.Minute),
+		start.Add(Slot),
+		start.Add(2*Slot + time.Second),
+	}
+	for i, w := range times {
+		if err := a.AppendAt(testPacket(i), w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the file of the current slot is kept open and only completed by Flush.
+	checkSequences(t, datadir, start, start.Add(3*Slot), []int{0, 1, 2})
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	checkSequences(t, datadir, start, start.Add(3*Slot), []int{0, 1, 2, 3})
+
+	// the file of a slot already written is appended to after Close.
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AppendAt(testPacket(4), start.Add(90*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkSequences(t, datadir, start.Truncate(Slot), start.Truncate(Slot).Add(Slot), []int{0, 1, 4})
+
+	for i, w := range times {
+		if _, err := os.Stat(File(datadir, w)); err != nil {
+			t.Errorf("record %d: %s", i, err)
+		}
+	}
+}
+
+func testPacket(seq int) panda.Telemetry {
+	p := panda.Telemetry{
+		CCSDSHeader: panda.CCSDSHeader{
+			Pid:     1<<12 | 1<<11 | 0x0386,
+			Segment: 0xC000 | uint16(seq),
+		},
+		ESAHeader: panda.ESAHeader{Coarse: uint32(1200000000 + seq)},
+		Data:      []byte{1, 2, 3, 4},
+	}
+	p.Length = uint16(panda.ESALength + len(p.Data) - 1)
+	return p
+}
+
+// checkSequences checks the sequence counters of the packets read from the
+// files of datadir covering [start, end).
+func checkSequences(t *testing.T, datadir string, start, end time.Time, want []int) {
+	t.Helper()
+	r, err := NewReader("tm", datadir, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := panda.NewReader(r, panda.DecodeTM())
+	var got []int
+	for {
+		p, err := rs.ReadPacket()
+		if err == panda.ErrDone {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p.(panda.Telemetry).Sequence())
+	}
+	if len(got) != len(want) {
+		t.Errorf("%s - %s: want packets %v, got %v", start, end, want, got)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("%s - %s: want packets %v, got %v", start, end, want, got)
+			return
+		}
+	}
+}
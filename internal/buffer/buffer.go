@@ -21,14 +21,77 @@ func NewBuffer(bs []byte) *Buffer {
 }
 
 func (b *Buffer) Discard(n int64) error {
+	if n < 0 || n > b.inner.Size() {
+		return ErrInvalidPosition
+	}
 	bs := make([]byte, b.inner.Size()-n)
-	if _, err := b.inner.ReadAt(bs, n); err != nil {
+	if _, err := b.inner.ReadAt(bs, n); err != nil && len(bs) > 0 {
 		return err
 	}
 	b.inner.Reset(bs)
+	b.index = 0
+	return nil
+}
+
+func (b *Buffer) SeekBit(pos int) error {
+	if pos < 0 || int64(pos) > b.inner.Size()*8 {
+		return ErrInvalidPosition
+	}
+	b.index = int64(pos)
 	return nil
 }
 
+func (b *Buffer) Remaining() int {
+	return int(b.inner.Size()*8 - b.index)
+}
+
+func (b *Buffer) Align() {
+	if r := b.index % 8; r != 0 {
+		b.index += 8 - r
+	}
+}
+
+func (b *Buffer) ReadBits(n int, order binary.ByteOrder) (uint64, error) {
+	v, err := b.Peek(n, order)
+	if err == nil {
+		b.index += int64(n)
+	}
+	return v, err
+}
+
+func (b *Buffer) Peek(n int, order binary.ByteOrder) (uint64, error) {
+	if n <= 0 || n > 64 {
+		return 0, fmt.Errorf("invalid number of bits %d", n)
+	}
+	if n > b.Remaining() {
+		return 0, ErrInvalidPosition
+	}
+	first, offset := b.index/8, int(b.index%8)
+	bs := make([]byte, (offset+n+7)/8)
+	if _, err := b.inner.ReadAt(bs, first); err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i := 0; i < n; i++ {
+		ix := offset + i
+		if order == binary.LittleEndian {
+			v |= uint64(bs[ix/8]>>uint(ix%8)&1) << uint(i)
+		} else {
+			v = v<<1 | uint64(bs[ix/8]>>uint(7-ix%8)&1)
+		}
+	}
+	return v, nil
+}
+
+func (b *Buffer) ReadSignedBits(n int, order binary.ByteOrder) (int64, error) {
+	v, err := b.ReadBits(n, order)
+	if err != nil {
+		return 0, err
+	}
+	shift := uint(64 - n)
+	return int64(v<<shift) >> shift, nil
+}
+
 func (b *Buffer) ReadFloat(pos int, order binary.ByteOrder) (i float32, err error) {
 	ix, _ := index(pos)
 
@@ -0,0 +1,124 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+var patterns = [][]byte{
+	{0x00, 0x00},
+	{0xFF, 0xFF},
+	{0xA5, 0x3C},
+	{0x81, 0x7E},
+	{0x12, 0xF0},
+	{0x0F, 0x80},
+}
+
+// want gives the n bits at offset of bs (at most two bytes) by reading them
+// as a big endian or little endian word.
+func want(bs []byte, offset, n int, order binary.ByteOrder) uint64 {
+	var w, size uint64
+	for i, b := range bs {
+		if order == binary.LittleEndian {
+			w |= uint64(b) << uint(8*i)
+		} else {
+			w = w<<8 | uint64(b)
+		}
+		size += 8
+	}
+	mask := uint64(1)<<uint(n) - 1
+	if order == binary.LittleEndian {
+		return w >> uint(offset) & mask
+	}
+	return w >> (size - uint64(offset+n)) & mask
+}
+
+func TestReadBits(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		for _, p := range patterns {
+			for size := 1; size <= 2; size++ {
+				bs := p[:size]
+				for offset := 0; offset < 8*size; offset++ {
+					for n := 1; offset+n <= 8*size; n++ {
+						b := NewBuffer(bs)
+						if err := b.SeekBit(offset); err != nil {
+							t.Fatalf("seek %d: %s", offset, err)
+						}
+						got, err := b.ReadBits(n, order)
+						if err != nil {
+							t.Errorf("%s %x offset %d width %d: %s", order, bs, offset, n, err)
+							continue
+						}
+						if w := want(bs, offset, n, order); got != w {
+							t.Errorf("%s %x offset %d width %d: want %x, got %x", order, bs, offset, n, w, got)
+						}
+						if r := b.Remaining(); r != 8*size-offset-n {
+							t.Errorf("%s %x offset %d width %d: want %d bits remaining, got %d", order, bs, offset, n, 8*size-offset-n, r)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReadSignedBits(t *testing.T) {
+	for _, p := range patterns {
+		for offset := 0; offset < 16; offset++ {
+			for n := 1; offset+n <= 16; n++ {
+				b := NewBuffer(p)
+				b.SeekBit(offset)
+				got, err := b.ReadSignedBits(n, binary.BigEndian)
+				if err != nil {
+					t.Fatal(err)
+				}
+				w := int64(want(p, offset, n, binary.BigEndian))
+				if w&(1<<uint(n-1)) != 0 {
+					w -= 1 << uint(n)
+				}
+				if got != w {
+					t.Errorf("%x offset %d width %d: want %d, got %d", p, offset, n, w, got)
+				}
+			}
+		}
+	}
+}
+
+func TestReadBitsSequence(t *testing.T) {
+	b := NewBuffer([]byte{0xA5, 0x3C})
+	for i, w := range []uint64{0x5, 0x5, 0x3C} {
+		n := []int{3, 5, 8}[i]
+		got, err := b.ReadBits(n, binary.BigEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != w {
+			t.Errorf("read %d: want %x, got %x", i, w, got)
+		}
+	}
+}
+
+func TestReadBitsOverRead(t *testing.T) {
+	for size := 1; size <= 2; size++ {
+		for offset := 0; offset <= 8*size; offset++ {
+			b := NewBuffer(patterns[2][:size])
+			b.SeekBit(offset)
+			n := 8*size - offset + 1
+			if _, err := b.ReadBits(n, binary.BigEndian); err != ErrInvalidPosition {
+				t.Errorf("size %d offset %d width %d: want %s, got %v", size, offset, n, ErrInvalidPosition, err)
+			}
+			if r := b.Remaining(); r != 8*size-offset {
+				t.Errorf("size %d offset %d: cursor moved on error (%d bits remaining)", size, offset, r)
+			}
+		}
+	}
+	b := NewBuffer([]byte{0xFF})
+	for _, n := range []int{0, -1, 65} {
+		if _, err := b.ReadBits(n, binary.BigEndian); err == nil {
+			t.Errorf("width %d: expected error", n)
+		}
+	}
+	if err := b.SeekBit(9); err != ErrInvalidPosition {
+		t.Errorf("seek past end: want %s, got %v", ErrInvalidPosition, err)
+	}
+}
@@ -0,0 +1,74 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// FuzzBuffer runs the operations encoded in ops (one byte for the operation,
+// one for its argument) on a buffer holding data, checking that the cursor
+// only moves as expected.
+func FuzzBuffer(f *testing.F) {
+	f.Add([]byte{0xA5, 0x3C, 0x81}, []byte{0, 3, 1, 5, 2, 0, 0, 8})
+	f.Add([]byte{0xFF}, []byte{0, 9, 3, 1, 1, 8})
+	f.Add([]byte{}, []byte{0, 1, 2, 0, 4, 0})
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, []byte{4, 2, 0, 64, 5, 3, 1, 13})
+
+	f.Fuzz(func(t *testing.T, data, ops []byte) {
+		var (
+			b    = NewBuffer(data)
+			size = len(data) * 8
+			pos  int
+		)
+		for i := 0; i+1 < len(ops); i += 2 {
+			n := int(ops[i+1])
+			order := binary.ByteOrder(binary.BigEndian)
+			if ops[i]&0x80 != 0 {
+				order = binary.LittleEndian
+			}
+			switch ops[i] & 0x7F % 6 {
+			case 0:
+				v, err := b.Peek(n, order)
+				if err == nil && n < 64 && v>>uint(n) != 0 {
+					t.Fatalf("peek %d bits: %x has too many bits", n, v)
+				}
+				if err == nil && (n <= 0 || n > 64 || n > size-pos) {
+					t.Fatalf("peek %d bits at %d/%d: expected error", n, pos, size)
+				}
+			case 1:
+				w, perr := b.Peek(n, order)
+				v, err := b.ReadBits(n, order)
+				if (err == nil) != (perr == nil) || v != w {
+					t.Fatalf("read %d bits: peek gave %x (%v), read %x (%v)", n, w, perr, v, err)
+				}
+				if err == nil {
+					pos += n
+				}
+			case 2:
+				b.Align()
+				if r := pos % 8; r != 0 {
+					pos += 8 - r
+				}
+			case 3:
+				if err := b.SeekBit(n); err == nil {
+					pos = n
+				} else if n <= size {
+					t.Fatalf("seek %d/%d: %s", n, size, err)
+				}
+			case 4:
+				if err := b.Discard(int64(n)); err == nil {
+					size, pos = size-n*8, 0
+				} else if n*8 <= size {
+					t.Fatalf("discard %d bytes of %d: %s", n, size/8, err)
+				}
+			case 5:
+				if _, err := b.ReadSignedBits(n, order); err == nil {
+					pos += n
+				}
+			}
+			if r := b.Remaining(); r != size-pos {
+				t.Fatalf("op %d: want %d bits remaining, got %d", i/2, size-pos, r)
+			}
+		}
+	})
+}
@@ -1,12 +1,46 @@
 package panda
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
+)
+
+const (
+	FrameTM = 10
+	FramePP = 12
 )
 
 func Listen(p, s string) (io.Reader, error) {
+	return listen(p, s, true)
+}
+
+func ListenRaw(p, s string) (io.Reader, error) {
+	return listen(p, s, false)
+}
+
+// ListenContext is like Listen but closes the socket once ctx is done.
+func ListenContext(ctx context.Context, p, s string) (io.Reader, error) {
+	r, err := Listen(p, s)
+	if err == nil {
+		closeOnDone(ctx, r.(io.Closer))
+	}
+	return r, err
+}
+
+func closeOnDone(ctx context.Context, c io.Closer) {
+	if ctx.Done() == nil {
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+}
+
+func listen(p, s string, strip bool) (io.Reader, error) {
 	a, err := net.ResolveUDPAddr("udp", s)
 	if err != nil {
 		return nil, err
@@ -23,24 +57,50 @@ func Listen(p, s string) (io.Reader, error) {
 	default:
 		return nil, fmt.Errorf("unsupported: %s", p)
 	case "tm":
-		tag, skip = TagTM, 10
+		tag, skip = TagTM, FrameTM
 	case "pp":
-		tag, skip = TagPP, 12
+		tag, skip = TagPP, FramePP
 	}
-	return &conn{c, tag, skip}, nil
+	if !strip {
+		skip = 0
+	}
+	return &conn{Conn: c, tag: tag, skip: skip}, nil
 }
 
 type conn struct {
 	net.Conn
 	tag  byte
 	skip int
+
+	short uint64
 }
 
+// Read gives the content of the next datagram carrying a packet of the kind of
+// c. The other datagrams are skipped, the ones too short to hold a packet being
+// counted.
 func (c *conn) Read(bs []byte) (int, error) {
 	t := make([]byte, len(bs))
-	r, err := c.Conn.Read(t)
-	if r == 0 || t[0] != c.tag {
-		return r, ErrSkip
+	for {
+		r, err := c.Conn.Read(t)
+		if err != nil {
+			return 0, err
+		}
+		if r == 0 || t[0] != c.tag {
+			continue
+		}
+		if r <= c.skip {
+			atomic.AddUint64(&c.short, 1)
+			continue
+		}
+		return copy(bs, t[c.skip:r]), nil
 	}
-	return copy(bs, t[c.skip:r]), err
+}
+
+// Short gives the number of datagrams received too short to hold a packet.
+func (c *conn) Short() uint64 {
+	return atomic.LoadUint64(&c.short)
+}
+
+func (c *conn) Drops() (uint64, error) {
+	return drops(c.Conn)
 }
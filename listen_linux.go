@@ -0,0 +1,62 @@
+//go:build linux
+// +build linux
+
+package panda
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func drops(c net.Conn) (uint64, error) {
+	s, ok := c.(syscall.Conn)
+	if !ok {
+		return 0, fmt.Errorf("drops not available")
+	}
+	rc, err := s.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var inode string
+	rc.Control(func(fd uintptr) {
+		n, e := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+		if e != nil {
+			err = e
+			return
+		}
+		inode = strings.TrimSuffix(strings.TrimPrefix(n, "socket:["), "]")
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		if n, ok := readDrops(p, inode); ok {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("socket %s not found", inode)
+}
+
+func readDrops(p, inode string) (uint64, bool) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fs := strings.Fields(s.Text())
+		if len(fs) < 13 || fs[9] != inode {
+			continue
+		}
+		n, err := strconv.ParseUint(fs[len(fs)-1], 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
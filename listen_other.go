@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package panda
+
+import (
+	"fmt"
+	"net"
+)
+
+func drops(c net.Conn) (uint64, error) {
+	return 0, fmt.Errorf("drops not available")
+}
@@ -0,0 +1,45 @@
+package panda
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenShort(t *testing.T) {
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skip(err)
+	}
+	r := &conn{Conn: c, tag: TagTM, skip: FrameTM}
+	defer r.Close()
+
+	w, err := net.Dial("udp", c.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	want := testTelemetry(7, false)
+	bs, err := Frame(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range [][]byte{{TagPP, 0, 0}, bs[:FrameTM], bs} {
+		if _, err := w.Write(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	p, err := NewReader(r, DecodeTM()).ReadPacket()
+	if err != nil {
+		t.Fatalf("packet after a short datagram not received: %s", err)
+	}
+	if got := p.(Telemetry).Sequence(); got != want.Sequence() {
+		t.Errorf("want packet %d, got %d", want.Sequence(), got)
+	}
+	if n := r.Short(); n != 1 {
+		t.Errorf("want 1 short datagram, got %d", n)
+	}
+}
@@ -1,6 +1,7 @@
 package panda
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -33,3 +34,87 @@ func AdjustAcquisitionTime(s int64) time.Time {
 func AcquisitionTimeFromEpoch(s int64) int64 {
 	return millis * (s + 34)
 }
+
+type Epoch struct {
+	Base  time.Time
+	Pivot time.Time
+}
+
+func (e *Epoch) Time(coarse uint32, fine time.Duration) time.Time {
+	if e == nil {
+		return time.Unix(int64(coarse), 0).Add(fine).UTC()
+	}
+	base := e.Base
+	if base.IsZero() {
+		base = UNIX
+	}
+	t := base.Add(time.Duration(coarse) * time.Second)
+	if !e.Pivot.IsZero() {
+		const period = time.Second << 32
+		for t.Sub(e.Pivot) < -period/2 {
+			t = t.Add(period)
+		}
+		for t.Sub(e.Pivot) >= period/2 {
+			t = t.Add(-period)
+		}
+	}
+	return t.Add(fine).UTC()
+}
+
+type FineTime uint8
+
+const (
+	FineMillis FineTime = iota
+	FineBinary8
+	FineBinary16
+)
+
+func ParseFineTime(s string) (FineTime, error) {
+	switch s {
+	case "", "ms", "millis":
+		return FineMillis, nil
+	case "8", "2^-8":
+		return FineBinary8, nil
+	case "16", "2^-16":
+		return FineBinary16, nil
+	default:
+		return FineMillis, fmt.Errorf("unsupported fine time scale %s", s)
+	}
+}
+
+func (f FineTime) Duration(v uint16) time.Duration {
+	switch f {
+	default:
+		return time.Duration(v) * time.Millisecond
+	case FineBinary8:
+		return time.Duration(v) * time.Second / (1 << 8)
+	case FineBinary16:
+		return time.Duration(v) * time.Second / (1 << 16)
+	}
+}
+
+func (f FineTime) Layout() string {
+	if f == FineMillis {
+		return "2006-01-02T15:04:05.000Z"
+	}
+	return "2006-01-02T15:04:05.000000Z"
+}
+
+func (f *FineTime) Set(s string) error {
+	v, err := ParseFineTime(s)
+	if err == nil {
+		*f = v
+	}
+	return err
+}
+
+func (f FineTime) String() string {
+	switch f {
+	default:
+		return "ms"
+	case FineBinary8:
+		return "2^-8"
+	case FineBinary16:
+		return "2^-16"
+	}
+}
@@ -10,6 +10,7 @@ import (
 const (
 	CCSDSLength = 6
 	ESALength   = 10
+	PUSLength   = 10
 	UMILength   = 21
 )
 
@@ -25,6 +26,15 @@ type Telemetry struct {
 	ESAHeader
 	Data []byte
 	Sum  uint16
+
+	// Corrupted is only set when decoding with WithChecksum(ChecksumFlag).
+	Corrupted bool
+
+	// PUS is only set when decoding with DecodeTMPUS, the ESAHeader being
+	// then left empty.
+	PUS *PUSHeader
+
+	raw []byte
 }
 
 func (t Telemetry) Bytes() ([]byte, error) {
@@ -33,7 +43,11 @@ func (t Telemetry) Bytes() ([]byte, error) {
 
 	b, _ = encodeCCSDS(t.CCSDSHeader)
 	w.Write(b)
-	b, _ = encodeESA(t.ESAHeader)
+	if t.PUS != nil {
+		b, _ = encodePUS(*t.PUS)
+	} else {
+		b, _ = encodeESA(t.ESAHeader)
+	}
 	w.Write(b)
 
 	w.Write(t.Data)
@@ -41,13 +55,36 @@ func (t Telemetry) Bytes() ([]byte, error) {
 	return w.Bytes(), nil
 }
 
+func (t Telemetry) Timestamp() time.Time {
+	if t.PUS != nil {
+		return t.PUS.Timestamp()
+	}
+	return t.ESAHeader.Timestamp()
+}
+
 func (t Telemetry) Payload() []byte {
 	return t.Data
 }
 
+// Verify computes the packet error control (CRC-CCITT) of t and compares it
+// with its last two bytes. Packets without checksum are always valid.
+func (t Telemetry) Verify() bool {
+	if !t.ESAHeader.Sum() || len(t.Data) < 2 {
+		return true
+	}
+	bs, err := t.Bytes()
+	if err != nil {
+		return false
+	}
+	n := len(bs) - 2
+	return checksum(bs[:n]) == binary.BigEndian.Uint16(bs[n:])
+}
+
 type Parameter struct {
 	UMIHeader
 	Data []byte
+
+	raw []byte
 }
 
 func (p Parameter) Value() interface{} {
@@ -113,16 +150,31 @@ type ESAHeader struct {
 	Fine    uint8
 	Control uint8
 	Sid     uint32
+
+	Scale FineTime
+	Epoch *Epoch
 }
 
 func (e ESAHeader) PacketType() ESAPacketType {
 	return ESAPacketType(e.Control & 0x0f)
 }
 
+// PacketTime tells how the time of the packet should be interpreted (two most
+// significant bits of the control byte).
+func (e ESAHeader) PacketTime() ESAPacketTime {
+	return ESAPacketTime(e.Control >> 6)
+}
+
+// ZOE reports whether the packet was recorded on board during a zone of
+// exclusion and downlinked later, its time being older than its reception.
+func (e ESAHeader) ZOE() bool {
+	return (e.Control>>4)&0x01 == 1
+}
+
 func (e ESAHeader) Timestamp() time.Time {
-	ns := time.Duration(e.Fine) * time.Millisecond
+	ns := e.Scale.Duration(uint16(e.Fine))
 
-	t := time.Unix(int64(e.Coarse), ns.Nanoseconds()).UTC()
+	t := e.Epoch.Time(e.Coarse, ns)
 	// return t.Add(epoch)
 	return t
 }
@@ -131,6 +183,25 @@ func (e ESAHeader) Sum() bool {
 	return (e.Control>>5)&0x01 == 1
 }
 
+// PUSHeader is the data field header of the PUS (ECSS-E-70-41) telemetry
+// packets: version, service type and subtype, destination id and CUC time
+// (4 bytes coarse time, 2 bytes fine time).
+type PUSHeader struct {
+	Version     uint8
+	Service     uint8
+	Subtype     uint8
+	Destination uint8
+	Coarse      uint32
+	Fine        uint16
+
+	Scale FineTime
+	Epoch *Epoch
+}
+
+func (p PUSHeader) Timestamp() time.Time {
+	return p.Epoch.Time(p.Coarse, p.Scale.Duration(p.Fine))
+}
+
 type UMIHeader struct {
 	State  UMIPacketState
 	Orbit  [4]byte
@@ -140,12 +211,15 @@ type UMIHeader struct {
 	Coarse uint32
 	Fine   uint8
 	Length uint16
+
+	Scale FineTime
+	Epoch *Epoch
 }
 
 func (u UMIHeader) Timestamp() time.Time {
-	ns := time.Duration(u.Fine) * time.Millisecond
+	ns := u.Scale.Duration(uint16(u.Fine))
 
-	t := time.Unix(int64(u.Coarse), ns.Nanoseconds()).UTC()
+	t := u.Epoch.Time(u.Coarse, ns)
 	// return t.Add(epoch)
 	return t
 }
@@ -192,6 +266,36 @@ func encodeESA(e ESAHeader) ([]byte, error) {
 	return w.Bytes(), nil
 }
 
+func decodePUS(bs []byte) (PUSHeader, error) {
+	var (
+		p PUSHeader
+		v uint8
+	)
+
+	r := bytes.NewReader(bs)
+	binary.Read(r, binary.BigEndian, &v)
+	binary.Read(r, binary.BigEndian, &p.Service)
+	binary.Read(r, binary.BigEndian, &p.Subtype)
+	binary.Read(r, binary.BigEndian, &p.Destination)
+	binary.Read(r, binary.BigEndian, &p.Coarse)
+	binary.Read(r, binary.BigEndian, &p.Fine)
+	p.Version = (v >> 4) & 0x07
+
+	return p, nil
+}
+
+func encodePUS(p PUSHeader) ([]byte, error) {
+	w := new(bytes.Buffer)
+	binary.Write(w, binary.BigEndian, (p.Version&0x07)<<4)
+	binary.Write(w, binary.BigEndian, p.Service)
+	binary.Write(w, binary.BigEndian, p.Subtype)
+	binary.Write(w, binary.BigEndian, p.Destination)
+	binary.Write(w, binary.BigEndian, p.Coarse)
+	binary.Write(w, binary.BigEndian, p.Fine)
+
+	return w.Bytes(), nil
+}
+
 func decodeUMI(bs []byte) (UMIHeader, error) {
 	var u UMIHeader
 
@@ -222,3 +326,18 @@ func encodeUMI(u UMIHeader) ([]byte, error) {
 
 	return w.Bytes(), nil
 }
+
+func checksum(bs []byte) uint16 {
+	var sum uint16 = 0xFFFF
+	for _, b := range bs {
+		sum ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if sum&0x8000 != 0 {
+				sum = sum<<1 ^ 0x1021
+			} else {
+				sum <<= 1
+			}
+		}
+	}
+	return sum
+}
@@ -0,0 +1,250 @@
+package panda
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// fileBuffer is the number of records scanned ahead in each file read
+// concurrently.
+const fileBuffer = 1024
+
+// mergeFiles is the number of files merged at once when WithWorkers is not
+// given.
+const mergeFiles = 4
+
+// WithWorkers makes the walker scan up to n files concurrently. Records are
+// still given file by file, in the order the files are walked.
+func WithWorkers(n int) WalkOption {
+	return func(w *walker) {
+		if n > 0 {
+			w.workers = n
+		}
+	}
+}
+
+// WithMerge makes the walker give the records of the files ordered by the time
+// of their packet (TM and PP only), assuming that records are ordered within
+// each file and that files come ordered by the time of their first record, as
+// the files of an archive. Records are merged over a window of as many files
+// as given to WithWorkers (4 by default): the records of a file overlapping
+// more files than that may come out of order.
+//
+// A record is given as soon as the first record of a later file comes after
+// it, so that merging the files of Watch gives the records of a file once the
+// next one is completed.
+func WithMerge() WalkOption {
+	return func(w *walker) {
+		w.merge = true
+	}
+}
+
+type record struct {
+	file string
+	at   int64
+	data []byte
+	err  error
+}
+
+// start runs the stage giving the records of the files to read: a channel per
+// file when scanning them concurrently or a single channel with the records
+// of every file when merging them.
+func (w *walker) start(p string, strip bool) error {
+	var key func([]byte) uint64
+	if w.merge {
+		// offset of the packet in the records given by the walker.
+		var offset int
+		if !strip {
+			offset, _ = recordSkip(p)
+		}
+		switch p {
+		case "tm":
+			offset += CCSDSLength
+		case "pp":
+			// coarse and fine time follow state, orbit, code, type and unit.
+			offset += 14
+		default:
+			return fmt.Errorf("%s records can not be merged", p)
+		}
+		key = func(bs []byte) uint64 {
+			if len(bs) < offset+5 {
+				return 0
+			}
+			return uint64(binary.BigEndian.Uint32(bs[offset:]))<<8 | uint64(bs[offset+4])
+		}
+	}
+	if w.workers <= 1 && key == nil {
+		return nil
+	}
+	size := w.workers - 1
+	if size < 0 {
+		size = 0
+	}
+	files := make(chan chan record, size)
+	go func() {
+		defer close(files)
+		if key != nil {
+			w.mergeAll(files, key)
+			return
+		}
+		for rc := range w.next {
+			q := make(chan record, fileBuffer)
+			select {
+			case files <- q:
+				go w.scanFile(rc, q)
+			case <-w.done:
+				rc.Close()
+				return
+			}
+		}
+	}()
+	w.files = files
+	return nil
+}
+
+func (w *walker) scanFile(rc io.ReadCloser, q chan<- record) {
+	defer func() {
+		rc.Close()
+		close(q)
+	}()
+	var (
+		file  string
+		notes Annotations
+		at    int64
+	)
+	if f, ok := rc.(interface{ Name() string }); ok {
+		file = f.Name()
+		if w.flags != 0 {
+			notes, _ = LoadAnnotations(file)
+		}
+	}
+	sc := newScanner(rc, w.skip, w.limit, &at)
+	for sc.Scan() {
+		if notes[at]&w.flags != 0 {
+			continue
+		}
+		select {
+		case q <- record{file: file, at: at, data: sc.Bytes()}:
+		case <-w.done:
+			return
+		}
+	}
+	if err := sc.Err(); err != nil {
+		select {
+		case q <- record{file: file, at: at, err: err}:
+		case <-w.done:
+		}
+	}
+}
+
+func (w *walker) mergeAll(files chan<- chan record, key func([]byte) uint64) {
+	q := make(chan record)
+	select {
+	case files <- q:
+	case <-w.done:
+		return
+	}
+	defer close(q)
+
+	size := w.workers
+	if size <= 1 {
+		size = mergeFiles
+	}
+	var (
+		hs    heads
+		seq   int
+		bound uint64
+		more  = true
+	)
+	for {
+		// the records of the files still to come can not be before bound,
+		// the first record of the last file opened.
+		for hs.Len() > 0 && (!more || hs.Len() >= size || hs[0].key <= bound) {
+			h := &hs[0]
+			select {
+			case q <- h.record:
+			case <-w.done:
+				return
+			}
+			if r, ok := <-h.queue; ok {
+				h.record, h.key = r, key(r.data)
+				heap.Fix(&hs, 0)
+			} else {
+				heap.Pop(&hs)
+			}
+		}
+		if !more {
+			return
+		}
+		select {
+		case rc, ok := <-w.next:
+			if !ok {
+				more = false
+				continue
+			}
+			fq := make(chan record, fileBuffer)
+			go w.scanFile(rc, fq)
+			if r, ok := <-fq; ok {
+				bound = key(r.data)
+				heap.Push(&hs, head{record: r, key: bound, seq: seq, queue: fq})
+				seq++
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+type head struct {
+	record
+	key   uint64
+	seq   int
+	queue <-chan record
+}
+
+type heads []head
+
+func (h heads) Len() int { return len(h) }
+
+func (h heads) Less(i, j int) bool {
+	if h[i].key == h[j].key {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].key < h[j].key
+}
+
+func (h heads) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *heads) Push(x interface{}) { *h = append(*h, x.(head)) }
+
+func (h *heads) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// readFiles gives the next record coming from the files stage.
+func (w *walker) readFiles() ([]byte, error) {
+	for {
+		if w.curr == nil {
+			q, ok := <-w.files
+			if !ok {
+				return nil, ErrDone
+			}
+			w.curr = q
+		}
+		r, ok := <-w.curr
+		if !ok {
+			w.curr = nil
+			continue
+		}
+		w.file, w.at = r.file, r.at
+		if r.err != nil {
+			return nil, r.err
+		}
+		return r.data, nil
+	}
+}
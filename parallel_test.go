@@ -0,0 +1,117 @@
+package panda
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTimed writes an archive file of TM records whose packets have the given
+// coarse times, their sequence counter being set to the same value.
+func writeTimed(t *testing.T, file string, coarse ...int) {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, c := range coarse {
+		p := testSequence(0x386, c)
+		p.ESAHeader.Coarse = uint32(c)
+		p.Data = []byte{1, 2, 3, 4}
+		p.Length = uint16(ESALength + len(p.Data) - 1)
+		bs, _ := p.Bytes()
+		binary.Write(&buf, binary.LittleEndian, uint32(len(bs)+RecordHeaderTM))
+		buf.Write([]byte{TagTM, 0, 0, 0, 0, 0})
+		buf.Write(bs)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkMerge(t *testing.T) {
+	d := t.TempDir()
+	writeTimed(t, filepath.Join(d, "rt_00_04.dat"), 0, 2, 4)
+	writeTimed(t, filepath.Join(d, "rt_05_09.dat"), 1, 3, 5)
+	writeTimed(t, filepath.Join(d, "rt_10_14.dat"))
+	writeTimed(t, filepath.Join(d, "rt_15_19.dat"), 6, 8)
+	writeTimed(t, filepath.Join(d, "rt_20_24.dat"), 7, 9)
+
+	for _, n := range []int{0, 2, 8} {
+		r, err := Walk("tm", d, WithMerge(), WithWorkers(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs := NewReader(r, DecodeTM())
+		var want int
+		for ; ; want++ {
+			p, err := rs.ReadPacket()
+			if err == ErrDone {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.(Telemetry).Sequence(); got != want {
+				t.Fatalf("window %d: want packet %d, got %d", n, want, got)
+			}
+		}
+		if want != 10 {
+			t.Errorf("window %d: want 10 packets, got %d", n, want)
+		}
+	}
+}
+
+func TestWatchMerge(t *testing.T) {
+	defer func(d time.Duration) { WatchIdle = d }(WatchIdle)
+	WatchIdle = 200 * time.Millisecond
+
+	root := t.TempDir()
+	r, err := Watch("tm", root, WithMerge())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.(interface{ Close() error }).Close()
+
+	queue := make(chan int)
+	go func() {
+		defer close(queue)
+		rs := NewReader(r, DecodeTM())
+		for {
+			p, err := rs.Read()
+			if err != nil {
+				return
+			}
+			queue <- p.(Telemetry).Sequence()
+		}
+	}()
+	// the records after the first one of the last file are held until a
+	// later file comes.
+	writeTimed(t, filepath.Join(root, "rt_00_04.dat"), 0, 2)
+	time.Sleep(50 * time.Millisecond)
+	writeTimed(t, filepath.Join(root, "rt_05_09.dat"), 1, 3)
+	expect(t, queue, 0, 1)
+	writeTimed(t, filepath.Join(root, "rt_10_14.dat"), 4, 5)
+	expect(t, queue, 2, 3, 4)
+}
+
+func expect(t *testing.T, queue <-chan int, seqs ...int) {
+	t.Helper()
+	for _, want := range seqs {
+		select {
+		case got, ok := <-queue:
+			if !ok {
+				t.Fatalf("watcher stopped before packet %d", want)
+			}
+			if got != want {
+				t.Fatalf("want packet %d, got %d", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("packet %d not received", want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package panda
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Encoder gives the bytes of a packet as Decoder expects them, fixing the
+// fields derived from its content (length, checksum) on the way.
+type Encoder interface {
+	Encode(Packet) ([]byte, error)
+}
+
+type EncoderFunc func(Packet) ([]byte, error)
+
+func (e EncoderFunc) Encode(p Packet) ([]byte, error) {
+	return e(p)
+}
+
+// EncodeTM encodes Telemetry packets, setting the length of their CCSDS
+// header and, when the ESA header says so, their checksum (last two bytes of
+// Data).
+//
+// Packets with a PUS header are written as is: nothing in that header tells
+// whether the packet ends with a packet error control, so any PEC has to be
+// part of Data already.
+func EncodeTM() Encoder {
+	f := func(p Packet) ([]byte, error) {
+		var t Telemetry
+		switch p := p.(type) {
+		case Telemetry:
+			t = p
+		case *Telemetry:
+			t = *p
+		default:
+			return nil, fmt.Errorf("unsupported: %T", p)
+		}
+		size := ESALength
+		if t.PUS != nil {
+			size = PUSLength
+		}
+		t.CCSDSHeader.Length = uint16(size + len(t.Data) - 1)
+		bs, err := t.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		if t.PUS == nil && t.ESAHeader.Sum() && len(t.Data) >= 2 {
+			n := len(bs) - 2
+			binary.BigEndian.PutUint16(bs[n:], checksum(bs[:n]))
+		}
+		return bs, nil
+	}
+	return EncoderFunc(f)
+}
+
+// EncodePP encodes Parameter packets, setting the length of their UMI header.
+func EncodePP() Encoder {
+	f := func(p Packet) ([]byte, error) {
+		var x Parameter
+		switch p := p.(type) {
+		case Parameter:
+			x = p
+		case *Parameter:
+			x = *p
+		default:
+			return nil, fmt.Errorf("unsupported: %T", p)
+		}
+		x.UMIHeader.Length = uint16(len(x.Data))
+		return x.Bytes()
+	}
+	return EncoderFunc(f)
+}
+
+// EncodeHR encodes the Image and Table packets of the given VMU protocol
+// version, setting their checksum (sum of all their bytes but the last four).
+func EncodeHR(v int) (Encoder, error) {
+	if v != VMUProtocol1 && v != VMUProtocol2 {
+		return nil, fmt.Errorf("unsupported vmu protocol version: %d", v)
+	}
+	f := func(p Packet) ([]byte, error) {
+		var ok bool
+		switch p := p.(type) {
+		case *Image:
+			switch p.IDH.(type) {
+			case *IDHv1:
+				ok = v == VMUProtocol1
+			case *IDHv2:
+				ok = v == VMUProtocol2
+			}
+		case *Table:
+			switch p.SDH.(type) {
+			case *SDHv1:
+				ok = v == VMUProtocol1
+			case *SDHv2:
+				ok = v == VMUProtocol2
+			}
+		default:
+			return nil, fmt.Errorf("unsupported: %T", p)
+		}
+		if !ok {
+			return nil, fmt.Errorf("packet header does not match vmu protocol version %d", v)
+		}
+		bs, err := p.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		n := len(bs) - 4
+		var sum uint32
+		for _, b := range bs[:n] {
+			sum += uint32(b)
+		}
+		binary.LittleEndian.PutUint32(bs[n:], sum)
+		return bs, nil
+	}
+	return EncoderFunc(f), nil
+}
@@ -0,0 +1,202 @@
+package panda
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testPayload gives n bytes of data starting with seed.
+func testPayload(seed, n int) []byte {
+	bs := make([]byte, n)
+	for i := range bs {
+		bs[i] = byte(seed + i)
+	}
+	return bs
+}
+
+// testTelemetry gives a TM packet whose length and checksum are already the
+// ones set by EncodeTM. It has a PUS header when pus is true, an ESA header
+// with a checksum otherwise.
+func testTelemetry(seq int, pus bool) Telemetry {
+	t := Telemetry{
+		CCSDSHeader: CCSDSHeader{
+			Pid:     1<<12 | 1<<11 | 0x0386,
+			Segment: 0xC000 | uint16(seq&0x3FFF),
+		},
+		Data: testPayload(seq, 64),
+	}
+	if pus {
+		t.PUS = &PUSHeader{Version: 1, Service: 3, Subtype: 25, Coarse: uint32(1200000000 + seq), Fine: 0x8000}
+		t.CCSDSHeader.Length = uint16(PUSLength + len(t.Data) - 1)
+		return t
+	}
+	t.ESAHeader = ESAHeader{Coarse: uint32(1200000000 + seq), Fine: 128, Control: 1 << 5, Sid: 0x1234}
+	t.CCSDSHeader.Length = uint16(ESALength + len(t.Data) - 1)
+
+	bs, _ := t.Bytes()
+	n := len(t.Data) - 2
+	t.Sum = checksum(bs[:len(bs)-2])
+	binary.BigEndian.PutUint16(t.Data[n:], t.Sum)
+	return t
+}
+
+func testParameter(seq int) Parameter {
+	p := Parameter{
+		UMIHeader: UMIHeader{
+			State:  NewValue,
+			Orbit:  [4]byte{0, 0, 0x12, 0x34},
+			Code:   [6]byte{0, 0, 0x01, 0x02, 0x03, byte(seq)},
+			Type:   Int32,
+			Unit:   1,
+			Coarse: uint32(1200000000 + seq),
+			Fine:   64,
+		},
+		Data: testPayload(seq, 4),
+	}
+	p.Length = uint16(len(p.Data))
+	return p
+}
+
+// testHR gives an image (vic1) or a science table (lrsd) of the given VMU
+// protocol version, with its checksum already set.
+func testHR(seq, v int, image bool) HRPacket {
+	var (
+		h = &VMUHeader{
+			Channel:  Science,
+			Source:   LRSD,
+			Sequence: uint32(seq),
+			Coarse:   uint32(1200000000 + seq),
+			Fine:     500,
+		}
+		info [32]byte
+		p    HRPacket
+	)
+	copy(info[:], "PANDA_TEST")
+	acq := time.Duration(1200000000+seq) * time.Second
+	if image {
+		h.Channel = Video1
+		i := &Image{VMUHeader: h, Data: testPayload(seq, 256)}
+		if v == VMUProtocol1 {
+			i.IDH = &IDHv1{Sequence: uint32(seq), Coarse: h.Coarse, Fine: 250, Type: 2, Rate: 25, Pixels: 16<<16 | 16, Info: info}
+		} else {
+			i.IDH = &IDHv2{Sequence: uint16(seq), Originator: 1, Acquisition: acq, Id: 0x3A, Type: 2, Pixels: 16<<16 | 16, Info: info}
+		}
+		p = i
+	} else {
+		t := &Table{VMUHeader: h, Data: testPayload(seq, 128)}
+		if v == VMUProtocol1 {
+			t.SDH = &SDHv1{Sequence: uint32(seq)}
+		} else {
+			t.SDH = &SDHv2{Properties: 0x11, Sequence: uint16(seq), Originator: 1, Acquisition: acq, Id: LRSD, Info: info}
+		}
+		p = t
+	}
+	bs, _ := p.Bytes()
+	var sum uint32
+	for _, b := range bs[:len(bs)-4] {
+		sum += uint32(b)
+	}
+	switch p := p.(type) {
+	case *Image:
+		p.Sum = sum
+	case *Table:
+		p.Sum = sum
+	}
+	return p
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	hr1, err := EncodeHR(VMUProtocol1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hr2, err := EncodeHR(VMUProtocol2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr1, _ := DecodeHR(VMUProtocol1)
+	dr2, _ := DecodeHR(VMUProtocol2)
+
+	data := []struct {
+		Name    string
+		Packet  Packet
+		Encoder Encoder
+		Decoder Decoder
+	}{
+		{"tm/esa", testTelemetry(1, false), EncodeTM(), DecodeTM()},
+		{"tm/pus", testTelemetry(2, true), EncodeTM(), DecodeTMPUS()},
+		{"pp", testParameter(3), EncodePP(), DecodePP()},
+		{"hr/v1/image", testHR(4, VMUProtocol1, true), hr1, dr1},
+		{"hr/v1/table", testHR(5, VMUProtocol1, false), hr1, dr1},
+		{"hr/v2/image", testHR(6, VMUProtocol2, true), hr2, dr2},
+		{"hr/v2/table", testHR(7, VMUProtocol2, false), hr2, dr2},
+	}
+	for _, d := range data {
+		bs, err := d.Encoder.Encode(d.Packet)
+		if err != nil {
+			t.Errorf("%s: encode: %s", d.Name, err)
+			continue
+		}
+		_, p, err := d.Decoder.Decode(bs)
+		if err != nil {
+			t.Errorf("%s: decode: %s", d.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(p, d.Packet) {
+			t.Errorf("%s: packets mismatched\nwant: %+v\ngot:  %+v", d.Name, d.Packet, p)
+		}
+		if hr, ok := p.(HRPacket); ok && !Valid(hr) {
+			t.Errorf("%s: invalid checksum", d.Name)
+		}
+	}
+}
+
+func TestEncodeDerived(t *testing.T) {
+	tm := testTelemetry(1, false)
+	tm.Length, tm.Data[len(tm.Data)-1] = 0, 0
+	bs, err := EncodeTM().Encode(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, p, err := DecodeTM(WithChecksum(ChecksumReject)).Decode(bs)
+	if err != nil {
+		t.Fatalf("tm: %s", err)
+	}
+	if got := p.(Telemetry); got.Length != uint16(ESALength+len(tm.Data)-1) {
+		t.Errorf("tm: want length %d, got %d", ESALength+len(tm.Data)-1, got.Length)
+	}
+
+	pp := testParameter(1)
+	pp.Length = 0
+	if bs, err = EncodePP().Encode(pp); err != nil {
+		t.Fatal(err)
+	}
+	if _, p, err = DecodePP().Decode(bs); err != nil {
+		t.Fatalf("pp: %s", err)
+	}
+	if got := p.(Parameter); !reflect.DeepEqual(got.Data, pp.Data) {
+		t.Errorf("pp: want data %x, got %x", pp.Data, got.Data)
+	}
+
+	for _, v := range []int{VMUProtocol1, VMUProtocol2} {
+		hr := testHR(1, v, true)
+		hr.(*Image).Sum = 0
+		e, _ := EncodeHR(v)
+		if bs, err = e.Encode(hr); err != nil {
+			t.Fatal(err)
+		}
+		d, _ := DecodeHR(v)
+		if _, p, err = d.Decode(bs); err != nil {
+			t.Fatalf("hr v%d: %s", v, err)
+		}
+		if !Valid(p.(HRPacket)) {
+			t.Errorf("hr v%d: checksum not set", v)
+		}
+		other, _ := EncodeHR(VMUProtocol1 + VMUProtocol2 - v)
+		if _, err := other.Encode(hr); err == nil {
+			t.Errorf("hr v%d: header of the other version accepted", v)
+		}
+	}
+}
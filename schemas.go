@@ -3,6 +3,7 @@ package panda
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"sort"
 	"time"
 
@@ -36,33 +37,63 @@ func (i Item) Extract(b *buffer.Buffer) (Item, error) {
 	default:
 		return i, fmt.Errorf("unsupported endianess %s", i.Endianess)
 	}
-	var err error
-
+	var width int
+	switch i.Type {
+	case "bool", "uchar", "char":
+		width = 8
+	case "ushort", "short", "":
+		width = 16
+	case "ulong", "long", "float":
+		width = 32
+	default:
+		return i, fmt.Errorf("unsupported type %s", i.Type)
+	}
+	n := i.Length
+	if n == 0 || i.Type == "float" {
+		n = width
+	}
+	if n > width {
+		return i, fmt.Errorf("unsupported length %d for %s", n, i.Type)
+	}
+	if err := b.SeekBit(i.Offset); err != nil {
+		return i, err
+	}
 	v := i
 	switch i.Type {
-	case "bool":
-		b, err := b.ReadUint8(v.Offset, v.Length, e)
-		if b == 1 && err == nil {
-			v.Raw = true
+	case "char", "short", "long":
+		r, err := b.ReadSignedBits(n, e)
+		if err != nil {
+			return i, err
+		}
+		switch width {
+		case 8:
+			v.Raw = int8(r)
+		case 16:
+			v.Raw = int16(r)
+		default:
+			v.Raw = int32(r)
 		}
-	case "uchar":
-		v.Raw, err = b.ReadUint8(v.Offset, v.Length, e)
-	case "ushort", "":
-		v.Raw, err = b.ReadUint16(v.Offset, v.Length, e)
-	case "ulong":
-		v.Raw, err = b.ReadUint32(v.Offset, v.Length, e)
-	case "char":
-		v.Raw, err = b.ReadInt8(v.Offset, v.Length, e)
-	case "short":
-		v.Raw, err = b.ReadInt16(v.Offset, v.Length, e)
-	case "long":
-		v.Raw, err = b.ReadInt32(v.Offset, v.Length, e)
-	case "float":
-		v.Raw, err = b.ReadFloat(v.Offset, e)
 	default:
-		return i, fmt.Errorf("unsupported type %s", i.Type)
+		r, err := b.ReadBits(n, e)
+		if err != nil {
+			return i, err
+		}
+		switch i.Type {
+		case "bool":
+			if r == 1 {
+				v.Raw = true
+			}
+		case "uchar":
+			v.Raw = uint8(r)
+		case "float":
+			v.Raw = math.Float32frombits(uint32(r))
+		case "ulong":
+			v.Raw = uint32(r)
+		default:
+			v.Raw = uint16(r)
+		}
 	}
-	return v, err
+	return v, nil
 }
 
 type Schema struct {
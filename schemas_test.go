@@ -0,0 +1,43 @@
+package panda
+
+import (
+	"testing"
+
+	"github.com/busoc/panda/internal/buffer"
+)
+
+func TestItemExtract(t *testing.T) {
+	payload := []byte{0xA5, 0x3C, 0x81, 0x7E, 0x00, 0x00, 0x80, 0x3F}
+	data := []struct {
+		Item
+		Want interface{}
+	}{
+		{Item: Item{Type: "bool", Offset: 0, Length: 1}, Want: true},
+		{Item: Item{Type: "bool", Offset: 1, Length: 1}, Want: nil},
+		{Item: Item{Type: "uchar", Offset: 4}, Want: uint8(0x53)},
+		{Item: Item{Type: "uchar", Offset: 4, Length: 4, Endianess: "little"}, Want: uint8(0xA)},
+		{Item: Item{Type: "ushort", Offset: 0}, Want: uint16(0xA53C)},
+		{Item: Item{Type: "ushort", Offset: 0, Endianess: "le"}, Want: uint16(0x3CA5)},
+		{Item: Item{Type: "ushort", Offset: 6, Length: 5}, Want: uint16(0x09)},
+		{Item: Item{Type: "ushort", Offset: 6, Length: 5, Endianess: "little"}, Want: uint16(0x12)},
+		{Item: Item{Type: "short", Offset: 8, Length: 4}, Want: int16(3)},
+		{Item: Item{Type: "short", Offset: 16, Length: 4}, Want: int16(-8)},
+		{Item: Item{Type: "char", Offset: 16}, Want: int8(-127)},
+		{Item: Item{Type: "ulong", Offset: 12, Length: 20}, Want: uint32(0xC817E)},
+		{Item: Item{Type: "long", Offset: 16, Length: 16}, Want: int32(-32386)},
+		{Item: Item{Type: "float", Offset: 32, Endianess: "little"}, Want: float32(1)},
+	}
+	for _, d := range data {
+		i, err := d.Extract(buffer.NewBuffer(payload))
+		if err != nil {
+			t.Errorf("%s at %d (%d bits): %s", d.Type, d.Offset, d.Length, err)
+			continue
+		}
+		if i.Raw != d.Want {
+			t.Errorf("%s at %d (%d bits, %s): want %v (%[5]T), got %v (%[6]T)", d.Type, d.Offset, d.Length, d.Endianess, d.Want, i.Raw)
+		}
+	}
+	if _, err := (Item{Type: "ulong", Offset: 48}).Extract(buffer.NewBuffer(payload)); err == nil {
+		t.Errorf("over-read: expected error")
+	}
+}
@@ -0,0 +1,161 @@
+package panda
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+func Stream(p string, r io.Reader) (io.Reader, error) {
+	return newStream(p, r, true)
+}
+
+func StreamRaw(p string, r io.Reader) (io.Reader, error) {
+	return newStream(p, r, false)
+}
+
+func newStream(p string, r io.Reader, strip bool) (io.Reader, error) {
+	var (
+		tag  byte
+		skip int
+	)
+	switch p {
+	default:
+		return nil, fmt.Errorf("unsupported: %s", p)
+	case "tm":
+		tag, skip = TagTM, FrameTM
+	case "pp":
+		tag, skip = TagPP, FramePP
+	}
+	return &stream{
+		reader: bufio.NewReader(r),
+		tag:    tag,
+		header: make([]byte, skip),
+		strip:  strip,
+	}, nil
+}
+
+type stream struct {
+	reader *bufio.Reader
+	tag    byte
+	header []byte
+	strip  bool
+}
+
+func (s *stream) Read(bs []byte) (int, error) {
+	if _, err := io.ReadFull(s.reader, s.header); err != nil {
+		return 0, err
+	}
+	if s.header[0] != s.tag {
+		return 0, fmt.Errorf("unexpected tag %02x (expected %02x)", s.header[0], s.tag)
+	}
+	var size int
+	switch s.tag {
+	case TagTM:
+		h, err := s.reader.Peek(CCSDSLength)
+		if err != nil {
+			return 0, err
+		}
+		size = CCSDSLength + int(binary.BigEndian.Uint16(h[4:])) + 1
+	case TagPP:
+		h, err := s.reader.Peek(UMILength)
+		if err != nil {
+			return 0, err
+		}
+		size = UMILength + int(binary.BigEndian.Uint16(h[UMILength-2:]))
+	}
+	if s.strip {
+		if len(bs) < size {
+			return 0, ErrTooShort
+		}
+		return io.ReadFull(s.reader, bs[:size])
+	}
+	if len(bs) < len(s.header)+size {
+		return 0, ErrTooShort
+	}
+	n := copy(bs, s.header)
+	c, err := io.ReadFull(s.reader, bs[n:n+size])
+	return n + c, err
+}
+
+type Writer struct {
+	writer io.Writer
+	tag    byte
+	skip   int
+
+	buf bytes.Buffer
+}
+
+func NewWriter(p string, w io.Writer) (*Writer, error) {
+	var (
+		tag  byte
+		skip int
+	)
+	switch p {
+	default:
+		return nil, fmt.Errorf("unsupported: %s", p)
+	case "tm":
+		tag, skip = TagTM, FrameTM
+	case "pp":
+		tag, skip = TagPP, FramePP
+	}
+	return &Writer{writer: w, tag: tag, skip: skip}, nil
+}
+
+func (w *Writer) Write(p Packet) error {
+	defer w.buf.Reset()
+	if err := frame(&w.buf, p, w.tag, w.skip); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(w.buf.Bytes())
+	return err
+}
+
+// Frame gives the bytes of p preceded by the header of the HRD frames sent on
+// the multicast groups: the tag of the packet and the time the frame is
+// written. PP frames also carry the coarse and fine time of the parameter.
+func Frame(p Packet) ([]byte, error) {
+	var (
+		buf  bytes.Buffer
+		tag  byte
+		skip int
+	)
+	switch p.(type) {
+	case Telemetry:
+		tag, skip = TagTM, FrameTM
+	case Parameter:
+		tag, skip = TagPP, FramePP
+	default:
+		return nil, fmt.Errorf("unsupported: %T", p)
+	}
+	if err := frame(&buf, p, tag, skip); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func frame(w *bytes.Buffer, p Packet, tag byte, skip int) error {
+	bs, err := p.Bytes()
+	if err != nil {
+		return err
+	}
+	n := time.Duration(time.Now().UnixNano())
+	s, ms := n/time.Second, n/time.Millisecond
+
+	z := w.Len()
+	binary.Write(w, binary.BigEndian, tag)
+	binary.Write(w, binary.BigEndian, uint32(s))
+	binary.Write(w, binary.BigEndian, uint8(ms)%255)
+	if x, ok := p.(Parameter); ok && tag == TagPP {
+		binary.Write(w, binary.BigEndian, x.Coarse)
+		binary.Write(w, binary.BigEndian, x.Fine)
+	}
+	if n := skip - (w.Len() - z); n > 0 {
+		w.Write(make([]byte, n))
+	}
+	w.Write(bs)
+	return nil
+}
@@ -0,0 +1,84 @@
+package panda
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// the Info field of the HRD headers being UPILen bytes long, the UPIs longer
+// than that are truncated by the VMU. upis gives back the full UPI of the
+// truncated ones.
+var upis struct {
+	sync.RWMutex
+	names map[string]string
+}
+
+// RegisterUPI makes the products whose Info field holds info attributed to
+// upi. When info is empty, the first UPILen bytes of upi are used, that is
+// what the VMU sends for it.
+func RegisterUPI(info, upi string) error {
+	if upi == "" {
+		return fmt.Errorf("empty upi")
+	}
+	if info == "" {
+		info = upi
+	}
+	if len(info) > UPILen {
+		info = info[:UPILen]
+	}
+	upis.Lock()
+	defer upis.Unlock()
+	if upis.names == nil {
+		upis.names = make(map[string]string)
+	}
+	if n, ok := upis.names[info]; ok && n != upi {
+		return fmt.Errorf("%s: upi already registered for %s", upi, n)
+	}
+	upis.names[info] = upi
+	return nil
+}
+
+// LookupUPI gives the UPI of an Info field, the one registered for it if any.
+func LookupUPI(info []byte) string {
+	info = bytes.Trim(info, "\x00")
+	upis.RLock()
+	defer upis.RUnlock()
+	if n, ok := upis.names[string(info)]; ok {
+		return n
+	}
+	return string(info)
+}
+
+// LoadUPI registers the UPIs listed in file, one per line. A line holds the
+// full UPI, optionally preceded by the Info field sent for it and a tab when it
+// is not the first UPILen bytes of the UPI. Empty lines and lines starting with
+// # are ignored.
+func LoadUPI(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var info, upi string
+		if i := strings.Index(line, "\t"); i >= 0 {
+			info, upi = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		} else {
+			upi = line
+		}
+		if err := RegisterUPI(info, upi); err != nil {
+			return fmt.Errorf("%s:%d: %s", file, n, err)
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,42 @@
+package panda
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadUPI(t *testing.T) {
+	const long = "LOAD_UPI_TEST_WITH_A_NAME_LONGER_THAN_THE_INFO_FIELD"
+
+	file := filepath.Join(t.TempDir(), "upi.txt")
+	list := "# known products\n\n" + long + "\nLOAD_ALIAS\tLOAD_UPI_TEST_ALIASED\n"
+	if err := ioutil.WriteFile(file, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadUPI(file); err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		Info string
+		Want string
+	}{
+		{long[:UPILen], long},
+		{"LOAD_ALIAS", "LOAD_UPI_TEST_ALIASED"},
+		{"LOAD_UNKNOWN", "LOAD_UNKNOWN"},
+	}
+	for _, d := range data {
+		var info [UPILen]byte
+		copy(info[:], d.Info)
+		if got := LookupUPI(info[:]); got != d.Want {
+			t.Errorf("%s: want %s, got %s", d.Info, d.Want, got)
+		}
+	}
+
+	if err := ioutil.WriteFile(file, []byte("LOAD_ALIAS\tLOAD_UPI_TEST_OTHER\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadUPI(file); err == nil {
+		t.Errorf("conflicting upi accepted")
+	}
+}
@@ -2,47 +2,177 @@ package panda
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
-func Walk(p, s string) (io.Reader, error) {
+// HRDP archive records start with their length (little endian, header
+// included). TM records then have a 6 bytes header: TagTM, coarse time (big
+// endian) and fine time (1/256s). PP records have no header.
+const (
+	RecordLengthSize = 4
+	RecordHeaderTM   = 6
+)
+
+// DefaultScanLimit is the largest record accepted by default when walking an
+// archive.
+const DefaultScanLimit = 8 << 20
+
+type WalkOption func(*walker)
+
+// SkipFlagged makes the walker ignore the records annotated with any of the
+// given flags.
+func SkipFlagged(f Flag) WalkOption {
+	return func(w *walker) {
+		w.flags = f
+	}
+}
+
+// WithScanLimit sets the size of the largest record accepted. Larger records
+// make the walker fail instead of being truncated.
+func WithScanLimit(n int) WalkOption {
+	return func(w *walker) {
+		if n > 0 {
+			w.limit = n
+		}
+	}
+}
+
+func WalkRaw(p, s string, opts ...WalkOption) (io.Reader, error) {
+	return newWalker(p, s, false, walk, opts)
+}
+
+func Walk(p, s string, opts ...WalkOption) (io.Reader, error) {
 	// if i, err := os.Stat(s); err != nil || !i.IsDir() {
 	// 	return nil, fmt.Errorf("not a directory")
 	// }
-	var skip int
+	return newWalker(p, s, true, walk, opts)
+}
+
+// WalkBetween is like Walk but only opens the files of the archive s covering
+// the interval [start, end), relying on the YYYY/DDD/HH/rt_MM_MM.dat layout.
+// Files cover 5 minutes each so packets around the bounds still have to be
+// filtered by the caller.
+func WalkBetween(p, s string, start, end time.Time, opts ...WalkOption) (io.Reader, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("invalid interval: %s < %s", end, start)
+	}
+	return newWalker(p, s, true, between(start, end), opts)
+}
+
+// WalkBetweenContext is like WalkBetween but stops walking s once ctx is done.
+func WalkBetweenContext(ctx context.Context, p, s string, start, end time.Time, opts ...WalkOption) (io.Reader, error) {
+	r, err := WalkBetween(p, s, start, end, opts...)
+	if err == nil {
+		closeOnDone(ctx, r.(io.Closer))
+	}
+	return r, err
+}
+
+// WalkContext is like Walk but stops walking s once ctx is done.
+func WalkContext(ctx context.Context, p, s string, opts ...WalkOption) (io.Reader, error) {
+	r, err := Walk(p, s, opts...)
+	if err == nil {
+		closeOnDone(ctx, r.(io.Closer))
+	}
+	return r, err
+}
+
+// Records calls fn with the offset and the content (envelope stripped) of
+// each record of the archive file f.
+func Records(p, f string, fn func(int64, []byte) error) error {
+	skip, err := recordSkip(p)
+	if err != nil {
+		return err
+	}
+	r, err := os.Open(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var at int64
+	sc := newScanner(r, skip, DefaultScanLimit, &at)
+	for sc.Scan() {
+		if err := fn(at, sc.Bytes()); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+type walkFunc func(string, <-chan struct{}) (<-chan io.ReadCloser, error)
+
+func recordSkip(p string) (int, error) {
 	switch p {
 	default:
-		return nil, fmt.Errorf("unsupported: %s", p)
+		return 0, fmt.Errorf("unsupported: %s", p)
 	case "tm":
-		skip = 10
+		return RecordLengthSize + RecordHeaderTM, nil
 	case "pp":
-		skip = 0
+		return RecordLengthSize, nil
 	case "hr", "hrd", "vmu":
-		skip = 26
+		return 26, nil
 	}
+}
 
-	done := make(chan struct{})
-	next := walk(s, done)
+func newWalker(p, s string, strip bool, f walkFunc, opts []WalkOption) (io.Reader, error) {
+	skip, err := recordSkip(p)
+	if err != nil {
+		return nil, err
+	}
+	if !strip {
+		skip = 0
+	}
 
-	return &walker{
-		next: next,
-		done: done,
-		skip: skip,
-	}, nil
+	done := make(chan struct{})
+	next, err := f(s, done)
+	if err != nil {
+		return nil, err
+	}
+	w := &walker{
+		next:  next,
+		done:  done,
+		skip:  skip,
+		limit: DefaultScanLimit,
+	}
+	for _, o := range opts {
+		o(w)
+	}
+	if err := w.start(p, strip); err != nil {
+		close(done)
+		return nil, err
+	}
+	return w, nil
 }
 
 type walker struct {
-	sc   *bufio.Scanner
-	rc   io.ReadCloser
-	skip int
+	sc    *bufio.Scanner
+	rc    io.ReadCloser
+	skip  int
+	limit int
+
+	flags Flag
+	notes Annotations
+	at    int64
+	file  string
 
 	next <-chan io.ReadCloser
 
+	workers int
+	merge   bool
+	files   <-chan chan record
+	curr    chan record
+
+	// mu guards rc, replaced by read while Close can be called at any time
+	// from another goroutine.
+	mu   sync.Mutex
 	once sync.Once
 	done chan struct{}
 }
@@ -56,30 +186,79 @@ func (w *walker) Read(b []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		if len(b) < len(p) {
+			return 0, ErrTooShort
+		}
 		return copy(b, p), nil
 	}
 }
 
 func (w *walker) read() ([]byte, error) {
+	if w.files != nil {
+		return w.readFiles()
+	}
 	if w.sc == nil {
-		r, ok := <-w.next
-		if !ok {
+		var r io.ReadCloser
+		select {
+		case x, ok := <-w.next:
+			if !ok {
+				return nil, ErrDone
+			}
+			r = x
+		case <-w.done:
+			return nil, ErrDone
+		}
+		if !w.setFile(r) {
+			r.Close()
 			return nil, ErrDone
 		}
-		w.rc = r
-		w.sc = bufio.NewScanner(w.rc)
-		w.sc.Buffer(make([]byte, 4096), 8<<20)
-		w.sc.Split(scan(w.skip))
+		w.notes, w.file = nil, ""
+		if f, ok := r.(interface{ Name() string }); ok {
+			w.file = f.Name()
+			if w.flags != 0 {
+				w.notes, _ = LoadAnnotations(w.file)
+			}
+		}
+		w.sc = newScanner(r, w.skip, w.limit, &w.at)
 	}
-	if !w.sc.Scan() {
-		w.rc.Close()
-		if err := w.sc.Err(); err != nil {
-			return nil, err
+	for w.sc.Scan() {
+		if w.notes[w.at]&w.flags == 0 {
+			return w.sc.Bytes(), nil
 		}
-		w.sc = nil
-		return w.read()
 	}
-	return w.sc.Bytes(), nil
+	if !w.setFile(nil) {
+		// the file was closed under the scanner: its error is not worth
+		// reporting.
+		return nil, ErrDone
+	}
+	if err := w.sc.Err(); err != nil {
+		return nil, err
+	}
+	w.sc = nil
+	return w.read()
+}
+
+// setFile closes the file being read and replaces it by rc. It returns false
+// when the walker is already closed.
+func (w *walker) setFile(rc io.ReadCloser) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.done:
+		return false
+	default:
+	}
+	if w.rc != nil {
+		w.rc.Close()
+	}
+	w.rc = rc
+	return true
+}
+
+// Position gives the file being read and the offset of the last record read
+// from it.
+func (w *walker) Position() (string, int64) {
+	return w.file, w.at
 }
 
 func (w *walker) Close() error {
@@ -88,6 +267,8 @@ func (w *walker) Close() error {
 		ok  bool
 	)
 	w.once.Do(func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
 		if w.rc != nil {
 			err = w.rc.Close()
 		}
@@ -101,26 +282,81 @@ func (w *walker) Close() error {
 	return ErrDone
 }
 
-func scan(s int) bufio.SplitFunc {
-	if s == 0 {
-		s = 4
+func newScanner(r io.Reader, s, limit int, at *int64) *bufio.Scanner {
+	n := 4096
+	if limit < n {
+		n = limit
 	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, n), limit)
+	sc.Split(scan(s, limit, at))
+	return sc
+}
+
+// scan splits HRDP records and stores the offset of the last record read in
+// at.
+func scan(s, limit int, at *int64) bufio.SplitFunc {
+	var offset int64
 	return func(buf []byte, ateof bool) (int, []byte, error) {
 		if len(buf) < 4 {
 			return 0, nil, nil
 		}
-		length := int(binary.LittleEndian.Uint32(buf[:4]) + 4)
+		length := int(binary.LittleEndian.Uint32(buf[:RecordLengthSize])) + RecordLengthSize
+		switch {
+		case length < s:
+			return 0, nil, fmt.Errorf("record at %d too short (%d bytes)", offset, length)
+		case length > limit:
+			return 0, nil, fmt.Errorf("record at %d too large (%d bytes, limit %d)", offset, length, limit)
+		}
 		if len(buf) < length {
 			return 0, nil, nil
 		}
 		b := make([]byte, length)
 		copy(b, buf[:length])
 
+		*at, offset = offset, offset+int64(length)
 		return length, b[s:], nil
 	}
 }
 
-func walk(s string, done <-chan struct{}) <-chan io.ReadCloser {
+// ArchiveSlot is the time covered by each file of an HRDP archive.
+const ArchiveSlot = time.Minute * 5
+
+// ArchiveFile gives the path of the file of the archive s holding the records
+// received at t.
+func ArchiveFile(s string, t time.Time) string {
+	t = t.UTC().Truncate(ArchiveSlot)
+	y, d, h := t.Year(), t.YearDay(), t.Hour()
+	n := fmt.Sprintf("rt_%02d_%02d.dat", t.Minute(), t.Minute()+4)
+	return filepath.Join(s, fmt.Sprintf("%04d", y), fmt.Sprintf("%03d", d), fmt.Sprintf("%02d", h), n)
+}
+
+func between(start, end time.Time) walkFunc {
+	return func(s string, done <-chan struct{}) (<-chan io.ReadCloser, error) {
+		if i, err := os.Stat(s); err != nil || !i.IsDir() {
+			return nil, fmt.Errorf("%s: not a directory", s)
+		}
+		q := make(chan io.ReadCloser)
+		go func() {
+			defer close(q)
+			for t := start.Truncate(ArchiveSlot); t.Before(end); t = t.Add(ArchiveSlot) {
+				f, err := os.Open(ArchiveFile(s, t))
+				if err != nil {
+					continue
+				}
+				select {
+				case <-done:
+					f.Close()
+					return
+				case q <- f:
+				}
+			}
+		}()
+		return q, nil
+	}
+}
+
+func walk(s string, done <-chan struct{}) (<-chan io.ReadCloser, error) {
 	q := make(chan io.ReadCloser)
 	go func() {
 		defer close(q)
@@ -128,7 +364,7 @@ func walk(s string, done <-chan struct{}) <-chan io.ReadCloser {
 			if err != nil {
 				return err
 			}
-			if i.IsDir() {
+			if i.IsDir() || filepath.Ext(p) == AnnotationExt {
 				return nil
 			}
 			f, err := os.Open(p)
@@ -144,5 +380,5 @@ func walk(s string, done <-chan struct{}) <-chan io.ReadCloser {
 			}
 		})
 	}()
-	return q
+	return q, nil
 }
@@ -0,0 +1,40 @@
+package panda
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// TestWalkContextCancel cancels the walk of an archive while its files are
+// being read; run with -race to check Close against the reads.
+func TestWalkContextCancel(t *testing.T) {
+	d := t.TempDir()
+	for i := 0; i < 256; i++ {
+		writeRecords(t, filepath.Join(d, fmt.Sprintf("rt_%03d.dat", i)), i)
+	}
+	for n := 0; n < 256; n += 7 {
+		ctx, cancel := context.WithCancel(context.Background())
+		r, err := WalkContext(ctx, "tm", d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs := NewReaderContext(ctx, r, DecodeTM())
+		for i := 0; i < n; i++ {
+			if _, err := rs.ReadPacket(); err != nil {
+				t.Fatalf("packet %d: %s", i, err)
+			}
+		}
+		cancel()
+		for {
+			_, err := rs.ReadPacket()
+			if err == ErrDone {
+				break
+			}
+			if _, ok := err.(*DecodeError); ok {
+				t.Fatalf("read %d packets: error after cancel: %s", n, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package panda
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func Watch(p, s string, opts ...WalkOption) (io.Reader, error) {
+	return newWalker(p, s, true, watch, opts)
+}
+
+func WatchRaw(p, s string, opts ...WalkOption) (io.Reader, error) {
+	return newWalker(p, s, false, watch, opts)
+}
+
+// WatchIdle is the time after which the last file created, left unmodified,
+// is read even if no other file follows it. It is then followed as it grows
+// until the next file is created.
+var WatchIdle = time.Minute
+
+// tailPoll is the interval at which a followed file is checked for new data.
+const tailPoll = time.Second
+
+// watch emits files created under s (and its sub directories created later)
+// once they are completed. HRDP writes one file at a time so a file is
+// considered completed as soon as the next one is created or when the watcher
+// stops. A file left unmodified for WatchIdle is emitted before, the data
+// appended to it later being read as well. Files being named after their time
+// (YYYY/DDD/HH/rt_MM_MM.dat), the ones not coming after the last file seen are
+// ignored.
+func watch(s string, done <-chan struct{}) (<-chan io.ReadCloser, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := watchDirs(w, s); err != nil {
+		w.Close()
+		return nil, err
+	}
+	q := make(chan io.ReadCloser)
+	go func() {
+		defer w.Close()
+		defer close(q)
+
+		var (
+			pending, last string
+			// followed is the pending file when it was emitted before being
+			// completed.
+			followed *tail
+		)
+		send := func(n string, follow bool) bool {
+			f, err := os.Open(n)
+			if err != nil {
+				return true
+			}
+			var rc io.ReadCloser = f
+			if follow {
+				followed = newTail(f)
+				rc = followed
+			}
+			select {
+			case <-done:
+				f.Close()
+				return false
+			case q <- rc:
+				return true
+			}
+		}
+		// complete marks the pending file as completed, emitting it when it
+		// was not yet.
+		complete := func() bool {
+			if followed != nil {
+				followed.complete()
+				followed = nil
+				return true
+			}
+			return len(pending) == 0 || send(pending, false)
+		}
+		emit := func(n string) bool {
+			if n <= last || filepath.Ext(n) == AnnotationExt {
+				return true
+			}
+			last = n
+			if !complete() {
+				return false
+			}
+			pending = n
+			return true
+		}
+		defer func() {
+			if followed != nil {
+				followed.complete()
+			} else if len(pending) > 0 {
+				send(pending, false)
+			}
+		}()
+		tick := time.NewTicker(WatchIdle / 4)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				if len(pending) == 0 || followed != nil {
+					break
+				}
+				if i, err := os.Stat(pending); err == nil && time.Since(i.ModTime()) < WatchIdle {
+					break
+				}
+				if !send(pending, true) {
+					return
+				}
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				switch {
+				case e.Op&fsnotify.Create == fsnotify.Create:
+					i, err := os.Stat(e.Name)
+					if err != nil {
+						break
+					}
+					if !i.IsDir() {
+						if !emit(e.Name) {
+							return
+						}
+						break
+					}
+					// files created before the directory is watched give no
+					// event.
+					fs, _ := watchDirs(w, e.Name)
+					for _, f := range fs {
+						if !emit(f) {
+							return
+						}
+					}
+				case e.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+					if pending == e.Name {
+						if followed != nil {
+							followed.complete()
+							followed = nil
+						}
+						pending = ""
+					}
+				}
+			case _, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return q, nil
+}
+
+// watchDirs adds s and its sub directories to w and gives the files they
+// hold, in lexical order.
+func watchDirs(w *fsnotify.Watcher, s string) ([]string, error) {
+	var fs []string
+	err := filepath.Walk(s, func(p string, i os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !i.IsDir() {
+			fs = append(fs, p)
+			return nil
+		}
+		return w.Add(p)
+	})
+	return fs, err
+}
+
+// tail reads a file that can still grow: at its end, it waits for more data
+// until the file is completed or closed.
+type tail struct {
+	*os.File
+
+	done   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newTail(f *os.File) *tail {
+	return &tail{
+		File:   f,
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+func (t *tail) Read(bs []byte) (int, error) {
+	for {
+		n, err := t.File.Read(bs)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		select {
+		case <-t.done:
+			return t.File.Read(bs)
+		case <-t.closed:
+			return 0, io.EOF
+		case <-time.After(tailPoll):
+		}
+	}
+}
+
+// complete makes Read give io.EOF once the data of the file are read.
+func (t *tail) complete() {
+	close(t.done)
+}
+
+func (t *tail) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return t.File.Close()
+}
@@ -0,0 +1,133 @@
+package panda
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testRecord gives the archive record of a TM packet of the given sequence
+// counter.
+func testRecord(seq int) []byte {
+	p := testSequence(0x386, seq)
+	p.Data = []byte{1, 2, 3, 4}
+	p.Length = uint16(ESALength + len(p.Data) - 1)
+	bs, _ := p.Bytes()
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(bs)+RecordHeaderTM))
+	buf.Write([]byte{TagTM, 0, 0, 0, 0, 0})
+	buf.Write(bs)
+	return buf.Bytes()
+}
+
+func writeRecords(t *testing.T, file string, seqs ...int) {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, s := range seqs {
+		buf.Write(testRecord(s))
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	defer func(d time.Duration) { WatchIdle = d }(WatchIdle)
+	WatchIdle = 200 * time.Millisecond
+
+	root, tmp := t.TempDir(), t.TempDir()
+	r, err := Watch("tm", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.(interface{ Close() error }).Close()
+
+	queue := make(chan int)
+	go func() {
+		defer close(queue)
+		rs := NewReader(r, DecodeTM())
+		for {
+			p, err := rs.Read()
+			if err != nil {
+				return
+			}
+			queue <- p.(Telemetry).Sequence()
+		}
+	}()
+
+	// the files of an hour directory created before it is watched.
+	hour := filepath.Join(tmp, "00")
+	os.Mkdir(hour, 0755)
+	writeRecords(t, filepath.Join(hour, "rt_00_04.dat"), 1, 2)
+	writeRecords(t, filepath.Join(hour, "rt_05_09.dat"), 3)
+	if err := os.Rename(hour, filepath.Join(root, "00")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	writeRecords(t, filepath.Join(root, "00", "rt_10_14.dat"), 4, 5)
+
+	for want := 1; want <= 5; want++ {
+		select {
+		case got, ok := <-queue:
+			if !ok {
+				t.Fatalf("watcher stopped before packet %d", want)
+			}
+			if got != want {
+				t.Fatalf("want packet %d, got %d", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("packet %d not received", want)
+		}
+	}
+}
+
+func TestWatchAppend(t *testing.T) {
+	defer func(d time.Duration) { WatchIdle = d }(WatchIdle)
+	WatchIdle = 200 * time.Millisecond
+
+	root := t.TempDir()
+	r, err := Watch("tm", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.(interface{ Close() error }).Close()
+
+	queue := make(chan int)
+	go func() {
+		defer close(queue)
+		rs := NewReader(r, DecodeTM())
+		for {
+			p, err := rs.Read()
+			if err != nil {
+				return
+			}
+			queue <- p.(Telemetry).Sequence()
+		}
+	}()
+
+	file := filepath.Join(root, "rt_00_04.dat")
+	writeRecords(t, file, 1, 2)
+	expect(t, queue, 1, 2)
+
+	// the file, read once idle, is appended to before the next one comes.
+	time.Sleep(2 * WatchIdle)
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(testRecord(3))
+	f.Write(testRecord(4)[:10])
+	time.Sleep(50 * time.Millisecond)
+	f.Write(testRecord(4)[10:])
+	f.Close()
+	expect(t, queue, 3, 4)
+
+	writeRecords(t, filepath.Join(root, "rt_05_09.dat"), 5)
+	writeRecords(t, filepath.Join(root, "rt_10_14.dat"), 6)
+	expect(t, queue, 5, 6)
+}